@@ -0,0 +1,334 @@
+package hangups
+
+import (
+	"strconv"
+	"strings"
+
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// AttachmentType is the normalised kind of a message attachment
+type AttachmentType int
+
+// known attachment types
+const (
+	AttachmentUnknown AttachmentType = iota
+	AttachmentPhoto
+	AttachmentVideo
+	AttachmentLocation
+	AttachmentWebPage
+	AttachmentSticker
+)
+
+// String returns the name of the attachment type
+func (t AttachmentType) String() string {
+	switch t {
+	case AttachmentPhoto:
+		return "photo"
+	case AttachmentVideo:
+		return "video"
+	case AttachmentLocation:
+		return "location"
+	case AttachmentWebPage:
+		return "webpage"
+	case AttachmentSticker:
+		return "sticker"
+	default:
+		return "unknown"
+	}
+}
+
+// Attachment is a normalised view of an EMEmbedClientItem, V1 and V2 embeds produce the same values.
+// Only the fields relevant to Type are filled in.
+type Attachment struct {
+	Type        AttachmentType
+	ID          string // photo id or place id
+	Name        string
+	Description string
+	URL         string // page of the item, e.g. the album or maps page
+	ImageURL    string // preview or thumbnail image
+	ContentURL  string // original media, photo or video content
+	DownloadURL string // direct download url if the server provided one
+	Width       int    // in pixels, 0 if unknown
+	Height      int    // in pixels, 0 if unknown
+	OwnerID     string // obfuscated gaia id of the owner of a photo
+	AlbumID     string
+	Latitude    float64
+	Longitude   float64
+	Address     string // formatted postal address of a location
+	MapURL      string
+
+	Embed *hangouts.EMEmbedClientItem // the raw embed item
+}
+
+// HasLocation returns true if the attachment carries geo coordinates
+func (a *Attachment) HasLocation() bool {
+	return a.Latitude != 0 || a.Longitude != 0
+}
+
+// hasItemType checks if an embed item is tagged with one of the types
+func hasItemType(item *hangouts.EMEmbedClientItem, types ...hangouts.EMItemType) bool {
+	for _, itemType := range item.GetTypeArray() {
+		for _, t := range types {
+			if itemType == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// firstNonEmpty returns the first non empty string
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseDimension parses the string dimensions used by embeds, e.g. "1024" or "1024px"
+func parseDimension(v string) int {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(v), "px"))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// normaliseURL adds the https scheme to protocol relative urls such as "//lh3.googleusercontent.com/..."
+func normaliseURL(v string) string {
+	if strings.HasPrefix(v, "//") {
+		return "https:" + v
+	}
+	return v
+}
+
+// formatAddress joins the parts of a postal address
+func formatAddress(parts ...string) string {
+	nonEmpty := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return strings.Join(nonEmpty, ", ")
+}
+
+// ParseAttachment normalises an embed item, it returns an attachment of type AttachmentUnknown
+// for embeds which are not recognised so that the raw item is still available
+func ParseAttachment(item *hangouts.EMEmbedClientItem) *Attachment {
+	attachment := &Attachment{Type: AttachmentUnknown, Embed: item}
+	if item == nil {
+		return attachment
+	}
+
+	switch {
+	case item.GetPlusPhoto() != nil:
+		parsePlusPhoto(attachment, item.GetPlusPhoto())
+	case item.GetPlusPhotoV2() != nil:
+		parsePlusPhotoV2(attachment, item.GetPlusPhotoV2())
+	case item.GetImageObject() != nil:
+		parseImageObject(attachment, item.GetImageObject())
+	case item.GetImageObjectV2() != nil:
+		parseImageObjectV2(attachment, item.GetImageObjectV2())
+	case item.GetPlace() != nil:
+		parsePlace(attachment, item.GetPlace())
+	case item.GetPlaceV2() != nil:
+		parsePlaceV2(attachment, item.GetPlaceV2())
+	case item.GetGeoCoordinates() != nil:
+		attachment.Type = AttachmentLocation
+		parseGeoCoordinates(attachment, item.GetGeoCoordinates())
+	case item.GetGeoCoordinatesV2() != nil:
+		attachment.Type = AttachmentLocation
+		parseGeoCoordinatesV2(attachment, item.GetGeoCoordinatesV2())
+	case item.GetThingV2() != nil:
+		parseThingV2(attachment, item.GetThingV2())
+	}
+
+	if attachment.ID == "" {
+		attachment.ID = item.GetIdP()
+	}
+
+	// stickers are regular photos tagged with the sticker item type
+	if hasItemType(item, hangouts.EMItemType_StickerV2) {
+		attachment.Type = AttachmentSticker
+	}
+
+	return attachment
+}
+
+func parsePlusPhoto(attachment *Attachment, photo *hangouts.EMPlusPhoto) {
+	attachment.Type = AttachmentPhoto
+	if photo.GetIsVideo() || photo.GetMediaType() == hangouts.EMPlusPhoto_PhotoMediaType_Video {
+		attachment.Type = AttachmentVideo
+	}
+	thumbnail := photo.GetThumbnail()
+	attachment.ID = photo.GetPhotoId()
+	attachment.Name = photo.GetName()
+	attachment.URL = normaliseURL(photo.GetURL())
+	attachment.ImageURL = normaliseURL(firstNonEmpty(thumbnail.GetImageURL(), thumbnail.GetThumbnailURL()))
+	attachment.ContentURL = normaliseURL(firstNonEmpty(photo.GetOriginalContentURL(), thumbnail.GetContentURL(), thumbnail.GetImageURL()))
+	attachment.DownloadURL = normaliseURL(photo.GetDownloadURL())
+	attachment.OwnerID = photo.GetOwnerObfuscatedId()
+	attachment.AlbumID = photo.GetAlbumId()
+	attachment.Width = int(thumbnail.GetWidthPx())
+	attachment.Height = int(thumbnail.GetHeightPx())
+	if attachment.Width == 0 {
+		attachment.Width = firstDimension(photo.GetMaxWidth(), thumbnail.GetWidth())
+	}
+	if attachment.Height == 0 {
+		attachment.Height = firstDimension(photo.GetMaxHeight(), thumbnail.GetHeight())
+	}
+}
+
+func parsePlusPhotoV2(attachment *Attachment, photo *hangouts.EMPlusPhotoV2) {
+	attachment.Type = AttachmentPhoto
+	if photo.GetEmbedURL() != "" || photo.GetDuration() != "" {
+		attachment.Type = AttachmentVideo
+	}
+	attachment.ID = photo.GetPhotoId()
+	attachment.Name = photo.GetName()
+	attachment.Description = photo.GetDescriptionP()
+	attachment.URL = normaliseURL(photo.GetURL())
+	attachment.ImageURL = normaliseURL(firstNonEmpty(photo.GetImageURL(), photo.GetProxiedImage().GetImageURL()))
+	attachment.ContentURL = normaliseURL(firstNonEmpty(photo.GetOriginalContentURL(), photo.GetContentURL(), photo.GetImageURL()))
+	attachment.OwnerID = photo.GetOwnerObfuscatedId()
+	attachment.AlbumID = photo.GetAlbumId()
+	attachment.Width = int(photo.GetWidthPx())
+	attachment.Height = int(photo.GetHeightPx())
+	if attachment.Width == 0 {
+		attachment.Width = firstDimension(photo.GetMaxWidth(), photo.GetWidth())
+	}
+	if attachment.Height == 0 {
+		attachment.Height = firstDimension(photo.GetMaxHeight(), photo.GetHeight())
+	}
+}
+
+func parseImageObject(attachment *Attachment, image *hangouts.EMImageObject) {
+	attachment.Type = AttachmentPhoto
+	attachment.Name = image.GetName()
+	attachment.Description = image.GetDescriptionP()
+	attachment.URL = normaliseURL(image.GetURL())
+	attachment.ImageURL = normaliseURL(firstNonEmpty(image.GetThumbnailURL(), image.GetImageURL()))
+	attachment.ContentURL = normaliseURL(firstNonEmpty(image.GetContentURL(), image.GetImageURL()))
+	attachment.Width = int(image.GetWidthPx())
+	attachment.Height = int(image.GetHeightPx())
+	if attachment.Width == 0 {
+		attachment.Width = parseDimension(image.GetWidth())
+	}
+	if attachment.Height == 0 {
+		attachment.Height = parseDimension(image.GetHeight())
+	}
+}
+
+func parseImageObjectV2(attachment *Attachment, image *hangouts.EMImageObjectV2) {
+	attachment.Type = AttachmentPhoto
+	if image.GetEmbedURL() != "" || image.GetDuration() != "" {
+		attachment.Type = AttachmentVideo
+	}
+	attachment.Name = image.GetName()
+	attachment.Description = image.GetDescriptionP()
+	attachment.URL = normaliseURL(image.GetURL())
+	attachment.ImageURL = normaliseURL(firstNonEmpty(image.GetImageURL(), image.GetProxiedImage().GetImageURL()))
+	attachment.ContentURL = normaliseURL(firstNonEmpty(image.GetContentURL(), image.GetImageURL()))
+	attachment.Width = int(image.GetWidthPx())
+	attachment.Height = int(image.GetHeightPx())
+	if attachment.Width == 0 {
+		attachment.Width = parseDimension(image.GetWidth())
+	}
+	if attachment.Height == 0 {
+		attachment.Height = parseDimension(image.GetHeight())
+	}
+}
+
+func parsePlace(attachment *Attachment, place *hangouts.EMPlace) {
+	attachment.Type = AttachmentLocation
+	attachment.ID = place.GetPlaceId()
+	attachment.Name = place.GetName()
+	attachment.Description = place.GetDescriptionP()
+	attachment.URL = normaliseURL(place.GetURL())
+	attachment.ImageURL = normaliseURL(place.GetImageURL())
+	attachment.MapURL = normaliseURL(place.GetMapURL())
+	if geo := place.GetGeo(); geo != nil {
+		parseGeoCoordinates(attachment, geo)
+	}
+	if address := place.GetAddress(); address != nil {
+		attachment.Address = firstNonEmpty(address.GetName(), formatAddress(address.GetStreetAddress(),
+			address.GetAddressLocality(), address.GetAddressRegion(), address.GetPostalCode(), address.GetAddressCountry()))
+	}
+}
+
+func parsePlaceV2(attachment *Attachment, place *hangouts.EMPlaceV2) {
+	attachment.Type = AttachmentLocation
+	attachment.ID = place.GetPlaceId()
+	attachment.Name = place.GetName()
+	attachment.Description = place.GetDescriptionP()
+	attachment.URL = normaliseURL(place.GetURL())
+	attachment.ImageURL = normaliseURL(firstNonEmpty(place.GetImageURL(), place.GetProxiedImage().GetImageURL()))
+	attachment.MapURL = normaliseURL(place.GetMapURL())
+	if geo := place.GetGeo().GetGeoCoordinatesV2(); geo != nil {
+		parseGeoCoordinatesV2(attachment, geo)
+	}
+	if address := place.GetAddress().GetPostalAddressV2(); address != nil {
+		attachment.Address = firstNonEmpty(address.GetName(), formatAddress(address.GetStreetAddress(),
+			address.GetAddressLocality(), address.GetAddressRegion(), address.GetPostalCode(), address.GetAddressCountry()))
+	}
+}
+
+func parseGeoCoordinates(attachment *Attachment, geo *hangouts.EMGeoCoordinates) {
+	attachment.Latitude = geo.GetLatitude()
+	attachment.Longitude = geo.GetLongitude()
+	attachment.Name = firstNonEmpty(attachment.Name, geo.GetName())
+	attachment.URL = firstNonEmpty(attachment.URL, normaliseURL(geo.GetURL()))
+	attachment.ImageURL = firstNonEmpty(attachment.ImageURL, normaliseURL(geo.GetImageURL()))
+}
+
+func parseGeoCoordinatesV2(attachment *Attachment, geo *hangouts.EMGeoCoordinatesV2) {
+	attachment.Latitude = geo.GetLatitude()
+	attachment.Longitude = geo.GetLongitude()
+	attachment.Name = firstNonEmpty(attachment.Name, geo.GetName())
+	attachment.URL = firstNonEmpty(attachment.URL, normaliseURL(geo.GetURL()))
+	attachment.ImageURL = firstNonEmpty(attachment.ImageURL, normaliseURL(geo.GetImageURL()))
+}
+
+// parseThingV2 handles generic things, which is how link previews are sent.
+// The V1 EMWebPage and EMThing messages have no extension in EMEmbedClientItem and never appear on the wire.
+func parseThingV2(attachment *Attachment, thing *hangouts.EMThingV2) {
+	attachment.Type = AttachmentWebPage
+	if hasItemType(attachment.Embed, hangouts.EMItemType_VideoObject, hangouts.EMItemType_VideoObjectV2) {
+		attachment.Type = AttachmentVideo
+	}
+	attachment.Name = thing.GetName()
+	attachment.Description = firstNonEmpty(thing.GetDescriptionP(), thing.GetDescriptionTruncated())
+	attachment.URL = normaliseURL(firstNonEmpty(thing.GetURL(), thing.GetDestinationURL()))
+	attachment.ImageURL = normaliseURL(firstNonEmpty(thing.GetImageURL(), thing.GetProxiedImage().GetImageURL()))
+}
+
+// firstDimension returns the first parsable dimension
+func firstDimension(values ...string) int {
+	for _, v := range values {
+		if n := parseDimension(v); n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// GetAttachments returns the normalised attachments of a message content
+func GetAttachments(content *hangouts.MessageContent) []*Attachment {
+	attachments := make([]*Attachment, 0, len(content.GetAttachment()))
+	for _, attachment := range content.GetAttachment() {
+		if attachment.GetEmbedItem() == nil {
+			continue
+		}
+		attachments = append(attachments, ParseAttachment(attachment.GetEmbedItem()))
+	}
+	return attachments
+}
+
+// EventAttachments returns the normalised attachments of a chat message event
+func EventAttachments(event *hangouts.Event) []*Attachment {
+	return GetAttachments(event.GetChatMessage().GetMessageContent())
+}
@@ -0,0 +1,337 @@
+// Package bot is a framework for hangouts bots: commands such as "!deploy prod" are routed to
+// handlers wrapped in middleware, handlers reply through a Context bound to the conversation and sender.
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// defaultPrefix starts commands when Bot.Prefix is not set
+const defaultPrefix = "!"
+
+// HandlerFunc handles a message, a returned error is replied to the sender
+type HandlerFunc func(ctx *Context) error
+
+// Middleware wraps a handler, e.g. to check permissions or log commands
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Command is a command of the bot
+type Command struct {
+	Name        string   // invoked as Prefix + Name
+	Aliases     []string // other names of the command
+	Usage       string   // arguments shown in the help, e.g. "<env> [version]"
+	Description string
+	MinArgs     int // the command is rejected with its usage below this
+	MaxArgs     int // the command is rejected with its usage above this, -1 for no limit
+	Hidden      bool
+	Handler     HandlerFunc
+	Middleware  []Middleware // applied after the middleware of the bot
+}
+
+// help returns the usage line of the command
+func (c *Command) help(prefix string) string {
+	usage := prefix + c.Name
+	if c.Usage != "" {
+		usage += " " + c.Usage
+	}
+	if c.Description != "" {
+		usage += " - " + c.Description
+	}
+	return usage
+}
+
+// Bot routes the messages of a Client to command handlers.
+// Messages of the bot's own user are ignored.
+type Bot struct {
+	Client *hangups.Client
+	Prefix string // starts commands, "!" if not set
+	// Name makes the bot react to mentions: "@name deploy prod", "name: deploy prod" and "name, help"
+	// run commands without the prefix
+	Name string
+
+	// OnMessage is called for messages which are not commands, optional
+	OnMessage HandlerFunc
+	// OnMention is called for mentions which are not commands, optional
+	OnMention HandlerFunc
+	// OnError is called for errors of the event source and of replies, optional
+	OnError func(err error)
+
+	mu         sync.RWMutex
+	commands   map[string]*Command // by name and alias
+	middleware []Middleware
+	poller     *hangups.Poller
+	names      map[string]string // gaia id -> display name from entity lookups
+}
+
+// New creates a bot for client with the built-in help command
+func New(client *hangups.Client) *Bot {
+	b := &Bot{
+		Client:   client,
+		commands: make(map[string]*Command),
+		names:    make(map[string]string),
+	}
+	b.Handle(&Command{
+		Name:        "help",
+		Usage:       "[command]",
+		Description: "list the commands or describe one",
+		MaxArgs:     1,
+		Handler:     b.help,
+	})
+	return b
+}
+
+// Use adds middleware applied to every handler, in the order added
+func (b *Bot) Use(middleware ...Middleware) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.middleware = append(b.middleware, middleware...)
+}
+
+// Handle registers a command, replacing any command of the same name or alias
+func (b *Bot) Handle(command *Command) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.commands[strings.ToLower(command.Name)] = command
+	for _, alias := range command.Aliases {
+		b.commands[strings.ToLower(alias)] = command
+	}
+}
+
+// HandleFunc registers a command without arguments limits
+func (b *Bot) HandleFunc(name, description string, handler HandlerFunc) {
+	b.Handle(&Command{Name: name, Description: description, MaxArgs: -1, Handler: handler})
+}
+
+// Commands returns the registered commands sorted by name, hidden commands excluded
+func (b *Bot) Commands() []*Command {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	seen := make(map[*Command]bool)
+	commands := make([]*Command, 0)
+	for _, command := range b.commands {
+		if !seen[command] && !command.Hidden {
+			seen[command] = true
+			commands = append(commands, command)
+		}
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	return commands
+}
+
+// prefix returns the command prefix
+func (b *Bot) prefix() string {
+	if b.Prefix == "" {
+		return defaultPrefix
+	}
+	return b.Prefix
+}
+
+// SelfID returns the gaia id of the bot, it is known once Run has started
+func (b *Bot) SelfID() string {
+	if b.poller == nil {
+		return ""
+	}
+	return b.poller.SelfID()
+}
+
+// Run receives events until ctx is done
+func (b *Bot) Run(ctx context.Context) error {
+	b.poller = hangups.NewPoller(b.Client)
+	b.poller.OnError = b.OnError
+	return b.poller.Run(ctx, func(conversation *hangouts.Conversation, event *hangouts.Event) {
+		b.HandleEvent(ctx, conversation, event)
+	})
+}
+
+// HandleEvent dispatches an event, Run calls it for every new event.
+// It can be called directly to feed the bot from another event source.
+func (b *Bot) HandleEvent(ctx context.Context, conversation *hangouts.Conversation, event *hangouts.Event) {
+	message := hangups.NewMessage(event)
+	if message == nil || message.SenderID() == b.SelfID() {
+		return
+	}
+
+	c := &Context{
+		Context:      ctx,
+		Bot:          b,
+		Message:      message,
+		Conversation: conversation,
+		Sender:       b.sender(conversation, message.SenderID()),
+	}
+
+	handler, command := b.route(c)
+	if handler == nil {
+		return
+	}
+	c.Command = command
+
+	if err := b.wrap(handler, command)(c); err != nil {
+		if replyErr := c.Reply(err.Error()); replyErr != nil && b.OnError != nil {
+			b.OnError(replyErr)
+		}
+	}
+}
+
+// route finds the handler of a message and parses the arguments of commands
+func (b *Bot) route(c *Context) (HandlerFunc, *Command) {
+	text := strings.TrimSpace(c.Message.Text())
+	if text == "" {
+		if b.OnMessage != nil {
+			return b.OnMessage, nil
+		}
+		return nil, nil
+	}
+
+	line, isCommand := "", false
+	if strings.HasPrefix(text, b.prefix()) {
+		line, isCommand = strings.TrimPrefix(text, b.prefix()), true
+	} else if rest, ok := b.mention(text); ok {
+		c.Mentioned = true
+		line, isCommand = strings.TrimPrefix(rest, b.prefix()), true
+	}
+
+	if isCommand {
+		name, rawArgs := splitCommand(line)
+		b.mu.RLock()
+		command, ok := b.commands[strings.ToLower(name)]
+		b.mu.RUnlock()
+		if ok {
+			c.RawArgs = rawArgs
+			args, err := ParseArgs(rawArgs)
+			if err == nil {
+				err = checkArgs(command, len(args))
+			}
+			if err != nil {
+				usage := fmt.Sprintf("%v, usage: %s", err, command.help(b.prefix()))
+				return func(c *Context) error { return c.Reply(usage) }, command
+			}
+			c.Args = args
+			return command.Handler, command
+		}
+	}
+
+	if c.Mentioned && b.OnMention != nil {
+		return b.OnMention, nil
+	}
+	if b.OnMessage != nil {
+		return b.OnMessage, nil
+	}
+	return nil, nil
+}
+
+// mention checks if a message addresses the bot by name and returns the rest of it
+func (b *Bot) mention(text string) (string, bool) {
+	if b.Name == "" {
+		return "", false
+	}
+	lower := strings.ToLower(text)
+	name := strings.ToLower(b.Name)
+	for _, form := range []string{"@" + name, name + ":", name + ","} {
+		if strings.HasPrefix(lower, form) {
+			rest := text[len(form):]
+			if rest == "" || rest[0] == ' ' || strings.HasSuffix(form, ":") || strings.HasSuffix(form, ",") {
+				return strings.TrimLeft(strings.TrimSpace(rest), ":,"), true
+			}
+		}
+	}
+	return "", false
+}
+
+// splitCommand splits a command line into the command name and its raw arguments
+func splitCommand(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if i := strings.IndexAny(line, " \t\n"); i >= 0 {
+		return line[:i], strings.TrimSpace(line[i+1:])
+	}
+	return line, ""
+}
+
+// checkArgs validates the number of arguments of a command
+func checkArgs(command *Command, count int) error {
+	if count < command.MinArgs {
+		return errors.New("not enough arguments")
+	}
+	if command.MaxArgs >= 0 && count > command.MaxArgs {
+		return errors.New("too many arguments")
+	}
+	return nil
+}
+
+// wrap applies the middleware of the bot and of the command, the first added runs first
+func (b *Bot) wrap(handler HandlerFunc, command *Command) HandlerFunc {
+	b.mu.RLock()
+	middleware := append([]Middleware{}, b.middleware...)
+	b.mu.RUnlock()
+	if command != nil {
+		middleware = append(middleware, command.Middleware...)
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// help is the handler of the help command
+func (b *Bot) help(c *Context) error {
+	if len(c.Args) == 1 {
+		b.mu.RLock()
+		command, ok := b.commands[strings.ToLower(strings.TrimPrefix(c.Args[0], b.prefix()))]
+		b.mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("unknown command %s", c.Args[0])
+		}
+		help := command.help(b.prefix())
+		if len(command.Aliases) > 0 {
+			help += "\naliases: " + strings.Join(command.Aliases, ", ")
+		}
+		return c.Reply(help)
+	}
+
+	lines := make([]string, 0)
+	for _, command := range b.Commands() {
+		lines = append(lines, command.help(b.prefix()))
+	}
+	return c.Reply(strings.Join(lines, "\n"))
+}
+
+// sender returns the sender of a message with their display name
+func (b *Bot) sender(conversation *hangouts.Conversation, gaiaID string) *User {
+	for _, participant := range conversation.GetParticipantData() {
+		if participant.GetId().GetGaiaId() == gaiaID && participant.GetFallbackName() != "" {
+			return &User{ID: gaiaID, Name: participant.GetFallbackName()}
+		}
+	}
+
+	b.mu.RLock()
+	name, ok := b.names[gaiaID]
+	b.mu.RUnlock()
+	if !ok {
+		response, err := b.Client.GetEntityByID([]string{gaiaID})
+		if err == nil {
+			for _, result := range response.GetEntityResult() {
+				for _, entity := range result.GetEntity() {
+					name = entity.GetProperties().GetDisplayName()
+				}
+			}
+		}
+		// failed lookups are cached too, the id is shown instead
+		b.mu.Lock()
+		b.names[gaiaID] = name
+		b.mu.Unlock()
+	}
+	if name == "" {
+		name = gaiaID
+	}
+	return &User{ID: gaiaID, Name: name}
+}
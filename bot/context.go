@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// User is the sender of a message
+type User struct {
+	ID   string // gaia id
+	Name string // display name, the gaia id if unknown
+}
+
+// Context is the message being handled, replies go to its conversation
+type Context struct {
+	context.Context
+
+	Bot          *Bot
+	Message      *hangups.Message
+	Conversation *hangouts.Conversation
+	Sender       *User
+	Mentioned    bool     // the message addressed the bot by name
+	Command      *Command // nil for messages which are not commands
+	Args         []string // parsed arguments of the command
+	RawArgs      string   // arguments of the command as typed
+
+	values map[string]interface{}
+}
+
+// ConversationID returns the id of the conversation of the message
+func (c *Context) ConversationID() string {
+	return c.Message.ConversationID()
+}
+
+// Text returns the text of the message
+func (c *Context) Text() string {
+	return c.Message.Text()
+}
+
+// Arg returns the i-th argument, or "" if there are fewer arguments
+func (c *Context) Arg(i int) string {
+	if i < 0 || i >= len(c.Args) {
+		return ""
+	}
+	return c.Args[i]
+}
+
+// Set stores a value for the following middleware and the handler
+func (c *Context) Set(key string, value interface{}) {
+	if c.values == nil {
+		c.values = make(map[string]interface{})
+	}
+	c.values[key] = value
+}
+
+// Get returns a value stored with Set
+func (c *Context) Get(key string) (interface{}, bool) {
+	value, ok := c.values[key]
+	return value, ok
+}
+
+// Reply sends a message to the conversation of the message
+func (c *Context) Reply(text string) error {
+	_, err := c.Bot.Client.Send(c.ConversationID(), text)
+	return err
+}
+
+// Replyf formats and sends a message to the conversation of the message
+func (c *Context) Replyf(format string, args ...interface{}) error {
+	return c.Reply(fmt.Sprintf(format, args...))
+}
+
+// ReplyAction sends a "/me" action message to the conversation of the message
+func (c *Context) ReplyAction(text string) error {
+	_, err := c.Bot.Client.Send(c.ConversationID(), text, hangups.WithAction())
+	return err
+}
+
+// ReplyImage sends an image, a file path, url or base64 encoded image, to the conversation of the message
+func (c *Context) ReplyImage(image string) error {
+	return c.Bot.Client.SendImage(c.ConversationID(), image)
+}
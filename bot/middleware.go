@@ -0,0 +1,118 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrNotAllowed is returned by RequireSenders for senders without permission
+var ErrNotAllowed = errors.New("you are not allowed to do that")
+
+// ErrRateLimited is returned by RateLimit when a sender sends too many commands
+var ErrRateLimited = errors.New("slow down, too many requests")
+
+// Logger logs every handled message with its sender, command, duration and error
+func Logger(logger *log.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			start := time.Now()
+			err := next(c)
+
+			name := "message"
+			if c.Command != nil {
+				name = c.Bot.prefix() + c.Command.Name
+			}
+			logger.Printf("%s %s (%s) in %s : %v", c.ConversationID(), name, c.Sender.Name, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// RequireSenders only lets the users with the given gaia ids through
+func RequireSenders(gaiaIDs ...string) Middleware {
+	allowed := make(map[string]bool)
+	for _, id := range gaiaIDs {
+		allowed[id] = true
+	}
+	return Require(func(c *Context) bool {
+		return allowed[c.Sender.ID]
+	})
+}
+
+// Require only lets messages through for which allow returns true
+func Require(allow func(c *Context) bool) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if !allow(c) {
+				return ErrNotAllowed
+			}
+			return next(c)
+		}
+	}
+}
+
+// RateLimit lets every sender through at most burst times in a row, refilled at one per every interval
+func RateLimit(burst int, every time.Duration) Middleware {
+	type bucket struct {
+		tokens float64
+		last   time.Time
+	}
+	var mu sync.Mutex
+	buckets := make(map[string]*bucket)
+	// a bucket idle for this long is full again, the same as a new one
+	refill := time.Duration(burst) * every
+	lastSweep := time.Now()
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			now := time.Now()
+
+			mu.Lock()
+			if now.Sub(lastSweep) >= refill {
+				for id, b := range buckets {
+					if now.Sub(b.last) >= refill {
+						delete(buckets, id)
+					}
+				}
+				lastSweep = now
+			}
+			b, ok := buckets[c.Sender.ID]
+			if !ok {
+				b = &bucket{tokens: float64(burst), last: now}
+				buckets[c.Sender.ID] = b
+			}
+			b.tokens += float64(now.Sub(b.last)) / float64(every)
+			if b.tokens > float64(burst) {
+				b.tokens = float64(burst)
+			}
+			b.last = now
+			allowed := b.tokens >= 1
+			if allowed {
+				b.tokens--
+			}
+			mu.Unlock()
+
+			if !allowed {
+				return ErrRateLimited
+			}
+			return next(c)
+		}
+	}
+}
+
+// Recover turns panics of handlers into errors
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("internal error : %v", r)
+				}
+			}()
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Package bridge relays messages between hangouts conversations and the rooms of another chat network.
+// The remote network is reached through an Adapter, the bridge takes care of the conversation to room
+// mapping, of showing who sent a relayed message, of attachments and of keeping relayed messages from
+// coming back.
+package bridge
+
+import (
+	"context"
+	"time"
+)
+
+// Message is a message of the remote network, or a hangouts message relayed to it
+type Message struct {
+	ID         string // id on the remote network, set by the adapter for received messages
+	RoomID     string
+	SenderID   string // remote user id, or gaia id for relayed hangouts messages
+	SenderName string
+	Text       string
+	Action     bool   // a "/me" message
+	EditOf     string // remote id of the edited message if this is an edit
+	Timestamp  time.Time
+
+	Attachments []*Attachment
+}
+
+// Attachment is a file of a message, given by URL, by content or both
+type Attachment struct {
+	Name     string
+	MimeType string
+	URL      string
+	Data     []byte
+}
+
+// MessageHandler receives the messages of the remote network
+type MessageHandler func(message *Message)
+
+// Adapter connects the bridge to a remote network
+type Adapter interface {
+	// Name names the network in errors and logs, e.g. "slack"
+	Name() string
+	// Run receives messages until ctx is done and passes them to handler
+	Run(ctx context.Context, handler MessageHandler) error
+	// Send posts a message to message.RoomID and returns its remote id,
+	// messages echoed back by the network are recognised by this id
+	Send(ctx context.Context, message *Message) (string, error)
+}
+
+// Puppeting is implemented by adapters which can show a relayed message under the name of its sender,
+// like the username override of slack webhooks. The bridge prefixes the text with the sender name
+// for adapters which do not.
+type Puppeting interface {
+	Puppets() bool
+}
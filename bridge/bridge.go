@@ -0,0 +1,414 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mysqto/hangups"
+	"github.com/mysqto/hangups/internal/idset"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+const (
+	defaultNameFormat = "[%s] "
+	// maxRelayed bounds the remembered ids of relayed messages
+	maxRelayed = 10000
+)
+
+// Bridge relays messages between mapped hangouts conversations and remote rooms.
+// Messages it relayed are recognised when they come back and are not relayed again.
+type Bridge struct {
+	Client  *hangups.Client
+	Adapter Adapter
+	Store   MappingStore // persists the mappings, optional
+
+	// NameFormat formats the sender name prefixed to relayed messages, "[%s] " if not set.
+	// It is used on hangouts and on remote networks which cannot puppet.
+	NameFormat string
+	// DownloadAttachments relays hangouts photos and videos by content instead of by url,
+	// for remote networks which cannot reach the google urls
+	DownloadAttachments bool
+	// OnError is called for messages which cannot be relayed, optional
+	OnError func(err error)
+
+	mu            sync.Mutex
+	rooms         map[string]string // conversation id -> room id
+	conversations map[string]string // room id -> conversation id
+	relayed       *idset.Set        // remote ids of messages sent by the bridge
+	sending       sync.RWMutex      // held for reading while sending, echoes wait for the ids
+	names         map[string]string // gaia id -> display name
+}
+
+// New creates a bridge between client and adapter, mappings are persisted in store if it is not nil
+func New(client *hangups.Client, adapter Adapter, store MappingStore) *Bridge {
+	return &Bridge{
+		Client:        client,
+		Adapter:       adapter,
+		Store:         store,
+		rooms:         make(map[string]string),
+		conversations: make(map[string]string),
+		relayed:       idset.New(maxRelayed),
+		names:         make(map[string]string),
+	}
+}
+
+// Load reads the mappings from the store, Run calls it
+func (b *Bridge) Load() error {
+	if b.Store == nil {
+		return nil
+	}
+	mappings, err := b.Store.Load()
+	if err != nil {
+		return fmt.Errorf("error loading mappings : %v", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, mapping := range mappings {
+		b.rooms[mapping.ConversationID] = mapping.RoomID
+		b.conversations[mapping.RoomID] = mapping.ConversationID
+	}
+	return nil
+}
+
+// Map links a conversation to a room, replacing earlier mappings of both
+func (b *Bridge) Map(conversationID, roomID string) error {
+	b.mu.Lock()
+	if oldRoom, ok := b.rooms[conversationID]; ok {
+		delete(b.conversations, oldRoom)
+	}
+	if oldConversation, ok := b.conversations[roomID]; ok {
+		delete(b.rooms, oldConversation)
+	}
+	b.rooms[conversationID] = roomID
+	b.conversations[roomID] = conversationID
+	b.mu.Unlock()
+	return b.save()
+}
+
+// Unmap removes the mapping of a conversation
+func (b *Bridge) Unmap(conversationID string) error {
+	b.mu.Lock()
+	if roomID, ok := b.rooms[conversationID]; ok {
+		delete(b.conversations, roomID)
+		delete(b.rooms, conversationID)
+	}
+	b.mu.Unlock()
+	return b.save()
+}
+
+// Mappings returns the current mappings
+func (b *Bridge) Mappings() []*Mapping {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	mappings := make([]*Mapping, 0, len(b.rooms))
+	for conversationID, roomID := range b.rooms {
+		mappings = append(mappings, &Mapping{ConversationID: conversationID, RoomID: roomID})
+	}
+	return mappings
+}
+
+// Room returns the room mapped to a conversation
+func (b *Bridge) Room(conversationID string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	roomID, ok := b.rooms[conversationID]
+	return roomID, ok
+}
+
+// Conversation returns the conversation mapped to a room
+func (b *Bridge) Conversation(roomID string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	conversationID, ok := b.conversations[roomID]
+	return conversationID, ok
+}
+
+// save writes the mappings to the store
+func (b *Bridge) save() error {
+	if b.Store == nil {
+		return nil
+	}
+	if err := b.Store.Save(b.Mappings()); err != nil {
+		return fmt.Errorf("error saving mappings : %v", err)
+	}
+	return nil
+}
+
+// Run relays messages in both directions until ctx is done
+func (b *Bridge) Run(ctx context.Context) error {
+	if err := b.Load(); err != nil {
+		return err
+	}
+	// messages sent to hangouts are recognised by the poller when they come back
+	if b.Client.EchoSuppressor == nil {
+		b.Client.EchoSuppressor = hangups.NewEchoSuppressor()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- b.Adapter.Run(ctx, func(message *Message) {
+			b.HandleRemote(ctx, message)
+		})
+	}()
+	go func() {
+		poller := hangups.NewPoller(b.Client)
+		poller.OnError = b.OnError
+		errs <- poller.Run(ctx, func(conversation *hangouts.Conversation, event *hangouts.Event) {
+			b.HandleEvent(ctx, conversation, event)
+		})
+	}()
+
+	// either side stopping stops the bridge
+	err := <-errs
+	cancel()
+	<-errs
+	return err
+}
+
+// HandleEvent relays a hangouts event to the room of its conversation, Run calls it for every new event
+func (b *Bridge) HandleEvent(ctx context.Context, conversation *hangouts.Conversation, event *hangouts.Event) {
+	message := hangups.NewMessage(event)
+	if message == nil {
+		return
+	}
+	roomID, ok := b.Room(message.ConversationID())
+	if !ok {
+		return
+	}
+
+	remote := &Message{
+		RoomID:     roomID,
+		SenderID:   message.SenderID(),
+		SenderName: b.senderName(conversation, message.SenderID()),
+		Text:       message.Text(),
+		Action:     message.IsAction(),
+		Timestamp:  time.Unix(0, int64(event.GetTimestamp())*int64(time.Microsecond)),
+	}
+	for _, attachment := range message.Attachments() {
+		if relayed := b.remoteAttachment(ctx, attachment); relayed != nil {
+			remote.Attachments = append(remote.Attachments, relayed)
+		}
+	}
+	if remote.Text == "" && len(remote.Attachments) == 0 {
+		return
+	}
+	if puppeting, ok := b.Adapter.(Puppeting); !ok || !puppeting.Puppets() {
+		remote.Text = b.prefix(remote.SenderName) + remote.Text
+	}
+
+	b.sending.RLock()
+	id, err := b.Adapter.Send(ctx, remote)
+	b.relayed.Add(id)
+	b.sending.RUnlock()
+	if err != nil {
+		b.error(fmt.Errorf("error relaying %s to %s room %s : %v", event.GetEventId(), b.Adapter.Name(), roomID, err))
+		return
+	}
+}
+
+// remoteAttachment converts a hangouts attachment, nil for attachments without content
+func (b *Bridge) remoteAttachment(ctx context.Context, attachment *hangups.Attachment) *Attachment {
+	relayed := &Attachment{
+		Name: attachment.Name,
+		URL:  firstNonEmpty(attachment.DownloadURL, attachment.ContentURL, attachment.ImageURL, attachment.URL, attachment.MapURL),
+	}
+	if relayed.URL == "" {
+		return nil
+	}
+	if b.DownloadAttachments && (attachment.Type == hangups.AttachmentPhoto || attachment.Type == hangups.AttachmentVideo) {
+		var buffer bytes.Buffer
+		info, err := b.Client.DownloadAttachment(ctx, attachment, &buffer)
+		if err != nil {
+			b.error(err)
+			return relayed
+		}
+		relayed.Data = buffer.Bytes()
+		relayed.MimeType = info.MimeType
+	}
+	return relayed
+}
+
+// HandleRemote relays a message of the remote network to the conversation of its room,
+// Run calls it for every message received by the adapter
+func (b *Bridge) HandleRemote(ctx context.Context, message *Message) {
+	// an echo can arrive before Send has returned its id
+	b.sending.Lock()
+	b.sending.Unlock()
+	// the bridge's own messages and edits of them, e.g. link previews added by the remote network
+	if b.relayed.Has(message.ID) || b.relayed.Has(message.EditOf) {
+		return
+	}
+	conversationID, ok := b.Conversation(message.RoomID)
+	if !ok {
+		return
+	}
+
+	prefix := b.prefix(message.SenderName)
+	if message.EditOf != "" {
+		prefix += "(edited) "
+	}
+	text := message.Text
+
+	var images []*Attachment
+	for _, attachment := range message.Attachments {
+		if isImage(attachment) {
+			images = append(images, attachment)
+		} else if attachment.URL != "" {
+			text = strings.TrimSpace(text + "\n" + firstNonEmpty(attachment.Name, "file") + ": " + attachment.URL)
+		}
+	}
+
+	// hangouts messages carry one photo, the first goes with the text and the others follow on their own
+	var photoIDs []string
+	for _, image := range images {
+		photoID, err := b.upload(ctx, image)
+		if err != nil {
+			b.error(fmt.Errorf("error relaying %s attachment %s : %v", b.Adapter.Name(), image.Name, err))
+			if image.URL != "" {
+				text = strings.TrimSpace(text + "\n" + image.URL)
+			}
+			continue
+		}
+		photoIDs = append(photoIDs, photoID)
+	}
+	if text == "" && len(photoIDs) == 0 {
+		return
+	}
+
+	for i := 0; i == 0 || i < len(photoIDs); i++ {
+		var options []hangups.MessageOption
+		if message.Action {
+			options = append(options, hangups.WithAction())
+		}
+		if i < len(photoIDs) {
+			options = append(options, hangups.WithPhoto(photoIDs[i]))
+		}
+		body := text
+		if i > 0 {
+			body = ""
+		}
+		if _, err := b.Client.SendSegments(conversationID, b.segments(prefix, body), options...); err != nil {
+			b.error(fmt.Errorf("error relaying %s message %s to %s : %v", b.Adapter.Name(), message.ID, conversationID, err))
+			return
+		}
+	}
+}
+
+// segments formats a relayed message with the sender name in bold
+func (b *Bridge) segments(prefix, text string) []*hangouts.Segment {
+	name := &hangouts.Segment{
+		Type:       hangouts.SegmentType_SEGMENT_TYPE_TEXT.Enum(),
+		Text:       proto.String(prefix),
+		Formatting: &hangouts.Formatting{Bold: proto.Bool(true)},
+	}
+	return append([]*hangouts.Segment{name}, hangups.TextToSegments(text)...)
+}
+
+// upload uploads an image attachment and returns its photo id
+func (b *Bridge) upload(ctx context.Context, attachment *Attachment) (string, error) {
+	var photo *hangups.Photo
+	var err error
+	if len(attachment.Data) > 0 {
+		name := firstNonEmpty(attachment.Name, "image"+extension(attachment.MimeType))
+		photo, err = b.Client.UploadMedia(ctx, name, bytes.NewReader(attachment.Data), int64(len(attachment.Data)))
+	} else {
+		photo, err = b.Client.UploadImageFrom(ctx, hangups.MediaSourceURL, attachment.URL)
+	}
+	if err != nil {
+		return "", err
+	}
+	return photo.ImageID, nil
+}
+
+// prefix formats the name prefix of a relayed message
+func (b *Bridge) prefix(name string) string {
+	format := b.NameFormat
+	if format == "" {
+		format = defaultNameFormat
+	}
+	return fmt.Sprintf(format, name)
+}
+
+// senderName returns the display name of a hangouts user
+func (b *Bridge) senderName(conversation *hangouts.Conversation, gaiaID string) string {
+	for _, participant := range conversation.GetParticipantData() {
+		if participant.GetId().GetGaiaId() == gaiaID && participant.GetFallbackName() != "" {
+			return participant.GetFallbackName()
+		}
+	}
+
+	b.mu.Lock()
+	name, ok := b.names[gaiaID]
+	b.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	name = gaiaID
+	response, err := b.Client.GetEntityByID([]string{gaiaID})
+	if err == nil {
+		for _, result := range response.GetEntityResult() {
+			for _, entity := range result.GetEntity() {
+				if displayName := entity.GetProperties().GetDisplayName(); displayName != "" {
+					name = displayName
+				}
+			}
+		}
+	}
+	b.mu.Lock()
+	b.names[gaiaID] = name
+	b.mu.Unlock()
+	return name
+}
+
+// error reports an error to OnError
+func (b *Bridge) error(err error) {
+	if b.OnError != nil {
+		b.OnError(err)
+	}
+}
+
+// isImage checks if an attachment can be sent as a hangouts photo
+func isImage(attachment *Attachment) bool {
+	if attachment.MimeType != "" {
+		return strings.HasPrefix(attachment.MimeType, "image/")
+	}
+	name := firstNonEmpty(attachment.Name, attachment.URL)
+	if i := strings.IndexAny(name, "?#"); i >= 0 {
+		name = name[:i]
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp":
+		return true
+	}
+	return false
+}
+
+// extension returns a file extension for a mime type
+func extension(mimeType string) string {
+	if extensions, err := mime.ExtensionsByType(mimeType); err == nil && len(extensions) > 0 {
+		return extensions[0]
+	}
+	return ""
+}
+
+// firstNonEmpty returns the first non empty string
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,313 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+const (
+	testConversation = "UgwConversation"
+	testRoom         = "room-1"
+	testSender       = "1001"
+)
+
+// fakeHangouts answers the chat API requests made when relaying to hangouts and records the sent messages
+type fakeHangouts struct {
+	mu   sync.Mutex
+	sent []*hangouts.SendChatMessageRequest
+}
+
+// RoundTrip implements http.RoundTripper
+func (f *fakeHangouts) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	ok := &hangouts.ResponseHeader{Status: hangouts.ResponseStatus_RESPONSE_STATUS_OK.Enum()}
+	var response proto.Message
+	switch strings.TrimPrefix(req.URL.Path, "/chat/v1/") {
+	case "conversations/getconversation":
+		response = &hangouts.GetConversationResponse{
+			ResponseHeader: ok,
+			ConversationState: &hangouts.ConversationState{
+				Conversation: &hangouts.Conversation{ConversationId: &hangouts.ConversationId{Id: proto.String(testConversation)}},
+			},
+		}
+	case "conversations/sendchatmessage":
+		request := &hangouts.SendChatMessageRequest{}
+		if err = proto.Unmarshal(body, request); err != nil {
+			return nil, err
+		}
+		f.mu.Lock()
+		f.sent = append(f.sent, request)
+		eventID := "event-" + strconv.Itoa(len(f.sent))
+		f.mu.Unlock()
+		response = &hangouts.SendChatMessageResponse{
+			ResponseHeader: ok,
+			CreatedEvent:   &hangouts.Event{EventId: proto.String(eventID)},
+		}
+	default:
+		return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found",
+			Body: ioutil.NopCloser(strings.NewReader("")), Request: req}, nil
+	}
+
+	encoded, err := proto.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Body:       ioutil.NopCloser(bytes.NewReader([]byte(base64.StdEncoding.EncodeToString(encoded)))),
+		Request:    req,
+	}, nil
+}
+
+// texts returns the text of the messages sent to hangouts
+func (f *fakeHangouts) texts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	texts := make([]string, 0, len(f.sent))
+	for _, request := range f.sent {
+		var text strings.Builder
+		for _, segment := range request.GetMessageContent().GetSegment() {
+			text.WriteString(segment.GetText())
+		}
+		texts = append(texts, text.String())
+	}
+	return texts
+}
+
+// waitTexts waits until n messages have been sent to hangouts
+func (f *fakeHangouts) waitTexts(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		texts := f.texts()
+		if len(texts) >= n {
+			return texts
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d messages on hangouts, want %d: %q", len(texts), n, texts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// slowSend is a Loopback returning from Send only after a delay, so that its echoes are handled
+// before the bridge gets the id of the sent message
+type slowSend struct {
+	*Loopback
+}
+
+// Send implements Adapter
+func (s slowSend) Send(ctx context.Context, message *Message) (string, error) {
+	id, err := s.Loopback.Send(ctx, message)
+	time.Sleep(20 * time.Millisecond)
+	return id, err
+}
+
+// newTestBridge creates a bridge with a mapped conversation, the loopback adapter receives
+// in the background until the test ends
+func newTestBridge(t *testing.T, loopback *Loopback) (*Bridge, *fakeHangouts, context.Context) {
+	return newTestBridgeWith(t, loopback, loopback)
+}
+
+// newTestBridgeWith creates a test bridge sending through adapter, which wraps loopback
+func newTestBridgeWith(t *testing.T, loopback *Loopback, adapter Adapter) (*Bridge, *fakeHangouts, context.Context) {
+	fake := &fakeHangouts{}
+	client := &hangups.Client{HTTPClient: &http.Client{Transport: fake}}
+	b := New(client, adapter, nil)
+	b.OnError = func(err error) {
+		t.Errorf("bridge error: %v", err)
+	}
+	if err := b.Map(testConversation, testRoom); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		loopback.Run(ctx, func(message *Message) {
+			b.HandleRemote(ctx, message)
+		})
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return b, fake, ctx
+}
+
+// chatEvent creates a hangouts chat message event of the mapped conversation
+func chatEvent(id, text string) (*hangouts.Conversation, *hangouts.Event) {
+	conversation := &hangouts.Conversation{
+		ConversationId: &hangouts.ConversationId{Id: proto.String(testConversation)},
+		ParticipantData: []*hangouts.ConversationParticipantData{
+			{Id: &hangouts.ParticipantId{GaiaId: proto.String(testSender)}, FallbackName: proto.String("Jane Doe")},
+		},
+	}
+	event := &hangouts.Event{
+		ConversationId: conversation.ConversationId,
+		SenderId:       &hangouts.ParticipantId{GaiaId: proto.String(testSender), ChatId: proto.String(testSender)},
+		Timestamp:      proto.Uint64(uint64(time.Now().UnixNano() / 1000)),
+		EventId:        proto.String(id),
+		EventType:      hangouts.EventType_EVENT_TYPE_REGULAR_CHAT_MESSAGE.Enum(),
+		ChatMessage: &hangouts.ChatMessage{
+			MessageContent: &hangouts.MessageContent{
+				Segment: []*hangouts.Segment{
+					{Type: hangouts.SegmentType_SEGMENT_TYPE_TEXT.Enum(), Text: proto.String(text)},
+				},
+			},
+		},
+	}
+	return conversation, event
+}
+
+func TestHandleEventPuppeting(t *testing.T) {
+	tests := []struct {
+		name   string
+		puppet bool
+		want   string
+	}{
+		{name: "prefixed without puppeting", puppet: false, want: "[Jane Doe] hello"},
+		{name: "plain with puppeting", puppet: true, want: "hello"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loopback := NewLoopback()
+			loopback.Puppet = test.puppet
+			b, _, ctx := newTestBridge(t, loopback)
+
+			conversation, event := chatEvent("e1", "hello")
+			b.HandleEvent(ctx, conversation, event)
+
+			sent := loopback.Sent()
+			if len(sent) != 1 {
+				t.Fatalf("got %d remote messages, want 1", len(sent))
+			}
+			if sent[0].Text != test.want || sent[0].RoomID != testRoom || sent[0].SenderName != "Jane Doe" ||
+				sent[0].SenderID != testSender {
+				t.Errorf("remote message = %+v, want text %q in %s from Jane Doe", sent[0], test.want, testRoom)
+			}
+		})
+	}
+}
+
+func TestHandleEventUnmapped(t *testing.T) {
+	loopback := NewLoopback()
+	b, _, ctx := newTestBridge(t, loopback)
+	if err := b.Unmap(testConversation); err != nil {
+		t.Fatal(err)
+	}
+
+	conversation, event := chatEvent("e1", "hello")
+	b.HandleEvent(ctx, conversation, event)
+	if sent := loopback.Sent(); len(sent) != 0 {
+		t.Errorf("relayed %d messages of an unmapped conversation", len(sent))
+	}
+}
+
+func TestEchoesAreNotRelayedBack(t *testing.T) {
+	loopback := NewLoopback()
+	loopback.Echo = true
+	b, fake, ctx := newTestBridgeWith(t, loopback, slowSend{loopback})
+
+	// the echoes are received while Send is running, before the bridge knows their ids
+	for i := 0; i < 5; i++ {
+		conversation, event := chatEvent("e"+strconv.Itoa(i), "hello "+strconv.Itoa(i))
+		b.HandleEvent(ctx, conversation, event)
+	}
+	relayed := loopback.Sent()
+	if len(relayed) != 5 {
+		t.Fatalf("got %d remote messages, want 5", len(relayed))
+	}
+
+	// edits of relayed messages, e.g. link previews, are echoes as well
+	loopback.Receive(&Message{RoomID: testRoom, SenderName: "bot", Text: "hello 0 (preview)", EditOf: relayed[0].ID})
+	// messages are handled in order, this one comes after all the echoes
+	loopback.Receive(&Message{RoomID: testRoom, SenderName: "Max", Text: "hi"})
+
+	if texts := fake.waitTexts(t, 1); len(texts) != 1 || texts[0] != "[Max] hi" {
+		t.Errorf("hangouts messages = %q, want only [Max] hi", texts)
+	}
+}
+
+func TestHandleRemote(t *testing.T) {
+	tests := []struct {
+		name    string
+		message *Message
+		want    []string
+	}{
+		{
+			name:    "message",
+			message: &Message{RoomID: testRoom, SenderName: "Max", Text: "hi"},
+			want:    []string{"[Max] hi"},
+		},
+		{
+			name:    "edit of a remote message",
+			message: &Message{RoomID: testRoom, SenderName: "Max", Text: "hi!", EditOf: "remote-1"},
+			want:    []string{"[Max] (edited) hi!"},
+		},
+		{
+			name: "file attachment",
+			message: &Message{RoomID: testRoom, SenderName: "Max", Text: "see",
+				Attachments: []*Attachment{{Name: "notes.txt", URL: "https://example.com/notes.txt"}}},
+			want: []string{"[Max] see\nnotes.txt: https://example.com/notes.txt"},
+		},
+		{
+			name:    "unmapped room",
+			message: &Message{RoomID: "room-2", SenderName: "Max", Text: "hi"},
+		},
+		{
+			name:    "empty",
+			message: &Message{RoomID: testRoom, SenderName: "Max"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, fake, ctx := newTestBridge(t, NewLoopback())
+			b.HandleRemote(ctx, test.message)
+			if got := fake.texts(); strings.Join(got, "|") != strings.Join(test.want, "|") {
+				t.Errorf("hangouts messages = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHandleRemoteAction(t *testing.T) {
+	b, fake, ctx := newTestBridge(t, NewLoopback())
+	b.HandleRemote(ctx, &Message{RoomID: testRoom, SenderName: "Max", Text: "waves", Action: true})
+
+	fake.waitTexts(t, 1)
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	request := fake.sent[0]
+	if request.GetEventRequestHeader().GetConversationId().GetId() != testConversation {
+		t.Errorf("sent to %s, want %s", request.GetEventRequestHeader().GetConversationId().GetId(), testConversation)
+	}
+	segments := request.GetMessageContent().GetSegment()
+	if len(segments) == 0 || !segments[0].GetFormatting().GetBold() {
+		t.Errorf("the sender name is not bold: %v", segments)
+	}
+	if len(request.GetAnnotation()) == 0 {
+		t.Error("action sent without annotation")
+	}
+}
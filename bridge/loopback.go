@@ -0,0 +1,94 @@
+package bridge
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Loopback is an in-memory Adapter for tests. Receive injects messages as if they were posted
+// to the remote network, Sent returns what the bridge posted. With Echo set every sent message
+// is also received again, like networks which echo a bot's own messages.
+type Loopback struct {
+	Echo    bool
+	Puppet  bool // report puppeting support, names are not prefixed then
+	SendErr error
+
+	mu       sync.Mutex
+	sent     []*Message
+	incoming chan *Message
+	nextID   int
+}
+
+// NewLoopback creates a Loopback adapter
+func NewLoopback() *Loopback {
+	return &Loopback{incoming: make(chan *Message, 100)}
+}
+
+// Name implements Adapter
+func (l *Loopback) Name() string {
+	return "loopback"
+}
+
+// Puppets implements Puppeting
+func (l *Loopback) Puppets() bool {
+	return l.Puppet
+}
+
+// Run implements Adapter
+func (l *Loopback) Run(ctx context.Context, handler MessageHandler) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case message := <-l.incoming:
+			handler(message)
+		}
+	}
+}
+
+// Send implements Adapter
+func (l *Loopback) Send(ctx context.Context, message *Message) (string, error) {
+	if l.SendErr != nil {
+		return "", l.SendErr
+	}
+
+	sent := *message
+	l.mu.Lock()
+	sent.ID = l.newID()
+	l.sent = append(l.sent, &sent)
+	l.mu.Unlock()
+
+	if l.Echo {
+		echo := sent
+		l.incoming <- &echo
+	}
+	return sent.ID, nil
+}
+
+// Receive injects a message as if it was posted to the remote network, an id is assigned if it has none
+func (l *Loopback) Receive(message *Message) {
+	if message.ID == "" {
+		l.mu.Lock()
+		message.ID = l.newID()
+		l.mu.Unlock()
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	l.incoming <- message
+}
+
+// Sent returns the messages posted by the bridge so far
+func (l *Loopback) Sent() []*Message {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*Message{}, l.sent...)
+}
+
+// newID returns the next message id, l.mu must be held
+func (l *Loopback) newID() string {
+	l.nextID++
+	return "loopback-" + strconv.Itoa(l.nextID)
+}
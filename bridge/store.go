@@ -0,0 +1,80 @@
+package bridge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Mapping links a hangouts conversation to a remote room
+type Mapping struct {
+	ConversationID string `json:"conversation_id"`
+	RoomID         string `json:"room_id"`
+}
+
+// MappingStore persists the mappings of a bridge
+type MappingStore interface {
+	Load() ([]*Mapping, error)
+	Save(mappings []*Mapping) error
+}
+
+// FileMappingStore is a MappingStore keeping the mappings in a JSON file
+type FileMappingStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// NewFileMappingStore creates a store writing to path
+func NewFileMappingStore(path string) *FileMappingStore {
+	return &FileMappingStore{Path: path}
+}
+
+// Load implements MappingStore, a missing file has no mappings
+func (s *FileMappingStore) Load() ([]*Mapping, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mappings := make([]*Mapping, 0)
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return mappings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return mappings, nil
+	}
+	if err = json.Unmarshal(data, &mappings); err != nil {
+		return nil, err
+	}
+	return mappings, nil
+}
+
+// Save implements MappingStore, the file is replaced atomically
+func (s *FileMappingStore) Save(mappings []*Mapping) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(mappings, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
@@ -5,7 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/asaskevich/govalidator"
@@ -24,6 +25,32 @@ type Client struct {
 	Session   *Session
 	ClientID  string
 	UserAgent string
+
+	MaxDownloadSize int64           // limit for DownloadAttachment and images given by url in bytes, 50MB if not set
+	ImageHosts      []string        // hosts, including their subdomains, images given by url may be downloaded from, any if empty
+	ImageProcessor  *ImageProcessor // optional processing of images before they are uploaded
+	EchoSuppressor  *EchoSuppressor // optional, remembers the messages sent by this client
+	MaxMessageRunes int             // longer messages are split, 4000 if not set, negative for no limit
+	MaxMessageBytes int             // longer messages are split, no limit if not set
+
+	Logger Logger  // optional, receives the logs of requests, retries and decoding failures
+	Tracer *Tracer // optional, called around every API request
+	Stats  Stats   // optional, receives request, sync and upload measurements
+
+	HTTPClient *http.Client // used for all requests, a new http.Client if not set
+	Recorder   *Recorder    // optional, records the protobuf requests and responses
+
+	// OnUnknownFields is called with the fields of a response which are not in hangouts.proto,
+	// optional as walking every response has a cost. See UnknownFieldReport to collect them.
+	OnUnknownFields func(endpoint string, fields []*UnknownField)
+}
+
+// httpClient returns the HTTP client of the requests
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return &http.Client{}
 }
 
 // getMessageContent creates a new MessageContent with content
@@ -68,13 +95,8 @@ func getExistingMedia(imageID string) *hangouts.ExistingMedia {
 	return nil
 }
 
-// initialize random number generator needed for client id
-func init() {
-	rand.Seed(time.Now().UTC().UnixNano())
-}
-
 func getLookupSpec(id string) *hangouts.EntityLookupSpec {
-	if id[0] == '+' {
+	if strings.HasPrefix(id, "+") {
 		return &hangouts.EntityLookupSpec{
 			Phone:                &id,
 			CreateOffnetworkGaia: proto.Bool(true),
@@ -114,7 +136,7 @@ func (c *Client) NewEventRequestHeaders(conversationID string, offTheRecord bool
 	}
 
 	// needs to be unique every time
-	clientGeneratedID := uint64(rand.Uint32())
+	clientGeneratedID := newClientGeneratedID()
 	eventType := hangouts.EventType_EVENT_TYPE_REGULAR_CHAT_MESSAGE
 	return &hangouts.EventRequestHeader{
 		ConversationId:    &hangouts.ConversationId{Id: &conversationID},
@@ -126,7 +148,13 @@ func (c *Client) NewEventRequestHeaders(conversationID string, offTheRecord bool
 }
 
 // ProtobufAPIRequest do a protobuf API request
-func (c *Client) ProtobufAPIRequest(apiEndpoint string, requestStruct, responseStruct proto.Message) error {
+func (c *Client) ProtobufAPIRequest(apiEndpoint string, requestStruct, responseStruct proto.Message) (err error) {
+	trace := c.startTrace(apiEndpoint)
+	var header *hangouts.ResponseHeader
+	defer func() {
+		c.endTrace(trace, header, err)
+	}()
+
 	payload, err := proto.Marshal(requestStruct)
 	if err != nil {
 		return err
@@ -135,21 +163,39 @@ func (c *Client) ProtobufAPIRequest(apiEndpoint string, requestStruct, responseS
 	headers := map[string]string{
 		"Content-Type": "application/x-protobuf",
 	}
-	output, err := c.APIRequest(url, "proto", headers, payload)
+	output, status, err := c.apiRequest(url, "proto", headers, payload)
+	trace.HTTPStatus = status
 	if err != nil {
 		return err
 	}
 
 	decodedOutput, err := base64.StdEncoding.DecodeString(string(output))
 	if err != nil {
-		return err
+		logTo(c.Logger, LevelError, "cannot decode response", "endpoint", apiEndpoint,
+			"http_status", status, "error", err, "body", truncateBody(output))
+		c.recordExchange(apiEndpoint, requestStruct, nil, payload, output)
+		return newHTTPError(status, output, err)
 	}
 
 	err = proto.Unmarshal(decodedOutput, responseStruct)
 	if err != nil {
-		return err
+		logTo(c.Logger, LevelError, "cannot unmarshal response", "endpoint", apiEndpoint,
+			"http_status", status, "error", err)
+		c.recordExchange(apiEndpoint, requestStruct, nil, payload, decodedOutput)
+		return newHTTPError(status, output, err)
+	}
+	c.recordExchange(apiEndpoint, requestStruct, responseStruct, payload, decodedOutput)
+	if c.OnUnknownFields != nil {
+		if fields := UnknownFields(responseStruct); len(fields) > 0 {
+			c.OnUnknownFields(apiEndpoint, fields)
+		}
 	}
 
+	if response, ok := responseStruct.(interface {
+		GetResponseHeader() *hangouts.ResponseHeader
+	}); ok {
+		header = response.GetResponseHeader()
+	}
 	return nil
 }
 
@@ -184,7 +230,7 @@ func (c *Client) CreateConversation(inviteesGaiaIds []string, name string, oneOn
 	if oneOnOne {
 		conversationType = hangouts.ConversationType_CONVERSATION_TYPE_ONE_TO_ONE
 	}
-	clientGeneratedID := uint64(rand.Uint32())
+	clientGeneratedID := newClientGeneratedID()
 	request := &hangouts.CreateConversationRequest{
 		RequestHeader:     c.NewRequestHeaders(),
 		InviteeId:         inviteeIds,
@@ -251,6 +297,25 @@ func (c *Client) GetConversation(conversationID string, includeEvent bool, maxEv
 	return response, nil
 }
 
+// GetConversationHistory return up to maxEventsPerConversation events of a conversation
+// older than eventTimestamp, use the timestamp of the oldest event received to page backwards.
+func (c *Client) GetConversationHistory(conversationID string, maxEventsPerConversation,
+	eventTimestamp uint64) (*hangouts.GetConversationResponse, error) {
+	request := &hangouts.GetConversationRequest{
+		RequestHeader:            c.NewRequestHeaders(),
+		ConversationSpec:         &hangouts.ConversationSpec{ConversationId: &hangouts.ConversationId{Id: &conversationID}},
+		IncludeEvent:             proto.Bool(true),
+		MaxEventsPerConversation: &maxEventsPerConversation,
+		EventContinuationToken:   &hangouts.EventContinuationToken{EventTimestamp: &eventTimestamp},
+	}
+	response := &hangouts.GetConversationResponse{}
+	err := c.ProtobufAPIRequest("conversations/getconversation", request, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
 // GetEntities returns entities of a specific id
 func (c *Client) GetEntities(id string) (*hangouts.Entity, error) {
 	response, err := c.GetEntityByID([]string{id})
@@ -258,7 +323,11 @@ func (c *Client) GetEntities(id string) (*hangouts.Entity, error) {
 		return nil, err
 	}
 
-	return response.EntityResult[0].Entity[0], nil
+	results := response.GetEntityResult()
+	if len(results) == 0 || len(results[0].GetEntity()) == 0 {
+		return nil, fmt.Errorf("no entity found for %s", id)
+	}
+	return results[0].GetEntity()[0], nil
 }
 
 // GetEntityByID return info about a list of users.
@@ -403,24 +472,145 @@ func (c *Client) SearchEntities(query string, maxCount uint64) (*hangouts.Search
 
 // SendMessage sends a message to a user/group
 // to can be phoneNumber, email chatID/GaiaID or conversation ID
-func (c *Client) SendMessage(to, content string) error {
-	return c.sendMessage(to, content, "")
+// options can modify the message, e.g. WithAction to send a "/me" action
+func (c *Client) SendMessage(to, content string, options ...MessageOption) error {
+	_, err := c.sendMessage(to, content, "", options...)
+	return err
+}
+
+// Send sends a message to a user/group like SendMessage and returns the sent message
+// with its client generated id and the event created by the server
+func (c *Client) Send(to, content string, options ...MessageOption) (*SentMessage, error) {
+	return c.sendMessage(to, content, "", options...)
+}
+
+// SendSegments sends a message made of segments, e.g. with formatting or links, to a user/group
+// to can be phoneNumber, email chatID/GaiaID or conversation ID
+func (c *Client) SendSegments(to string, segments []*hangouts.Segment, options ...MessageOption) (*SentMessage, error) {
+	return c.sendSegments(to, segments, "", options...)
 }
 
 // sendMessage the actual send routine
-func (c *Client) sendMessage(to, content, imageID string) error {
+func (c *Client) sendMessage(to, content, imageID string, options ...MessageOption) (*SentMessage, error) {
+	return c.sendSegments(to, getMessageContent(content).GetSegment(), imageID, options...)
+}
+
+// sendSegments sends segments, splitting them into several messages if they exceed the size limits.
+// The parts are sent in order, an image is attached to the last one.
+func (c *Client) sendSegments(to string, segments []*hangouts.Segment, imageID string,
+	options ...MessageOption) (*SentMessage, error) {
 	conv, err := c.Create1On1Conversation(to)
 	if err != nil {
-		return errors.New("cannot determine one on one conversation for " + to)
+		return nil, fmt.Errorf("cannot determine one on one conversation for %s : %w", to, err)
+	}
+
+	maxRunes := c.MaxMessageRunes
+	if maxRunes == 0 {
+		maxRunes = defaultMaxMessageRunes
+	}
+	chunks := SplitSegments(segments, c.MaxMessageBytes, maxRunes)
+	if len(chunks) == 0 {
+		// image only message
+		chunks = append(chunks, nil)
+	}
+
+	parts := make([]*SentMessage, 0, len(chunks))
+	for i, chunk := range chunks {
+		request := c.newChatMessageRequest(conv)
+		if len(chunk) > 0 {
+			request.MessageContent = &hangouts.MessageContent{Segment: chunk}
+		}
+		request.ExistingMedia = getExistingMedia(imageID)
+		applyMessageOptions(request, conv, options)
+
+		if i > 0 {
+			// derive the ids of the parts from the first one so that retries stay idempotent
+			request.EventRequestHeader.ClientGeneratedId = proto.Uint64(parts[0].ClientGeneratedID + uint64(i))
+		}
+		if i < len(chunks)-1 {
+			request.ExistingMedia = nil
+		}
+
+		sent, err := c.sendTo(to, request)
+		if err != nil {
+			if len(chunks) > 1 {
+				return nil, fmt.Errorf("sent %d of %d parts : %w", i, len(chunks), err)
+			}
+			return nil, err
+		}
+		parts = append(parts, sent)
+	}
+
+	sent := parts[len(parts)-1]
+	if len(parts) > 1 {
+		sent.Parts = parts
+	}
+	return sent, nil
+}
+
+// sendTo sends a chat message request for to, registering its client generated id with the EchoSuppressor
+func (c *Client) sendTo(to string, request *hangouts.SendChatMessageRequest) (*SentMessage, error) {
+	clientGeneratedID := request.GetEventRequestHeader().GetClientGeneratedId()
+	if c.EchoSuppressor != nil {
+		c.EchoSuppressor.Add(clientGeneratedID)
+	}
+
+	resp, err := c.sendChatMessageRequest(request)
+	if err = checkSendResponse(to, resp, err); err != nil {
+		return nil, err
 	}
-	resp, err := c.sendChatMessage(conv, content, imageID)
 
+	if c.EchoSuppressor != nil {
+		c.EchoSuppressor.AddEvent(resp.GetCreatedEvent())
+	}
+
+	return &SentMessage{
+		ConversationID:    request.GetEventRequestHeader().GetConversationId().GetId(),
+		ClientGeneratedID: clientGeneratedID,
+		Event:             resp.GetCreatedEvent(),
+	}, nil
+}
+
+// ResponseError is a response from the server with a status other than RESPONSE_STATUS_OK
+type ResponseError struct {
+	Status      hangouts.ResponseStatus
+	Description string
+	DebugURL    string
+}
+
+// Error implements error
+func (e *ResponseError) Error() string {
+	return e.Description
+}
+
+// newResponseError returns a ResponseError for a response header with an error status, nil otherwise
+func newResponseError(header *hangouts.ResponseHeader) *ResponseError {
+	if header.GetStatus() == hangouts.ResponseStatus_RESPONSE_STATUS_OK {
+		return nil
+	}
+	return &ResponseError{
+		Status:      header.GetStatus(),
+		Description: header.GetErrorDescription(),
+		DebugURL:    header.GetDebugUrl(),
+	}
+}
+
+// CheckResponseHeader returns a *ResponseError if the response header reports a failure
+func CheckResponseHeader(header *hangouts.ResponseHeader) error {
+	if err := newResponseError(header); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkSendResponse turns a failed send or a response with an error status into an error
+func checkSendResponse(to string, resp *hangouts.SendChatMessageResponse, err error) error {
 	if err != nil {
-		return fmt.Errorf("fail to send message to %s, error : %v ", to, err)
+		return fmt.Errorf("fail to send message to %s, error : %w ", to, err)
 	}
 
-	if resp.GetResponseHeader().GetStatus() != hangouts.ResponseStatus_RESPONSE_STATUS_OK {
-		return fmt.Errorf("fail to send message to %s, error : %v ", to, resp.GetResponseHeader().GetErrorDescription())
+	if responseErr := newResponseError(resp.GetResponseHeader()); responseErr != nil {
+		return fmt.Errorf("fail to send message to %s, error : %w ", to, responseErr)
 	}
 	return nil
 }
@@ -429,12 +619,15 @@ func (c *Client) sendMessage(to, content, imageID string) error {
 func (c *Client) Create1On1Conversation(id string) (*hangouts.Conversation, error) {
 	chatID := id
 	// email/phone/GaiaID
-	if govalidator.IsEmail(id) || id[0] == '+' || govalidator.IsNumeric(id) {
+	if govalidator.IsEmail(id) || strings.HasPrefix(id, "+") || govalidator.IsNumeric(id) {
 		entity, err := c.GetEntities(id)
 		if err != nil {
-			return nil, fmt.Errorf("error getting entity for %s: %v", id, err)
+			return nil, fmt.Errorf("error getting entity for %s: %w", id, err)
+		}
+		chatID = entity.GetId().GetChatId()
+		if chatID == "" {
+			return nil, fmt.Errorf("no chat id found for %s", id)
 		}
-		chatID = *entity.Id.ChatId
 
 		resp, err := c.CreateConversation([]string{chatID}, id, true)
 
@@ -475,23 +668,17 @@ func (c *Client) SendChatImage(conversationID, imageID string) (*hangouts.SendCh
 	return c.sendChatMessageRequest(request)
 }
 
-// sendChatMessage send a chat message to a conversation.
-func (c *Client) sendChatMessage(conversation *hangouts.Conversation, content, imageID string) (*hangouts.SendChatMessageResponse, error) {
+// newChatMessageRequest creates an empty chat message request matching the settings of a conversation
+func (c *Client) newChatMessageRequest(conversation *hangouts.Conversation) *hangouts.SendChatMessageRequest {
 	offTheRecord := conversation.GetOtrStatus() == hangouts.OffTheRecordStatus_OFF_THE_RECORD_STATUS_OFF_THE_RECORD
-	deliveryMediumOption := conversation.SelfConversationState.DeliveryMediumOption
-	deliveryMediumType := hangouts.DeliveryMediumType_DELIVERY_MEDIUM_BABEL
-
-	if len(deliveryMediumOption) > 0 {
-		deliveryMediumType = *deliveryMediumOption[0].DeliveryMedium.MediumType
-	}
+	deliveryMedium := defaultDeliveryMedium(conversation)
 
-	request := &hangouts.SendChatMessageRequest{
+	eventRequestHeader := c.NewEventRequestHeaders(*conversation.ConversationId.Id, offTheRecord, deliveryMedium.GetMediumType())
+	eventRequestHeader.DeliveryMedium = deliveryMedium
+	return &hangouts.SendChatMessageRequest{
 		RequestHeader:      c.NewRequestHeaders(),
-		EventRequestHeader: c.NewEventRequestHeaders(*conversation.ConversationId.Id, offTheRecord, deliveryMediumType),
-		MessageContent:     getMessageContent(content),
-		ExistingMedia:      getExistingMedia(imageID),
+		EventRequestHeader: eventRequestHeader,
 	}
-	return c.sendChatMessageRequest(request)
 }
 
 // sendChatMessageRequest actual send routine
@@ -666,6 +853,25 @@ func (c *Client) SyncRecentConversations(maxConversations, maxEventsPerConversat
 	return response, nil
 }
 
+// SyncRecentConversationsBefore return info on conversations with events before lastEventTimestamp,
+// use the continuation_end_timestamp of the previous response to page through all conversations.
+func (c *Client) SyncRecentConversationsBefore(lastEventTimestamp, maxConversations,
+	maxEventsPerConversation uint64) (*hangouts.SyncRecentConversationsResponse, error) {
+	request := &hangouts.SyncRecentConversationsRequest{
+		RequestHeader:            c.NewRequestHeaders(),
+		LastEventTimestamp:       &lastEventTimestamp,
+		MaxConversations:         &maxConversations,
+		MaxEventsPerConversation: &maxEventsPerConversation,
+		SyncFilter:               []hangouts.SyncFilter{hangouts.SyncFilter_SYNC_FILTER_INBOX},
+	}
+	response := &hangouts.SyncRecentConversationsResponse{}
+	err := c.ProtobufAPIRequest("conversations/syncrecentconversations", request, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
 // UpdateWatermark update the watermark (read timestamp) of a conversation.
 func (c *Client) UpdateWatermark(conversationID string, lastReadTimestamp uint64) (*hangouts.UpdateWatermarkResponse, error) {
 	request := &hangouts.UpdateWatermarkRequest{
@@ -684,12 +890,29 @@ func (c *Client) UpdateWatermark(conversationID string, lastReadTimestamp uint64
 // getImageUploadURL request the image upload url for uploading
 func (c *Client) getImageUploadURL(image string) (*UploadFile, error) {
 
-	uploadFile, err := readImage(image)
+	uploadFile, err := c.readImage(image)
 
 	if err != nil {
 		return nil, err
 	}
 
+	if c.ImageProcessor != nil {
+		uploadFile.name, uploadFile.data, err = c.ImageProcessor.Process(uploadFile.name, uploadFile.data)
+		if err != nil {
+			return nil, err
+		}
+		uploadFile.size = len(uploadFile.data)
+	}
+
+	uploadFile.uploadURL, err = c.createUploadSession(uploadFile.name, int64(uploadFile.size))
+	if err != nil {
+		return nil, err
+	}
+	return uploadFile, nil
+}
+
+// createUploadSession starts a resumable upload session for a file and returns its upload url
+func (c *Client) createUploadSession(name string, size int64) (string, error) {
 	now := time.Now()
 	nowMsec := now.UnixNano() / int64(time.Millisecond)
 	param := fmt.Sprintf(`    
@@ -763,7 +986,7 @@ func (c *Client) getImageUploadURL(image string) (*UploadFile, error) {
                 }
             ]
         }
-    }`, uploadFile.name, uploadFile.size, uploadFile.name, nowMsec, nowMsec, now.Format("2006-01-02"))
+    }`, name, size, name, nowMsec, nowMsec, now.Format("2006-01-02"))
 
 	params := json.RawMessage(param)
 
@@ -776,18 +999,17 @@ func (c *Client) getImageUploadURL(image string) (*UploadFile, error) {
 	resp, err := c.APIRequest(imageUploadURL, "json", headers, payload)
 
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	if !gjson.ValidBytes(resp) {
-		return nil, errors.New("cannot find upload url to upload, raw response : " + string(resp))
+		return "", errors.New("cannot find upload url to upload, raw response : " + string(resp))
 	}
 
 	result := gjson.GetBytes(resp, "sessionStatus.externalFieldTransfers.0.putInfo.url")
 	if !result.Exists() {
-		return nil, errors.New("cannot find upload url to upload, raw response : " + string(resp))
+		return "", errors.New("cannot find upload url to upload, raw response : " + string(resp))
 	}
-	uploadFile.uploadURL = result.String()
-	return uploadFile, nil
+	return result.String(), nil
 }
 
 // UploadImage uploads an image to google and returns the imageID for chat message sending
@@ -807,7 +1029,15 @@ func (c *Client) UploadImage(image string) (*Photo, error) {
 	if err != nil {
 		return nil, err
 	}
+	if c.Stats != nil {
+		c.Stats.UploadedBytes(int64(len(uploadFile.data)))
+	}
+
+	return parseUploadResponse(resp)
+}
 
+// parseUploadResponse extracts the uploaded photo from the final response of an upload session
+func parseUploadResponse(resp []byte) (*Photo, error) {
 	if !gjson.ValidBytes(resp) {
 		return nil, errors.New("cannot upload image, raw response : " + string(resp))
 	}
@@ -819,7 +1049,7 @@ func (c *Client) UploadImage(image string) (*Photo, error) {
 
 	var photo Photo
 
-	err = json.Unmarshal([]byte(result.Raw), &photo)
+	err := json.Unmarshal([]byte(result.Raw), &photo)
 
 	if err != nil {
 		return nil, err
@@ -837,12 +1067,14 @@ func (c *Client) SendImage(to, image string) error {
 		return fmt.Errorf("error uploading media %v : %v", image, err)
 	}
 
-	return c.sendMessage(to, "", photo.ImageID)
+	_, err = c.sendMessage(to, "", photo.ImageID)
+	return err
 }
 
 // SendPhotoID send image to a user or group
 // to can be phoneNumber, email chatID/GaiaID or conversation ID
 // photoID is a photo id from hangouts message
 func (c *Client) SendPhotoID(to, photoID string) error {
-	return c.sendMessage(to, "", photoID)
+	_, err := c.sendMessage(to, "", photoID)
+	return err
 }
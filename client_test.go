@@ -0,0 +1,96 @@
+package hangups
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// roundTripFunc answers requests of a fake chat api
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// protoClient returns a client whose requests are answered with response
+func protoClient(t *testing.T, response proto.Message) *Client {
+	data, err := proto.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := base64.StdEncoding.EncodeToString(data)
+	return &Client{HTTPClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Request:    r,
+		}, nil
+	})}}
+}
+
+func TestGetEntities(t *testing.T) {
+	entity := &hangouts.Entity{Id: &hangouts.ParticipantId{GaiaId: proto.String("1"), ChatId: proto.String("1")}}
+	tests := []struct {
+		name     string
+		response *hangouts.GetEntityByIdResponse
+		wantErr  bool
+	}{
+		{"no result", &hangouts.GetEntityByIdResponse{}, true},
+		{"no entity", &hangouts.GetEntityByIdResponse{EntityResult: []*hangouts.EntityResult{{}}}, true},
+		{"entity", &hangouts.GetEntityByIdResponse{EntityResult: []*hangouts.EntityResult{{Entity: []*hangouts.Entity{entity}}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := protoClient(t, tt.response).GetEntities("user@example.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetEntities() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.GetId().GetChatId() != "1" {
+				t.Errorf("GetEntities() = %v, want %v", got, entity)
+			}
+		})
+	}
+}
+
+func TestCreate1On1ConversationUnknown(t *testing.T) {
+	tests := []struct {
+		name     string
+		response *hangouts.GetEntityByIdResponse
+	}{
+		{"no entity", &hangouts.GetEntityByIdResponse{}},
+		{"no chat id", &hangouts.GetEntityByIdResponse{EntityResult: []*hangouts.EntityResult{{Entity: []*hangouts.Entity{{}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := protoClient(t, tt.response).Create1On1Conversation("+15550100"); err == nil {
+				t.Error("Create1On1Conversation() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestGetLookupSpec(t *testing.T) {
+	tests := []struct {
+		id    string
+		phone bool
+		email bool
+	}{
+		{"", false, false},
+		{"+15550100", true, false},
+		{"user@example.com", false, true},
+		{"123456789", false, false},
+	}
+	for _, tt := range tests {
+		spec := getLookupSpec(tt.id)
+		if (spec.Phone != nil) != tt.phone || (spec.Email != nil) != tt.email {
+			t.Errorf("getLookupSpec(%q) = %v", tt.id, spec)
+		}
+	}
+}
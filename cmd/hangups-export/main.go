@@ -0,0 +1,128 @@
+// Command hangups-export archives hangouts conversations, one file per conversation
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mysqto/hangups"
+	"github.com/mysqto/hangups/export"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+func main() {
+	format := flag.String("format", "json", "archive format: json, html or text")
+	out := flag.String("out", ".", "directory to write the archives to")
+	conversations := flag.String("conversation", "", "comma separated conversation ids to export, all if empty")
+	maxEvents := flag.Int("max-events", 0, "maximum number of events per conversation, 0 for the complete history")
+	inlineImages := flag.Bool("inline-images", true, "embed photos in html archives")
+	refreshToken := flag.String("refresh-token", os.Getenv("HANGUPS_REFRESH_TOKEN"), "oauth refresh token, asks to log in if empty")
+	takeout := flag.String("takeout", "", "export the conversations of a Google Takeout Hangouts.json instead of the live history")
+	flag.Parse()
+
+	var write func(f *os.File, archive *export.Archive) error
+	var extension string
+	switch *format {
+	case "json":
+		write, extension = func(f *os.File, a *export.Archive) error { return export.WriteJSON(f, a) }, ".json"
+	case "html":
+		write, extension = func(f *os.File, a *export.Archive) error { return export.WriteHTML(f, a) }, ".html"
+	case "text":
+		write, extension = func(f *os.File, a *export.Archive) error { return export.WriteText(f, a) }, ".txt"
+	default:
+		log.Fatalf("unknown format %q", *format)
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		log.Fatal(err)
+	}
+	save := func(archive *export.Archive) {
+		path := filepath.Join(*out, fileName(archive.ConversationID)+extension)
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = write(f, archive)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Fatalf("cannot write %s : %v", path, err)
+		}
+		fmt.Println(path)
+	}
+
+	wanted := make(map[string]bool)
+	if *conversations != "" {
+		for _, id := range strings.Split(*conversations, ",") {
+			wanted[id] = true
+		}
+	}
+
+	if *takeout != "" {
+		f, err := os.Open(*takeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		err = hangups.ReadTakeout(f, func(state *hangouts.ConversationState) error {
+			if len(wanted) > 0 && !wanted[state.GetConversationId().GetId()] {
+				return nil
+			}
+			conversation := export.NewConversation(state)
+			if *maxEvents > 0 && len(conversation.Events) > *maxEvents {
+				conversation.Events = conversation.Events[len(conversation.Events)-*maxEvents:]
+			}
+			save(export.NewArchive(conversation))
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	session := &hangups.Session{RefreshToken: *refreshToken}
+	if err := session.Init(); err != nil {
+		log.Fatal(err)
+	}
+	client := &hangups.Client{Session: session}
+
+	options := &export.FetchOptions{
+		MaxEvents: *maxEvents,
+		Progress: func(conversationID string, events int) {
+			log.Printf("%s : %d events", conversationID, events)
+		},
+	}
+	for id := range wanted {
+		options.ConversationIDs = append(options.ConversationIDs, id)
+	}
+
+	ctx := context.Background()
+	fetched, err := export.Fetch(ctx, client, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, conversation := range fetched {
+		archive := export.NewArchive(conversation)
+		if *format == "html" && *inlineImages {
+			export.InlineImages(ctx, archive, client.DownloadAttachment)
+		}
+		save(archive)
+	}
+}
+
+// fileName replaces the characters of a conversation id which are unsafe in file names
+func fileName(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == 0 {
+			return '_'
+		}
+		return r
+	}, id)
+}
@@ -0,0 +1,74 @@
+// Command hangups-gateway serves the REST/JSON API of package gateway
+package main
+
+import (
+	"expvar"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/mysqto/hangups"
+	"github.com/mysqto/hangups/gateway"
+	"github.com/mysqto/hangups/metrics"
+)
+
+func main() {
+	refreshToken := flag.String("refresh-token", os.Getenv("HANGUPS_REFRESH_TOKEN"), "oauth refresh token, asks to log in if empty")
+	listen := flag.String("listen", "localhost:8080", "address to listen on")
+	prefix := flag.String("prefix", "/", "path prefix of the api")
+	apiKeys := flag.String("api-keys", os.Getenv("HANGUPS_API_KEYS"), "comma separated api keys, required unless -insecure")
+	insecure := flag.Bool("insecure", false, "accept requests without api key")
+	allowFiles := flag.Bool("allow-files", false, "let requests send image files of this host")
+	imageHosts := flag.String("image-hosts", "", "comma separated hosts images given by url may be downloaded from, any if empty")
+	metricsAddr := flag.String("metrics", "", "address serving /metrics and /debug/vars, e.g. localhost:9090, disabled if empty")
+	flag.Parse()
+
+	keys := make([]string, 0)
+	for _, key := range strings.Split(*apiKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 && !*insecure {
+		log.Fatal("no api keys, set -api-keys or -insecure")
+	}
+
+	session := &hangups.Session{RefreshToken: *refreshToken}
+	if err := session.Init(); err != nil {
+		log.Fatal(err)
+	}
+
+	client := &hangups.Client{Session: session}
+	for _, host := range strings.Split(*imageHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			client.ImageHosts = append(client.ImageHosts, host)
+		}
+	}
+	if *metricsAddr != "" {
+		stats := metrics.New()
+		stats.Publish("hangups")
+		client.Stats = stats
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", stats)
+		metricsMux.Handle("/debug/vars", expvar.Handler())
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsAddr, metricsMux))
+		}()
+		log.Printf("serving metrics on %s", *metricsAddr)
+	}
+
+	api := gateway.New(client, keys...)
+	api.AllowFiles = *allowFiles
+
+	path := "/" + strings.Trim(*prefix, "/")
+	mux := http.NewServeMux()
+	if path == "/" {
+		mux.Handle("/", api)
+	} else {
+		mux.Handle(path+"/", http.StripPrefix(path, api))
+	}
+	log.Printf("serving the api on %s%s", *listen, path)
+	log.Fatal(http.ListenAndServe(*listen, mux))
+}
@@ -0,0 +1,45 @@
+// Command hangups-irc is a local IRC server for a hangouts account, see package irc
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mysqto/hangups"
+	"github.com/mysqto/hangups/irc"
+)
+
+func main() {
+	refreshToken := flag.String("refresh-token", os.Getenv("HANGUPS_REFRESH_TOKEN"), "oauth refresh token, asks to log in if empty")
+	listen := flag.String("listen", "localhost:6667", "address to listen on, keep it local")
+	password := flag.String("password", os.Getenv("HANGUPS_IRC_PASSWORD"), "password IRC clients have to send, optional")
+	flag.Parse()
+
+	session := &hangups.Session{RefreshToken: *refreshToken}
+	if err := session.Init(); err != nil {
+		log.Fatal(err)
+	}
+
+	server := irc.NewServer(irc.NewClientBackend(&hangups.Client{Session: session}))
+	server.Password = *password
+	server.OnError = func(err error) {
+		log.Print(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	log.Printf("IRC gateway listening on %s", *listen)
+	if err := server.ListenAndServe(ctx, *listen); err != nil && err != context.Canceled {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,93 @@
+// Command hangups-mail relays email received over SMTP to hangouts
+// and emails digests of missed hangouts messages, see package email for the recipient addresses
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mysqto/hangups"
+	"github.com/mysqto/hangups/email"
+)
+
+func main() {
+	refreshToken := flag.String("refresh-token", os.Getenv("HANGUPS_REFRESH_TOKEN"), "oauth refresh token, asks to log in if empty")
+	listen := flag.String("listen", "", "address of the SMTP listener, e.g. localhost:2525, disabled if empty")
+	domains := flag.String("domains", email.DefaultDomain, "comma separated recipient domains")
+	allow := flag.String("allow", "", "comma separated sender addresses or @domains allowed to send, required with -listen")
+	allowAll := flag.Bool("allow-all", false, "accept mail from every sender instead of -allow")
+	relayAddr := flag.String("digest-relay", "", "host:port of the SMTP relay for digests of missed messages, disabled if empty")
+	relayUser := flag.String("digest-user", "", "username of the SMTP relay")
+	relayPassword := flag.String("digest-password", os.Getenv("HANGUPS_SMTP_PASSWORD"), "password of the SMTP relay")
+	from := flag.String("digest-from", "", "sender address of the digests")
+	to := flag.String("digest-to", "", "comma separated recipient addresses of the digests")
+	interval := flag.Duration("digest-interval", time.Hour, "how often digests are sent")
+	flag.Parse()
+
+	if *listen == "" && *relayAddr == "" {
+		log.Fatal("nothing to do, set -listen and/or -digest-relay")
+	}
+	if *listen != "" && *allow == "" && !*allowAll {
+		log.Fatal("set the senders allowed to use the gateway with -allow, or -allow-all")
+	}
+	if *relayAddr != "" && (*from == "" || *to == "") {
+		log.Fatal("-digest-from and -digest-to are required with -digest-relay")
+	}
+
+	session := &hangups.Session{RefreshToken: *refreshToken}
+	if err := session.Init(); err != nil {
+		log.Fatal(err)
+	}
+	client := &hangups.Client{Session: session}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	errs := make(chan error, 2)
+	if *listen != "" {
+		gateway := email.NewGateway(client)
+		gateway.Domains = strings.Split(*domains, ",")
+		if !*allowAll {
+			gateway.AllowedSenders = strings.Split(*allow, ",")
+		}
+		gateway.OnError = func(err error) {
+			log.Print(err)
+		}
+		log.Printf("accepting mail on %s", *listen)
+		go func() {
+			errs <- gateway.ListenAndServe(ctx, *listen)
+		}()
+	}
+	if *relayAddr != "" {
+		digest := email.NewDigest(client, &email.SMTPRelay{
+			Addr:     *relayAddr,
+			Username: *relayUser,
+			Password: *relayPassword,
+			From:     *from,
+			To:       strings.Split(*to, ","),
+		})
+		digest.Interval = *interval
+		digest.OnError = func(err error) {
+			log.Print(err)
+		}
+		log.Printf("sending digests to %s every %s", *to, *interval)
+		go func() {
+			errs <- digest.Run(ctx)
+		}()
+	}
+
+	if err := <-errs; err != nil && err != context.Canceled {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,34 @@
+// Command hangups-tui is a terminal chat client for hangouts
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mysqto/hangups"
+	"github.com/mysqto/hangups/tui"
+)
+
+func main() {
+	refreshToken := flag.String("refresh-token", os.Getenv("HANGUPS_REFRESH_TOKEN"), "oauth refresh token, asks to log in if empty")
+	interval := flag.Duration("interval", 3*time.Second, "polling interval")
+	flag.Parse()
+
+	session := &hangups.Session{RefreshToken: *refreshToken}
+	if err := session.Init(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	app := &tui.App{
+		Client:       &hangups.Client{Session: session},
+		PollInterval: *interval,
+	}
+	if err := app.Run(context.Background()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,93 @@
+// Command hangups-webhook posts incoming hangouts events to HTTP endpoints
+// and sends the messages posted to its own HTTP endpoint, see package webhook for the formats
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/mysqto/hangups"
+	"github.com/mysqto/hangups/webhook"
+)
+
+func main() {
+	refreshToken := flag.String("refresh-token", os.Getenv("HANGUPS_REFRESH_TOKEN"), "oauth refresh token, asks to log in if empty")
+	endpoints := flag.String("endpoint", "", "comma separated urls to post the events to")
+	secret := flag.String("secret", os.Getenv("HANGUPS_WEBHOOK_SECRET"), "HMAC secret signing the posts and checking inbound requests")
+	listen := flag.String("listen", "", "address of the inbound endpoint, e.g. :8080, disabled if empty")
+	path := flag.String("path", "/send", "path of the inbound endpoint")
+	allowFiles := flag.Bool("allow-files", false, "let inbound requests send image files of this host")
+	imageHosts := flag.String("image-hosts", "", "comma separated hosts images given by url may be downloaded from, any if empty")
+	includeOwn := flag.Bool("include-own", false, "also relay the events of the logged in user")
+	insecure := flag.Bool("insecure", false, "let the inbound endpoint accept unsigned requests when -secret is not set")
+	flag.Parse()
+
+	if *endpoints == "" && *listen == "" {
+		log.Fatal("nothing to do, set -endpoint and/or -listen")
+	}
+	if *listen != "" && *secret == "" {
+		if !*insecure {
+			log.Fatal("the inbound endpoint would accept unsigned requests, set -secret or -insecure")
+		}
+		log.Print("warning: the inbound endpoint accepts unsigned requests")
+	}
+
+	session := &hangups.Session{RefreshToken: *refreshToken}
+	if err := session.Init(); err != nil {
+		log.Fatal(err)
+	}
+	client := &hangups.Client{Session: session, EchoSuppressor: hangups.NewEchoSuppressor()}
+	for _, host := range strings.Split(*imageHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			client.ImageHosts = append(client.ImageHosts, host)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if *listen != "" {
+		handler := webhook.NewHandler(client, []byte(*secret))
+		handler.AllowFiles = *allowFiles
+		mux := http.NewServeMux()
+		mux.Handle(*path, handler)
+		server := &http.Server{Addr: *listen, Handler: mux}
+		go func() {
+			<-ctx.Done()
+			_ = server.Close()
+		}()
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+		log.Printf("accepting messages on %s%s", *listen, *path)
+	}
+
+	if *endpoints == "" {
+		<-ctx.Done()
+		return
+	}
+
+	relay := webhook.NewRelay(client, strings.Split(*endpoints, ",")...)
+	relay.Secret = []byte(*secret)
+	relay.IncludeOwn = *includeOwn
+	relay.OnError = func(err error) {
+		log.Print(err)
+	}
+	log.Printf("relaying events to %s", *endpoints)
+	if err := relay.Run(ctx); err != nil && err != context.Canceled {
+		log.Fatal(err)
+	}
+}
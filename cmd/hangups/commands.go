@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mysqto/hangups"
+	"github.com/mysqto/hangups/export"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// user is the output of whoami, search-users and presence
+type user struct {
+	GaiaID    string   `json:"gaia_id"`
+	Name      string   `json:"name,omitempty"`
+	Emails    []string `json:"emails,omitempty"`
+	Phones    []string `json:"phones,omitempty"`
+	Reachable *bool    `json:"reachable,omitempty"`
+	Available *bool    `json:"available,omitempty"`
+	Mood      string   `json:"mood,omitempty"`
+	LastSeen  string   `json:"last_seen,omitempty"`
+}
+
+// newUser converts an entity
+func newUser(entity *hangouts.Entity) *user {
+	return &user{
+		GaiaID: entity.GetId().GetGaiaId(),
+		Name:   entity.GetProperties().GetDisplayName(),
+		Emails: entity.GetProperties().GetEmail(),
+		Phones: entity.GetProperties().GetPhone(),
+	}
+}
+
+// String formats the user as a line of text
+func (u *user) String() string {
+	fields := []string{u.GaiaID, u.Name}
+	fields = append(fields, u.Emails...)
+	fields = append(fields, u.Phones...)
+	return strings.Join(fields, "\t")
+}
+
+// conversation is the output of conversations
+type conversation struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Type         string    `json:"type"`
+	Participants []string  `json:"participants"`
+	LastActivity time.Time `json:"last_activity"`
+}
+
+// sent is the output of send and send-image
+type sent struct {
+	ConversationID    string `json:"conversation_id"`
+	ClientGeneratedID uint64 `json:"client_generated_id"`
+	EventID           string `json:"event_id,omitempty"`
+	Parts             int    `json:"parts"`
+	PhotoID           string `json:"photo_id,omitempty"`
+}
+
+// watchedEvent is a line of watch output
+type watchedEvent struct {
+	ConversationID   string               `json:"conversation_id"`
+	ConversationName string               `json:"conversation_name"`
+	SenderName       string               `json:"sender_name,omitempty"`
+	Event            *export.ArchiveEvent `json:"event"`
+}
+
+// checkResult returns the error of a failed request or of its response
+func checkResult(header *hangouts.ResponseHeader, err error) error {
+	if err != nil {
+		return err
+	}
+	return hangups.CheckResponseHeader(header)
+}
+
+func runLogin(app *app, args []string) error {
+	session := &hangups.Session{Logger: app.logger}
+	if len(args) > 0 {
+		session.RefreshToken = args[0]
+	}
+	// asks for an auth code on the terminal if there is no refresh token
+	if err := session.Init(); err != nil {
+		return err
+	}
+	if err := app.saveRefreshToken(session.RefreshToken); err != nil {
+		return err
+	}
+	return app.print(map[string]string{"token_file": app.tokenFile}, "refresh token saved to "+app.tokenFile)
+}
+
+func runWhoami(app *app, args []string) error {
+	client, err := app.getClient()
+	if err != nil {
+		return err
+	}
+	info, err := client.GetSelfInfo()
+	if err = checkResult(info.GetResponseHeader(), err); err != nil {
+		return err
+	}
+	self := newUser(info.GetSelfEntity())
+	return app.print(self, self.String())
+}
+
+func runConversations(app *app, args []string) error {
+	flags := newFlagSet("conversations")
+	count := flags.Uint64("n", 50, "number of conversations")
+	flags.Parse(args)
+
+	client, err := app.getClient()
+	if err != nil {
+		return err
+	}
+	info, err := client.GetSelfInfo()
+	if err = checkResult(info.GetResponseHeader(), err); err != nil {
+		return err
+	}
+	selfID := info.GetSelfEntity().GetId().GetGaiaId()
+
+	response, err := client.SyncRecentConversations(*count, 1)
+	if err = checkResult(response.GetResponseHeader(), err); err != nil {
+		return err
+	}
+
+	conversations := make([]*conversation, 0)
+	for _, state := range response.GetConversationState() {
+		c := &conversation{
+			ID:           state.GetConversationId().GetId(),
+			Name:         conversationName(state.GetConversation(), selfID),
+			Type:         strings.TrimPrefix(state.GetConversation().GetType().String(), "CONVERSATION_TYPE_"),
+			Participants: make([]string, 0),
+			LastActivity: time.Unix(0, int64(state.GetConversation().GetSelfConversationState().GetSortTimestamp())*int64(time.Microsecond)),
+		}
+		for _, participant := range state.GetConversation().GetParticipantData() {
+			c.Participants = append(c.Participants, participant.GetId().GetGaiaId())
+		}
+		conversations = append(conversations, c)
+	}
+
+	if app.json {
+		return app.print(conversations, "")
+	}
+	for _, c := range conversations {
+		fmt.Printf("%s\t%s\t%s\n", c.ID, c.LastActivity.Format("2006-01-02 15:04"), c.Name)
+	}
+	return nil
+}
+
+// conversationName returns the name of a conversation or the names of the other participants
+func conversationName(conversation *hangouts.Conversation, selfID string) string {
+	if conversation.GetName() != "" {
+		return conversation.GetName()
+	}
+	names := make([]string, 0)
+	for _, participant := range conversation.GetParticipantData() {
+		if participant.GetId().GetGaiaId() == selfID {
+			continue
+		}
+		if name := participant.GetFallbackName(); name != "" {
+			names = append(names, name)
+		} else {
+			names = append(names, participant.GetId().GetGaiaId())
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func runHistory(app *app, args []string) error {
+	flags := newFlagSet("history")
+	count := flags.Int("n", 50, "number of events, 0 for the complete history")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	client, err := app.getClient()
+	if err != nil {
+		return err
+	}
+	fetched, err := export.FetchConversation(context.Background(), client, flags.Arg(0), &export.FetchOptions{MaxEvents: *count})
+	if err != nil {
+		return err
+	}
+
+	archive := export.NewArchive(fetched)
+	if app.json {
+		return export.WriteJSON(os.Stdout, archive)
+	}
+	for _, event := range archive.Events {
+		fmt.Println(export.FormatEvent(archive, event))
+	}
+	return nil
+}
+
+// newSent converts the result of a send
+func newSent(message *hangups.SentMessage) *sent {
+	output := &sent{
+		ConversationID:    message.ConversationID,
+		ClientGeneratedID: message.ClientGeneratedID,
+		Parts:             1,
+	}
+	if message.Event != nil {
+		output.EventID = message.Event.GetEventId()
+	}
+	if len(message.Parts) > 0 {
+		output.Parts = len(message.Parts)
+	}
+	return output
+}
+
+func runSend(app *app, args []string) error {
+	flags := newFlagSet("send")
+	action := flags.Bool("me", false, "send as an action message")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	text := strings.Join(flags.Args()[1:], " ")
+	if text == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		text = strings.TrimRight(string(data), "\n")
+	}
+	if text == "" {
+		return errors.New("message is empty")
+	}
+
+	client, err := app.getClient()
+	if err != nil {
+		return err
+	}
+	options := make([]hangups.MessageOption, 0)
+	if *action {
+		options = append(options, hangups.WithAction())
+	}
+	message, err := client.Send(flags.Arg(0), text, options...)
+	if err != nil {
+		return err
+	}
+	output := newSent(message)
+	return app.print(output, "sent to "+output.ConversationID)
+}
+
+func runSendImage(app *app, args []string) error {
+	flags := newFlagSet("send-image")
+	caption := flags.String("caption", "", "text sent with the image")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	client, err := app.getClient()
+	if err != nil {
+		return err
+	}
+
+	source := hangups.MediaSourceFile
+	if strings.HasPrefix(flags.Arg(1), "http://") || strings.HasPrefix(flags.Arg(1), "https://") {
+		source = hangups.MediaSourceURL
+	}
+	photo, err := client.UploadImageFrom(context.Background(), source, flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	message, err := client.Send(flags.Arg(0), *caption, hangups.WithPhoto(photo.ImageID))
+	if err != nil {
+		return err
+	}
+	output := newSent(message)
+	output.PhotoID = photo.ImageID
+	return app.print(output, "sent to "+output.ConversationID)
+}
+
+func runWatch(app *app, args []string) error {
+	flags := newFlagSet("watch")
+	interval := flags.Duration("interval", 5*time.Second, "polling interval")
+	flags.Parse(args)
+
+	client, err := app.getClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	defer signal.Stop(interrupted)
+	go func() {
+		select {
+		case <-interrupted:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	poller := hangups.NewPoller(client)
+	poller.Interval = *interval
+	poller.OnError = func(err error) {
+		fmt.Fprintf(os.Stderr, "hangups watch : %v\n", err)
+	}
+
+	var printErr error
+	err = poller.Run(ctx, func(conversation *hangouts.Conversation, event *hangouts.Event) {
+		state := &hangouts.ConversationState{
+			ConversationId: conversation.GetConversationId(),
+			Conversation:   conversation,
+			Event:          []*hangouts.Event{event},
+		}
+		archive := export.NewArchive(export.NewConversation(state))
+		name := conversationName(conversation, poller.SelfID())
+		for _, archived := range archive.Events {
+			watched := &watchedEvent{
+				ConversationID:   archive.ConversationID,
+				ConversationName: name,
+				SenderName:       archive.Participant(archived.SenderID),
+				Event:            archived,
+			}
+			if printErr = app.print(watched, "["+name+"] "+export.FormatEvent(archive, archived)); printErr != nil {
+				cancel()
+				return
+			}
+		}
+	})
+	if printErr != nil {
+		return printErr
+	}
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
+
+func runSearchUsers(app *app, args []string) error {
+	flags := newFlagSet("search-users")
+	count := flags.Uint64("n", 20, "maximum number of results")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	client, err := app.getClient()
+	if err != nil {
+		return err
+	}
+	response, err := client.SearchEntities(strings.Join(flags.Args(), " "), *count)
+	if err = checkResult(response.GetResponseHeader(), err); err != nil {
+		return err
+	}
+
+	users := make([]*user, 0)
+	for _, entity := range response.GetEntity() {
+		users = append(users, newUser(entity))
+	}
+	if app.json {
+		return app.print(users, "")
+	}
+	for _, u := range users {
+		fmt.Println(u)
+	}
+	return nil
+}
+
+func runRename(app *app, args []string) error {
+	flags := newFlagSet("rename")
+	flags.Parse(args)
+	args = flags.Args()
+	if len(args) < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	client, err := app.getClient()
+	if err != nil {
+		return err
+	}
+	name := strings.Join(args[1:], " ")
+	response, err := client.RenameConversation(args[0], name)
+	if err = checkResult(response.GetResponseHeader(), err); err != nil {
+		return err
+	}
+	return app.print(map[string]string{"conversation_id": args[0], "name": name}, "renamed "+args[0]+" to "+name)
+}
+
+func runLeave(app *app, args []string) error {
+	flags := newFlagSet("leave")
+	flags.Parse(args)
+	args = flags.Args()
+	if len(args) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	client, err := app.getClient()
+	if err != nil {
+		return err
+	}
+	response, err := client.RemoveUser(args[0])
+	if err = checkResult(response.GetResponseHeader(), err); err != nil {
+		return err
+	}
+	return app.print(map[string]string{"conversation_id": args[0]}, "left "+args[0])
+}
+
+func runPresence(app *app, args []string) error {
+	flags := newFlagSet("presence")
+	flags.Parse(args)
+	args = flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	client, err := app.getClient()
+	if err != nil {
+		return err
+	}
+
+	users := make([]*user, 0)
+	for _, gaiaID := range args {
+		response, err := client.QueryPresence(gaiaID)
+		if err = checkResult(response.GetResponseHeader(), err); err != nil {
+			return err
+		}
+		for _, result := range response.GetPresenceResult() {
+			users = append(users, newPresence(result))
+		}
+	}
+
+	if app.json {
+		return app.print(users, "")
+	}
+	for _, u := range users {
+		state := "unavailable"
+		if u.Available != nil && *u.Available {
+			state = "available"
+		} else if u.Reachable != nil && *u.Reachable {
+			state = "reachable"
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", u.GaiaID, state, u.LastSeen, u.Mood)
+	}
+	return nil
+}
+
+// newPresence converts a presence result
+func newPresence(result *hangouts.PresenceResult) *user {
+	presence := result.GetPresence()
+	u := &user{
+		GaiaID:    result.GetUserId().GetGaiaId(),
+		Reachable: proto.Bool(presence.GetReachable()),
+		Available: proto.Bool(presence.GetAvailable()),
+	}
+	for _, segment := range presence.GetMoodMessage().GetMoodContent().GetSegment() {
+		u.Mood += segment.GetText()
+	}
+	if usec := presence.GetLastSeen().GetLastSeenTimestampUsec(); usec > 0 {
+		u.LastSeen = time.Unix(0, int64(usec)*int64(time.Microsecond)).Format(time.RFC3339)
+	}
+	return u
+}
@@ -0,0 +1,192 @@
+// Command hangups is a command line client for hangouts.
+//
+// Usage:
+//
+//	hangups [-json] [-token-file path] <command> [arguments]
+//
+// The refresh token is read from HANGUPS_REFRESH_TOKEN or the token file written by "hangups login".
+// With -json every command writes JSON, streaming commands write one JSON object per line.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mysqto/hangups"
+)
+
+// command is a subcommand of the client
+type command struct {
+	usage       string
+	description string
+	run         func(app *app, args []string) error
+}
+
+// commands is filled in by init, the commands refer to it for their usage
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"login":         {"login [refresh-token]", "log in and store the refresh token", runLogin},
+		"whoami":        {"whoami", "show the logged in user", runWhoami},
+		"conversations": {"conversations [-n count]", "list recent conversations", runConversations},
+		"history":       {"history [-n count] <conversation>", "show the events of a conversation", runHistory},
+		"send":          {"send [-me] <target> <text|->", "send a message, - reads it from stdin", runSend},
+		"send-image":    {"send-image [-caption text] <target> <file|url>", "send an image", runSendImage},
+		"watch":         {"watch [-interval duration]", "print incoming events until interrupted", runWatch},
+		"search-users":  {"search-users [-n count] <query>", "search users by name, email or phone", runSearchUsers},
+		"rename":        {"rename <conversation> <name>", "rename a group conversation", runRename},
+		"leave":         {"leave <conversation>", "leave a group conversation", runLeave},
+		"presence":      {"presence <gaia-id>...", "show the presence of users", runPresence},
+	}
+}
+
+// app holds the global options and the lazily created client
+type app struct {
+	json      bool
+	tokenFile string
+	logger    hangups.Logger // nil unless -v is set
+	recordDir string
+	unknown   *hangups.UnknownFieldReport // nil unless -unknown-fields is set
+	client    *hangups.Client
+}
+
+func main() {
+	app := &app{}
+	flag.BoolVar(&app.json, "json", false, "write JSON output")
+	flag.StringVar(&app.tokenFile, "token-file", defaultTokenFile(), "file holding the refresh token")
+	verbose := flag.Bool("v", false, "log requests to stderr")
+	flag.StringVar(&app.recordDir, "record", "", "directory to record the API requests and responses to")
+	unknownFields := flag.Bool("unknown-fields", false, "report the response fields missing in hangouts.proto to stderr")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *verbose {
+		app.logger = hangups.NewStdLogger(log.New(os.Stderr, "", log.LstdFlags), hangups.LevelDebug)
+	}
+	if *unknownFields {
+		app.unknown = hangups.NewUnknownFieldReport()
+	}
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	err := cmd.run(app, flag.Args()[1:])
+	if app.unknown != nil {
+		_, _ = app.unknown.WriteTo(os.Stderr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hangups %s : %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the global flags and the commands
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: hangups [-json] [-token-file path] <command> [arguments]")
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-50s %s\n", commands[name].usage, commands[name].description)
+	}
+}
+
+// defaultTokenFile returns the token file in the user config directory
+func defaultTokenFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "hangups-refresh-token"
+	}
+	return filepath.Join(dir, "hangups", "refresh_token")
+}
+
+// newFlagSet creates the flag set of a command, its usage line comes from the command table
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: hangups %s\n", commands[name].usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// refreshToken returns the stored refresh token
+func (a *app) refreshToken() (string, error) {
+	if token := os.Getenv("HANGUPS_REFRESH_TOKEN"); token != "" {
+		return token, nil
+	}
+	data, err := ioutil.ReadFile(a.tokenFile)
+	if os.IsNotExist(err) {
+		return "", errors.New("not logged in, run hangups login first")
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// saveRefreshToken stores the refresh token, readable only by the user
+func (a *app) saveRefreshToken(token string) error {
+	if err := os.MkdirAll(filepath.Dir(a.tokenFile), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(a.tokenFile, []byte(token+"\n"), 0600)
+}
+
+// getClient returns a client logged in with the stored refresh token
+func (a *app) getClient() (*hangups.Client, error) {
+	if a.client != nil {
+		return a.client, nil
+	}
+	token, err := a.refreshToken()
+	if err != nil {
+		return nil, err
+	}
+	session := &hangups.Session{RefreshToken: token, Logger: a.logger}
+	if err = session.Init(); err != nil {
+		return nil, err
+	}
+	a.client = &hangups.Client{Session: session, Logger: a.logger}
+	if a.unknown != nil {
+		a.client.OnUnknownFields = a.unknown.Add
+	}
+	if a.recordDir != "" {
+		if a.client.Recorder, err = hangups.NewRecorder(a.recordDir); err != nil {
+			return nil, err
+		}
+	}
+	return a.client, nil
+}
+
+// print writes v as JSON, or text as is
+func (a *app) print(v interface{}, text string) error {
+	if a.json {
+		return json.NewEncoder(os.Stdout).Encode(v)
+	}
+	if text != "" {
+		fmt.Println(text)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package hangups
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// defaultMaxDownloadSize is used when Client.MaxDownloadSize is not set
+const defaultMaxDownloadSize = 50 * 1024 * 1024
+
+// ErrDownloadTooLarge is returned when an attachment or an image given by url exceeds the download size limit
+var ErrDownloadTooLarge = errors.New("attachment exceeds the maximum download size")
+
+// authenticatedHosts are the domains which may need session cookies to serve media
+var authenticatedHosts = []string{"google.com", "googleusercontent.com", "ggpht.com"}
+
+var (
+	// size options of googleusercontent urls, e.g. "=w640-h480" or "=s220-c"
+	sizeSuffix = regexp.MustCompile(`=[swh]\d+[-\w]*$`)
+	// size options in the path of older photo urls, e.g. "/s640/"
+	sizePath = regexp.MustCompile(`/(s|w|h)\d+(-[\w]+)*/`)
+)
+
+// DownloadInfo describes a completed attachment download
+type DownloadInfo struct {
+	URL      string // the url the content was downloaded from
+	MimeType string
+	Size     int64 // number of bytes written
+}
+
+// bestAttachmentURL picks the url of the highest resolution version of an attachment
+func bestAttachmentURL(attachment *Attachment) string {
+	if attachment.DownloadURL != "" {
+		return attachment.DownloadURL
+	}
+
+	mediaURL := firstNonEmpty(attachment.ContentURL, attachment.ImageURL)
+	if mediaURL == "" {
+		return ""
+	}
+
+	uri, err := url.Parse(mediaURL)
+	if err != nil || !strings.HasSuffix(uri.Hostname(), "googleusercontent.com") {
+		return mediaURL
+	}
+
+	// ask for the original size instead of the resized preview
+	if sizeSuffix.MatchString(uri.Path) {
+		uri.Path = sizeSuffix.ReplaceAllString(uri.Path, "=s0")
+	} else if sizePath.MatchString(uri.Path) {
+		uri.Path = sizePath.ReplaceAllString(uri.Path, "/s0/")
+	}
+	return uri.String()
+}
+
+// needsAuthentication checks if a media url is served by google and may need the session cookies
+func needsAuthentication(mediaURL string) bool {
+	uri, err := url.Parse(mediaURL)
+	if err != nil {
+		return false
+	}
+	host := uri.Hostname()
+	for _, domain := range authenticatedHosts {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxDownloadSize returns Client.MaxDownloadSize or its default
+func (c *Client) maxDownloadSize() int64 {
+	if c.MaxDownloadSize <= 0 {
+		return defaultMaxDownloadSize
+	}
+	return c.MaxDownloadSize
+}
+
+// readLimited reads a response body of at most maxSize bytes, larger bodies fail with ErrDownloadTooLarge
+func readLimited(resp *http.Response, maxSize int64) ([]byte, error) {
+	if resp.ContentLength > maxSize {
+		return nil, ErrDownloadTooLarge
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrDownloadTooLarge
+	}
+	return data, nil
+}
+
+// mediaRequest performs a GET request for media, adding the session authentication for google hosts
+func (c *Client) mediaRequest(ctx context.Context, mediaURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	if needsAuthentication(mediaURL) && c.Session != nil {
+		for headerKey, headerVal := range GetAuthHeaders(c.Session.Sapisid) {
+			req.Header.Set(headerKey, headerVal)
+		}
+		req.Header.Set("Cookie", c.Session.Cookies)
+	}
+
+	return c.httpClient().Do(req)
+}
+
+// DownloadAttachment downloads the best resolution version of a photo or video attachment to w.
+// The download is aborted with ErrDownloadTooLarge if it exceeds Client.MaxDownloadSize,
+// w may then hold up to MaxDownloadSize bytes of partial content.
+func (c *Client) DownloadAttachment(ctx context.Context, attachment *Attachment, w io.Writer) (*DownloadInfo, error) {
+	mediaURL := bestAttachmentURL(attachment)
+	if mediaURL == "" {
+		return nil, fmt.Errorf("%s attachment %s has no downloadable content", attachment.Type, attachment.ID)
+	}
+
+	maxSize := c.maxDownloadSize()
+	resp, err := c.mediaRequest(ctx, mediaURL)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting attachment from %s : %v", mediaURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading attachment from %s : %s", mediaURL, resp.Status)
+	}
+
+	if resp.ContentLength > maxSize {
+		return nil, ErrDownloadTooLarge
+	}
+
+	body := bufio.NewReader(resp.Body)
+	mimeType := resp.Header.Get("Content-Type")
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		// Peek returns what it could read on a short body, which is all we need for sniffing
+		head, _ := body.Peek(512)
+		mimeType = http.DetectContentType(head)
+	}
+
+	size, err := io.Copy(w, io.LimitReader(body, maxSize))
+	if err != nil {
+		return nil, fmt.Errorf("error downloading attachment from %s : %v", mediaURL, err)
+	}
+	// bodies without a content length are only known to be too large once one more byte arrives,
+	// w never gets more than maxSize bytes
+	if size == maxSize {
+		if _, err = body.ReadByte(); err == nil {
+			return nil, ErrDownloadTooLarge
+		} else if err != io.EOF {
+			return nil, fmt.Errorf("error downloading attachment from %s : %v", mediaURL, err)
+		}
+	}
+
+	return &DownloadInfo{
+		URL:      mediaURL,
+		MimeType: mimeType,
+		Size:     size,
+	}, nil
+}
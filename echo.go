@@ -0,0 +1,105 @@
+package hangups
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mysqto/hangups/internal/idset"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// defaultEchoCapacity is the number of sent messages an EchoSuppressor remembers if Capacity is not set
+const defaultEchoCapacity = 10000
+
+// fallbackRand is only used if the system random source fails
+var fallbackRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// newClientGeneratedID returns a random non zero 64 bit id, unique across processes
+func newClientGeneratedID() uint64 {
+	var buffer [8]byte
+	var id uint64
+	for id == 0 {
+		if _, err := crand.Read(buffer[:]); err == nil {
+			id = binary.BigEndian.Uint64(buffer[:])
+		} else {
+			id = fallbackRand.Uint64()
+		}
+	}
+	return id
+}
+
+// SentMessage is a message sent by this client
+type SentMessage struct {
+	ConversationID    string
+	ClientGeneratedID uint64
+	Event             *hangouts.Event // the created_event of the response, may be nil
+	Parts             []*SentMessage  // every part in order if the message was split, this is the last one
+}
+
+// EchoSuppressor remembers the client generated ids and event ids of sent messages so that
+// they can be recognised when they come back through SyncAllNewEvents or other event streams.
+// Set it as Client.EchoSuppressor to register every send automatically.
+type EchoSuppressor struct {
+	Capacity int // maximum number of remembered messages, 10000 if not set
+
+	once sync.Once
+	ids  *idset.Set
+}
+
+// NewEchoSuppressor creates an EchoSuppressor with the default capacity
+func NewEchoSuppressor() *EchoSuppressor {
+	return &EchoSuppressor{}
+}
+
+// set returns the remembered ids, created with the capacity on first use
+func (e *EchoSuppressor) set() *idset.Set {
+	e.once.Do(func() {
+		capacity := e.Capacity
+		if capacity <= 0 {
+			capacity = defaultEchoCapacity
+		}
+		e.ids = idset.New(capacity)
+	})
+	return e.ids
+}
+
+// remember stores an id, evicting the oldest ones above the capacity
+func (e *EchoSuppressor) remember(id string) {
+	e.set().Add(id)
+}
+
+// seen checks if an id is remembered
+func (e *EchoSuppressor) seen(id string) bool {
+	return e.set().Has(id)
+}
+
+// Add remembers the client generated id of a sent message
+func (e *EchoSuppressor) Add(clientGeneratedID uint64) {
+	e.remember("client:" + strconv.FormatUint(clientGeneratedID, 10))
+}
+
+// AddEvent remembers the event created by a send
+func (e *EchoSuppressor) AddEvent(event *hangouts.Event) {
+	if event == nil {
+		return
+	}
+	if event.GetEventId() != "" {
+		e.remember("event:" + event.GetEventId())
+	}
+	if clientGeneratedID := event.GetSelfEventState().GetClientGeneratedId(); clientGeneratedID != "" {
+		e.remember("client:" + clientGeneratedID)
+	}
+}
+
+// IsEcho returns true if the event is one of the messages sent through this suppressor
+func (e *EchoSuppressor) IsEcho(event *hangouts.Event) bool {
+	if clientGeneratedID := event.GetSelfEventState().GetClientGeneratedId(); clientGeneratedID != "" &&
+		e.seen("client:"+clientGeneratedID) {
+		return true
+	}
+	return event.GetEventId() != "" && e.seen("event:"+event.GetEventId())
+}
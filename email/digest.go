@@ -0,0 +1,220 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// SMTPRelay is the mail server the digests are sent through
+type SMTPRelay struct {
+	Addr     string // host:port
+	Username string // PLAIN authentication is used if set, the relay must support TLS unless it is localhost
+	Password string
+	From     string
+	To       []string
+}
+
+// Send sends a message with a plain text body through the relay
+func (r *SMTPRelay) Send(subject, body string) error {
+	host, _, err := net.SplitHostPort(r.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid relay address %s : %v", r.Addr, err)
+	}
+	var auth smtp.Auth
+	if r.Username != "" {
+		auth = smtp.PlainAuth("", r.Username, r.Password, host)
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", r.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(r.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	writer := quotedprintable.NewWriter(&message)
+	if _, err = writer.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	return smtp.SendMail(r.Addr, auth, r.From, r.To, message.Bytes())
+}
+
+// missedMessage is a message of another user which was not read yet
+type missedMessage struct {
+	timestamp uint64
+	sender    string
+	text      string
+}
+
+// Digest emails the messages of other users which are still unread after an interval
+type Digest struct {
+	Client   *hangups.Client
+	Relay    *SMTPRelay
+	Interval time.Duration // how often digests are sent, 1h if not set
+	Subject  string        // "Missed hangouts messages" if not set
+	// OnError is called for failed polls and digests which could not be sent, optional
+	OnError func(err error)
+
+	mu     sync.Mutex
+	missed map[string][]*missedMessage
+}
+
+// NewDigest creates a digest of the messages of client sent through relay
+func NewDigest(client *hangups.Client, relay *SMTPRelay) *Digest {
+	return &Digest{Client: client, Relay: relay}
+}
+
+// Run collects new messages and sends a digest of the unread ones every Interval until ctx is done
+func (d *Digest) Run(ctx context.Context) error {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	d.mu.Lock()
+	d.missed = make(map[string][]*missedMessage)
+	d.mu.Unlock()
+
+	poller := hangups.NewPoller(d.Client)
+	poller.OnError = d.OnError
+	errs := make(chan error, 1)
+	go func() {
+		errs <- poller.Run(ctx, func(conversation *hangouts.Conversation, event *hangouts.Event) {
+			d.add(poller.SelfID(), conversation, event)
+		})
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case <-ticker.C:
+		}
+		if body := d.body(poller); body != "" {
+			subject := d.Subject
+			if subject == "" {
+				subject = "Missed hangouts messages"
+			}
+			if err := d.Relay.Send(subject, body); err != nil && d.OnError != nil {
+				d.OnError(fmt.Errorf("error sending digest : %v", err))
+			}
+		}
+	}
+}
+
+// add records a chat message of another user
+func (d *Digest) add(selfID string, conversation *hangouts.Conversation, event *hangouts.Event) {
+	if event.GetChatMessage() == nil {
+		return
+	}
+	message := hangups.NewMessage(event)
+	if message.SenderID() == selfID {
+		return
+	}
+
+	text := message.Text()
+	if message.IsAction() {
+		text = "* " + text
+	}
+	for _, attachment := range message.Attachments() {
+		url := attachment.URL
+		if url == "" {
+			url = attachment.ImageURL
+		}
+		text = strings.TrimSpace(text + "\n" + url)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	conversationID := message.ConversationID()
+	d.missed[conversationID] = append(d.missed[conversationID], &missedMessage{
+		timestamp: event.GetTimestamp(),
+		sender:    participantName(conversation, message.SenderID()),
+		text:      text,
+	})
+}
+
+// body drops the messages which have been read and formats the others, "" if there are none
+func (d *Digest) body(poller *hangups.Poller) string {
+	d.mu.Lock()
+	missed := d.missed
+	d.missed = make(map[string][]*missedMessage)
+	d.mu.Unlock()
+
+	conversationIDs := make([]string, 0, len(missed))
+	for conversationID := range missed {
+		conversationIDs = append(conversationIDs, conversationID)
+	}
+	sort.Strings(conversationIDs)
+
+	var body strings.Builder
+	for _, conversationID := range conversationIDs {
+		conversation := poller.Conversation(conversationID)
+		readTimestamp := conversation.GetSelfConversationState().GetSelfReadState().GetLatestReadTimestamp()
+		var unread []*missedMessage
+		for _, message := range missed[conversationID] {
+			if message.timestamp > readTimestamp {
+				unread = append(unread, message)
+			}
+		}
+		if len(unread) == 0 {
+			continue
+		}
+
+		title := fmt.Sprintf("%s (%d)", conversationName(conversation, poller.SelfID()), len(unread))
+		fmt.Fprintf(&body, "%s\n%s\n", title, strings.Repeat("=", len([]rune(title))))
+		for _, message := range unread {
+			timestamp := time.Unix(0, int64(message.timestamp)*int64(time.Microsecond))
+			text := strings.ReplaceAll(message.text, "\n", "\n    ")
+			fmt.Fprintf(&body, "[%s] %s: %s\n", timestamp.Format("Jan 2 15:04"), message.sender, text)
+		}
+		body.WriteString("\n")
+	}
+	return strings.TrimSpace(body.String())
+}
+
+// participantName returns the name of a participant, its id if the name is unknown
+func participantName(conversation *hangouts.Conversation, gaiaID string) string {
+	for _, participant := range conversation.GetParticipantData() {
+		if participant.GetId().GetGaiaId() == gaiaID && participant.GetFallbackName() != "" {
+			return participant.GetFallbackName()
+		}
+	}
+	return gaiaID
+}
+
+// conversationName returns the name of a conversation, or the names of the other participants
+func conversationName(conversation *hangouts.Conversation, selfID string) string {
+	if name := conversation.GetName(); name != "" {
+		return name
+	}
+	var names []string
+	for _, participant := range conversation.GetParticipantData() {
+		if participant.GetId().GetGaiaId() != selfID && participant.GetFallbackName() != "" {
+			names = append(names, participant.GetFallbackName())
+		}
+	}
+	if len(names) == 0 {
+		return conversation.GetConversationId().GetId()
+	}
+	return strings.Join(names, ", ")
+}
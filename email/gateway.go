@@ -0,0 +1,204 @@
+// Package email relays email to hangouts through an embedded SMTP server, for systems which can
+// only send email, and emails digests of missed hangouts messages.
+//
+// The recipient address picks the target of a message:
+//
+//	<conversation id>@hangups.local    a conversation
+//	<gaia id>@hangups.local            a one to one conversation with a user
+//	jane=example.com@hangups.local     a one to one conversation with jane@example.com
+//	+15551234567@hangups.local         a one to one conversation with a phone number
+//
+// Conversation ids are case sensitive, mail servers relaying to the gateway must keep the case.
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// DefaultDomain is the recipient domain accepted when Gateway.Domains is empty
+const DefaultDomain = "hangups.local"
+
+// Sender is the part of hangups.Client used to send the relayed email
+type Sender interface {
+	SendSegments(to string, segments []*hangouts.Segment, options ...hangups.MessageOption) (*hangups.SentMessage, error)
+	UploadImage(image string) (*hangups.Photo, error)
+}
+
+// Gateway relays email to hangouts, see Serve for the SMTP server
+type Gateway struct {
+	Sender  Sender
+	Domains []string // accepted recipient domains, DefaultDomain if empty
+	// AllowedSenders are the envelope senders allowed to use the gateway, as addresses or as "@domain"
+	// for every address of a domain. Every sender is allowed if empty. Envelope senders are not
+	// authenticated, the listener must only be reachable by trusted hosts.
+	AllowedSenders []string
+	Hostname       string // announced in the SMTP greeting, "localhost" if not set
+	MaxSize        int64  // maximum message size in bytes, 10MB if not set
+	MaxImages      int    // maximum images relayed per message, 10 if not set
+	// OnError is called for messages which could not be relayed, optional
+	OnError func(err error)
+}
+
+// NewGateway creates a gateway sending with sender and accepting mail from allowedSenders
+func NewGateway(sender Sender, allowedSenders ...string) *Gateway {
+	return &Gateway{Sender: sender, AllowedSenders: allowedSenders}
+}
+
+// Allowed checks if an envelope sender may use the gateway
+func (g *Gateway) Allowed(sender string) bool {
+	if len(g.AllowedSenders) == 0 {
+		return true
+	}
+	sender = strings.ToLower(sender)
+	for _, allowed := range g.AllowedSenders {
+		allowed = strings.ToLower(allowed)
+		if sender == allowed || (strings.HasPrefix(allowed, "@") && strings.HasSuffix(sender, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Recipient resolves a recipient address to the hangouts target accepted by hangups.Client.Send
+func (g *Gateway) Recipient(address string) (string, error) {
+	at := strings.LastIndex(address, "@")
+	if at <= 0 {
+		return "", fmt.Errorf("invalid recipient %s", address)
+	}
+	local, domain := address[:at], strings.ToLower(address[at+1:])
+
+	domains := g.Domains
+	if len(domains) == 0 {
+		domains = []string{DefaultDomain}
+	}
+	accepted := false
+	for _, d := range domains {
+		if strings.ToLower(d) == domain {
+			accepted = true
+		}
+	}
+	if !accepted {
+		return "", fmt.Errorf("relaying to %s is not allowed", domain)
+	}
+
+	if strings.HasPrefix(local, `"`) && strings.HasSuffix(local, `"`) && len(local) > 1 {
+		local = strings.ReplaceAll(local[1:len(local)-1], `\`, "")
+	}
+	if i := strings.LastIndex(local, "="); i > 0 && !strings.Contains(local, "@") {
+		local = local[:i] + "@" + local[i+1:]
+	}
+	if strings.Contains(local, "@") {
+		if _, err := mail.ParseAddress(local); err != nil {
+			return "", fmt.Errorf("invalid recipient %s", address)
+		}
+	}
+	if local == "" {
+		return "", fmt.Errorf("invalid recipient %s", address)
+	}
+	return local, nil
+}
+
+// Deliver relays a message to the targets of the recipients, images are uploaded once for all of them
+func (g *Gateway) Deliver(ctx context.Context, recipients []string, message *Message) error {
+	maxImages := g.MaxImages
+	if maxImages <= 0 {
+		maxImages = 10
+	}
+
+	text := message.Text
+	photoIDs := make([]string, 0)
+	for i, image := range message.Images {
+		if i >= maxImages {
+			message.Skipped = append(message.Skipped, image.Name)
+			continue
+		}
+		photo, err := g.Sender.UploadImage(base64.StdEncoding.EncodeToString(image.Data))
+		if err != nil {
+			return fmt.Errorf("error uploading image %s : %v", image.Name, err)
+		}
+		photoIDs = append(photoIDs, photo.ImageID)
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+	}
+	if len(message.Skipped) > 0 {
+		text = strings.TrimSpace(text + "\n\n(attachments not relayed: " + strings.Join(message.Skipped, ", ") + ")")
+	}
+
+	var errs []string
+	for _, recipient := range recipients {
+		target, err := g.Recipient(recipient)
+		if err == nil {
+			err = g.send(target, message.Subject, text, photoIDs)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s : %v", recipient, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// send sends the subject in bold with the text and the first photo, further photos follow on their own
+func (g *Gateway) send(target, subject, text string, photoIDs []string) error {
+	segments := make([]*hangouts.Segment, 0)
+	if subject != "" {
+		segments = append(segments, &hangouts.Segment{
+			Type:       hangouts.SegmentType_SEGMENT_TYPE_TEXT.Enum(),
+			Text:       proto.String(subject),
+			Formatting: &hangouts.Formatting{Bold: proto.Bool(true)},
+		})
+		if text != "" {
+			segments = append(segments, &hangouts.Segment{
+				Type: hangouts.SegmentType_SEGMENT_TYPE_LINE_BREAK.Enum(),
+				Text: proto.String("\n"),
+			})
+		}
+	}
+	segments = append(segments, hangups.TextToSegments(text)...)
+
+	for i := 0; i == 0 || i < len(photoIDs); i++ {
+		var options []hangups.MessageOption
+		if i < len(photoIDs) {
+			options = append(options, hangups.WithPhoto(photoIDs[i]))
+		}
+		if i > 0 {
+			segments = nil
+		}
+		if len(segments) == 0 && len(options) == 0 {
+			return nil
+		}
+		if _, err := g.Sender.SendSegments(target, segments, options...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeliverData parses a raw message and relays it
+func (g *Gateway) DeliverData(ctx context.Context, recipients []string, data []byte) error {
+	message, err := ParseMessage(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid message : %v", err)
+	}
+	return g.Deliver(ctx, recipients, message)
+}
+
+// error reports an error to OnError
+func (g *Gateway) error(err error) {
+	if g.OnError != nil {
+		g.OnError(err)
+	}
+}
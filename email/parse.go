@@ -0,0 +1,232 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// maxParts limits the number of MIME parts of a message
+const maxParts = 100
+
+// Message is the content of an email relevant to hangouts
+type Message struct {
+	From    string // address of the From header
+	Subject string
+	Text    string   // the text part, or the HTML part converted to text
+	Images  []*Image // image attachments and inline images
+	Skipped []string // names of attachments which cannot be sent
+}
+
+// Image is an image of an email
+type Image struct {
+	Name string
+	Data []byte
+}
+
+// ParseMessage parses an RFC 5322 message
+func ParseMessage(r io.Reader) (*Message, error) {
+	parsed, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := &mime.WordDecoder{CharsetReader: charsetReader}
+	message := &Message{}
+	if subject, err := decoder.DecodeHeader(parsed.Header.Get("Subject")); err == nil {
+		message.Subject = strings.TrimSpace(subject)
+	}
+	if from, err := parsed.Header.AddressList("From"); err == nil && len(from) > 0 {
+		message.From = from[0].Address
+	}
+
+	var plain, htmlText string
+	parts := 0
+	err = walkPart(parsed.Header, parsed.Body, &parts, func(contentType, name string, body []byte) {
+		switch {
+		case strings.HasPrefix(contentType, "image/"):
+			message.Images = append(message.Images, &Image{Name: name, Data: body})
+		case name != "":
+			message.Skipped = append(message.Skipped, name)
+		case contentType == "text/plain" && plain == "":
+			plain = string(body)
+		case contentType == "text/html" && htmlText == "":
+			htmlText = string(body)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(plain) != "" {
+		message.Text = normalizeText(plain)
+	} else {
+		message.Text = HTMLToText(htmlText)
+	}
+	return message, nil
+}
+
+// header is a message or MIME part header
+type header interface {
+	Get(key string) string
+}
+
+// walkPart decodes a MIME part and calls visit for every leaf part with its media type,
+// its file name for attachments and its decoded content
+func walkPart(h header, body io.Reader, parts *int, visit func(contentType, name string, body []byte)) error {
+	if *parts++; *parts > maxParts {
+		return fmt.Errorf("more than %d MIME parts", maxParts)
+	}
+
+	contentType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		contentType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(contentType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = walkPart(part.Header, part, parts, visit); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, &newlineSkipper{r: body})
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	name := ""
+	if _, dispositionParams, err := mime.ParseMediaType(h.Get("Content-Disposition")); err == nil {
+		name = dispositionParams["filename"]
+	}
+	if name == "" {
+		name = params["name"]
+	}
+	if name == "" && strings.HasPrefix(h.Get("Content-Disposition"), "attachment") {
+		name = "attachment"
+	}
+
+	if strings.HasPrefix(contentType, "text/") && name == "" {
+		data = toUTF8(data, params["charset"])
+	}
+	visit(contentType, name, data)
+	return nil
+}
+
+// newlineSkipper drops line breaks, base64 bodies are wrapped
+type newlineSkipper struct {
+	r io.Reader
+}
+
+func (n *newlineSkipper) Read(p []byte) (int, error) {
+	count, err := n.r.Read(p)
+	kept := 0
+	for _, b := range p[:count] {
+		if b != '\r' && b != '\n' {
+			p[kept] = b
+			kept++
+		}
+	}
+	return kept, err
+}
+
+// charsetReader decodes the charsets of encoded words which are not UTF-8
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(toUTF8(data, charset)), nil
+}
+
+// toUTF8 converts text in charset to UTF-8. Latin-1 is converted, other charsets are
+// expected to be UTF-8 compatible and invalid bytes are replaced.
+func toUTF8(data []byte, charset string) []byte {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return []byte(string(runes))
+	}
+	return bytes.ToValidUTF8(data, []byte("\uFFFD"))
+}
+
+var (
+	htmlSkipped  = regexp.MustCompile(`(?is)<(script|style|head|title)[^>]*>.*?</(script|style|head|title)\s*>`)
+	htmlComment  = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlLink     = regexp.MustCompile(`(?is)<a\s[^>]*href\s*=\s*["']?([^"'\s>]+)["']?[^>]*>(.*?)</a\s*>`)
+	htmlBreak    = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|tr|li|h[1-6]|table|blockquote|pre)\s*>`)
+	htmlListItem = regexp.MustCompile(`(?i)<li[^>]*>`)
+	htmlCell     = regexp.MustCompile(`(?i)</t[dh]\s*>`)
+	htmlTag      = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaces       = regexp.MustCompile(`[ \t\x{00a0}]+`)
+	blankLines   = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText converts an HTML body to plain text, links keep their target
+func HTMLToText(body string) string {
+	body = htmlComment.ReplaceAllString(body, "")
+	body = htmlSkipped.ReplaceAllString(body, "")
+	body = strings.NewReplacer("\r", "", "\n", " ").Replace(body)
+	body = htmlLink.ReplaceAllStringFunc(body, func(link string) string {
+		match := htmlLink.FindStringSubmatch(link)
+		target := html.UnescapeString(match[1])
+		text := strings.TrimSpace(htmlTag.ReplaceAllString(match[2], ""))
+		switch {
+		case text == "":
+			return target
+		case html.UnescapeString(text) == target || !strings.Contains(target, "://"):
+			// the link is shown already or is not a web link, e.g. mailto:
+			return text
+		}
+		return text + " (" + target + ")"
+	})
+	body = htmlBreak.ReplaceAllString(body, "\n")
+	body = htmlListItem.ReplaceAllString(body, "• ")
+	body = htmlCell.ReplaceAllString(body, " ")
+	body = htmlTag.ReplaceAllString(body, "")
+
+	// indentation of HTML source is not meaningful
+	lines := strings.Split(html.UnescapeString(body), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spaces.ReplaceAllString(line, " "))
+	}
+	return normalizeText(strings.Join(lines, "\n"))
+}
+
+// normalizeText removes trailing spaces of the lines of text and collapses runs of blank lines
+func normalizeText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	text = blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.Trim(text, "\n")
+}
@@ -0,0 +1,233 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxSize  = 10 << 20
+	maxRecipients   = 100
+	commandTimeout  = 5 * time.Minute
+	defaultHostname = "localhost"
+)
+
+// ListenAndServe listens on addr, e.g. "localhost:2525", and serves SMTP until ctx is done
+func (g *Gateway) ListenAndServe(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return g.Serve(ctx, listener)
+}
+
+// Serve accepts SMTP connections on listener until ctx is done.
+// Only the commands needed to submit mail are supported, without authentication and TLS.
+func (g *Gateway) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go g.serveConn(ctx, conn)
+	}
+}
+
+// session is the state of an SMTP transaction
+type session struct {
+	helo       bool
+	from       string
+	recipients []string
+}
+
+// reset starts a new transaction
+func (s *session) reset() {
+	s.from = ""
+	s.recipients = nil
+}
+
+// serveConn runs an SMTP session
+func (g *Gateway) serveConn(ctx context.Context, netConn net.Conn) {
+	defer netConn.Close()
+	// a panic while delivering must not take the other sessions down
+	defer func() {
+		if r := recover(); r != nil {
+			g.error(fmt.Errorf("internal error : %v", r))
+		}
+	}()
+	conn := textproto.NewConn(netConn)
+
+	hostname := g.Hostname
+	if hostname == "" {
+		hostname = defaultHostname
+	}
+	maxSize := g.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+
+	reply := func(format string, args ...interface{}) bool {
+		_ = netConn.SetWriteDeadline(time.Now().Add(commandTimeout))
+		return conn.PrintfLine(format, args...) == nil
+	}
+
+	reply("220 %s ESMTP hangups", hostname)
+	s := &session{}
+	for {
+		_ = netConn.SetReadDeadline(time.Now().Add(commandTimeout))
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			s.helo = true
+			s.reset()
+			reply("250 %s", hostname)
+		case "EHLO":
+			s.helo = true
+			s.reset()
+			reply("250-%s", hostname)
+			reply("250-SIZE %d", maxSize)
+			reply("250-8BITMIME")
+			reply("250 ENHANCEDSTATUSCODES")
+		case "MAIL":
+			address, params, ok := pathArgument(arg, "FROM:")
+			switch {
+			case !s.helo:
+				reply("503 5.5.1 Send HELO/EHLO first")
+			case !ok:
+				reply("501 5.5.4 Syntax: MAIL FROM:<address>")
+			case s.from != "":
+				reply("503 5.5.1 Sender already specified")
+			case sizeParameter(params) > maxSize:
+				reply("552 5.3.4 Message too big")
+			case !g.Allowed(address):
+				reply("550 5.7.1 Sender %s not allowed", address)
+			default:
+				s.from = address
+				reply("250 2.1.0 OK")
+			}
+		case "RCPT":
+			address, _, ok := pathArgument(arg, "TO:")
+			if s.from == "" {
+				reply("503 5.5.1 Need MAIL before RCPT")
+				break
+			}
+			if !ok {
+				reply("501 5.5.4 Syntax: RCPT TO:<address>")
+				break
+			}
+			if len(s.recipients) >= maxRecipients {
+				reply("452 4.5.3 Too many recipients")
+				break
+			}
+			if _, err := g.Recipient(address); err != nil {
+				reply("550 5.1.1 %v", err)
+				break
+			}
+			s.recipients = append(s.recipients, address)
+			reply("250 2.1.5 OK")
+		case "DATA":
+			if len(s.recipients) == 0 {
+				reply("503 5.5.1 Need RCPT before DATA")
+				break
+			}
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			_ = netConn.SetReadDeadline(time.Now().Add(commandTimeout))
+			dot := conn.DotReader()
+			data, err := ioutil.ReadAll(io.LimitReader(dot, maxSize+1))
+			if err != nil {
+				return
+			}
+			if int64(len(data)) > maxSize {
+				// the rest of the message has to be read before the reply, through the same reader
+				// as a new one would wait for another terminating dot
+				if _, err = io.Copy(ioutil.Discard, dot); err != nil {
+					return
+				}
+				reply("552 5.3.4 Message too big")
+			} else if err = g.DeliverData(ctx, s.recipients, data); err != nil {
+				g.error(fmt.Errorf("error relaying mail from %s : %v", s.from, err))
+				reply("554 5.0.0 %s", strings.ReplaceAll(err.Error(), "\n", " "))
+			} else {
+				reply("250 2.0.0 OK relayed to hangouts")
+			}
+			s.reset()
+		case "RSET":
+			s.reset()
+			reply("250 2.0.0 OK")
+		case "NOOP":
+			reply("250 2.0.0 OK")
+		case "VRFY":
+			reply("252 2.5.2 Cannot verify, will try")
+		case "HELP":
+			reply("214 2.0.0 HELO EHLO MAIL RCPT DATA RSET NOOP QUIT")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("502 5.5.2 Command not implemented")
+		}
+	}
+}
+
+// pathArgument parses the "FROM:<address> params" argument of MAIL and RCPT
+func pathArgument(arg, keyword string) (string, []string, bool) {
+	if len(arg) < len(keyword) || !strings.EqualFold(arg[:len(keyword)], keyword) {
+		return "", nil, false
+	}
+	arg = strings.TrimSpace(arg[len(keyword):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", nil, false
+	}
+	address := arg[1:end]
+	// source routes like <@relay:user@host> are obsolete, the mailbox is the part after the colon
+	if strings.HasPrefix(address, "@") {
+		if i := strings.IndexByte(address, ':'); i >= 0 {
+			address = address[i+1:]
+		}
+	}
+	return address, strings.Fields(arg[end+1:]), true
+}
+
+// sizeParameter returns the SIZE= parameter of MAIL, 0 if not given
+func sizeParameter(params []string) int64 {
+	for _, param := range params {
+		if strings.HasPrefix(strings.ToUpper(param), "SIZE=") {
+			var size int64
+			if _, err := fmt.Sscan(param[5:], &size); err == nil {
+				return size
+			}
+		}
+	}
+	return 0
+}
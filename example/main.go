@@ -72,6 +72,11 @@ func main() {
 						fmt.Println("[", conversationName, "] ", senderName, ":", *segment.Text, " (", *segment.Type, ")")
 					}
 				}
+
+				// print photos, locations and other attachments
+				for _, attachment := range hangups.EventAttachments(event) {
+					fmt.Println("[", conversationName, "] ", senderName, ":", attachment.Type, attachment.Name, attachment.ContentURL, attachment.URL)
+				}
 			}
 
 			// mark all events in this conversation as read
@@ -0,0 +1,241 @@
+package export
+
+import (
+	"time"
+
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// ArchiveVersion is the version of the JSON schema, it changes only on incompatible changes
+const ArchiveVersion = 1
+
+// event kinds of the archive schema
+const (
+	KindMessage    = "message"
+	KindMembership = "membership"
+	KindRename     = "rename"
+	KindOTR        = "otr"
+	KindHangout    = "hangout"
+	KindOther      = "other"
+)
+
+// Archive is the stable, self-contained representation of one conversation
+type Archive struct {
+	Version        int                   `json:"version"`
+	ConversationID string                `json:"conversation_id"`
+	Name           string                `json:"name,omitempty"`
+	Type           string                `json:"type"` // ONE_TO_ONE or GROUP
+	ExportedAt     time.Time             `json:"exported_at"`
+	Participants   []*ArchiveParticipant `json:"participants"`
+	Events         []*ArchiveEvent       `json:"events"`
+}
+
+// ArchiveParticipant is a user taking part in the conversation or mentioned by one of its events
+type ArchiveParticipant struct {
+	GaiaID string `json:"gaia_id"`
+	Name   string `json:"name,omitempty"`
+}
+
+// ArchiveEvent is a single event of the conversation, only the fields of its Kind are set
+type ArchiveEvent struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Type      string    `json:"type"` // the raw event type, e.g. REGULAR_CHAT_MESSAGE or SMS
+	Timestamp time.Time `json:"timestamp"`
+	SenderID  string    `json:"sender_id,omitempty"`
+
+	// message
+	Action      bool                 `json:"action,omitempty"`
+	Text        string               `json:"text,omitempty"`
+	Segments    []*ArchiveSegment    `json:"segments,omitempty"`
+	Attachments []*ArchiveAttachment `json:"attachments,omitempty"`
+
+	// membership and hangout
+	Change         string   `json:"change,omitempty"` // JOIN or LEAVE, or the hangout event type
+	ParticipantIDs []string `json:"participant_ids,omitempty"`
+
+	// rename
+	OldName string `json:"old_name,omitempty"`
+	NewName string `json:"new_name,omitempty"`
+
+	// otr
+	OffTheRecord bool `json:"off_the_record,omitempty"`
+}
+
+// ArchiveSegment is a formatted piece of message text
+type ArchiveSegment struct {
+	Type          string `json:"type"` // TEXT, LINE_BREAK or LINK
+	Text          string `json:"text"`
+	Link          string `json:"link,omitempty"`
+	Bold          bool   `json:"bold,omitempty"`
+	Italic        bool   `json:"italic,omitempty"`
+	Strikethrough bool   `json:"strikethrough,omitempty"`
+	Underline     bool   `json:"underline,omitempty"`
+}
+
+// ArchiveAttachment is an attachment of a message
+type ArchiveAttachment struct {
+	Type      string  `json:"type"`
+	ID        string  `json:"id,omitempty"`
+	Name      string  `json:"name,omitempty"`
+	URL       string  `json:"url,omitempty"`
+	ImageURL  string  `json:"image_url,omitempty"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+	Address   string  `json:"address,omitempty"`
+
+	// DataURI holds the image itself when it was inlined, it is never written to JSON
+	DataURI string `json:"-"`
+
+	attachment *hangups.Attachment
+}
+
+// NewArchive converts a conversation into its archive form
+func NewArchive(conversation *Conversation) *Archive {
+	archive := &Archive{
+		Version:        ArchiveVersion,
+		ConversationID: conversation.ID(),
+		Name:           conversation.Conversation.GetName(),
+		Type:           trimEnumPrefix(conversation.Conversation.GetType().String(), "CONVERSATION_TYPE_"),
+		ExportedAt:     time.Now().UTC(),
+		Participants:   make([]*ArchiveParticipant, 0),
+		Events:         make([]*ArchiveEvent, 0, len(conversation.Events)),
+	}
+	for _, id := range conversation.participantIDs() {
+		archive.Participants = append(archive.Participants, &ArchiveParticipant{GaiaID: id, Name: conversation.Names[id]})
+	}
+	for _, event := range conversation.Events {
+		archive.Events = append(archive.Events, NewArchiveEvent(event))
+	}
+	return archive
+}
+
+// Participant returns the display name of a user, falling back to the gaia id
+func (a *Archive) Participant(gaiaID string) string {
+	for _, participant := range a.Participants {
+		if participant.GaiaID == gaiaID && participant.Name != "" {
+			return participant.Name
+		}
+	}
+	return gaiaID
+}
+
+// Title returns the name of the conversation or the names of its participants
+func (a *Archive) Title() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	title := ""
+	for i, participant := range a.Participants {
+		if i > 0 {
+			title += ", "
+		}
+		title += a.Participant(participant.GaiaID)
+	}
+	if title == "" {
+		return a.ConversationID
+	}
+	return title
+}
+
+// NewArchiveEvent converts a single event
+func NewArchiveEvent(event *hangouts.Event) *ArchiveEvent {
+	archived := &ArchiveEvent{
+		ID:        event.GetEventId(),
+		Kind:      KindOther,
+		Type:      trimEnumPrefix(event.GetEventType().String(), "EVENT_TYPE_"),
+		Timestamp: timestamp(event.GetTimestamp()),
+		SenderID:  event.GetSenderId().GetGaiaId(),
+	}
+
+	switch {
+	case event.GetChatMessage() != nil:
+		message := hangups.NewMessage(event)
+		archived.Kind = KindMessage
+		archived.Action = message.IsAction()
+		archived.Text = message.Text()
+		for _, segment := range message.Segments() {
+			archived.Segments = append(archived.Segments, newArchiveSegment(segment))
+		}
+		for _, attachment := range message.Attachments() {
+			archived.Attachments = append(archived.Attachments, newArchiveAttachment(attachment))
+		}
+	case event.GetMembershipChange() != nil:
+		archived.Kind = KindMembership
+		archived.Change = trimEnumPrefix(event.GetMembershipChange().GetType().String(), "MEMBERSHIP_CHANGE_TYPE_")
+		for _, participant := range event.GetMembershipChange().GetParticipantIds() {
+			archived.ParticipantIDs = append(archived.ParticipantIDs, participant.GetGaiaId())
+		}
+	case event.GetConversationRename() != nil:
+		archived.Kind = KindRename
+		archived.OldName = event.GetConversationRename().GetOldName()
+		archived.NewName = event.GetConversationRename().GetNewName()
+	case event.GetOtrModification() != nil:
+		archived.Kind = KindOTR
+		archived.OffTheRecord = event.GetOtrModification().GetNewOtrStatus() ==
+			hangouts.OffTheRecordStatus_OFF_THE_RECORD_STATUS_OFF_THE_RECORD
+	case event.GetHangoutEvent() != nil:
+		archived.Kind = KindHangout
+		archived.Change = trimEnumPrefix(event.GetHangoutEvent().GetEventType().String(), "HANGOUT_EVENT_TYPE_")
+		for _, participant := range event.GetHangoutEvent().GetParticipantId() {
+			archived.ParticipantIDs = append(archived.ParticipantIDs, participant.GetGaiaId())
+		}
+	}
+	return archived
+}
+
+// newArchiveSegment converts a segment
+func newArchiveSegment(segment *hangouts.Segment) *ArchiveSegment {
+	archived := &ArchiveSegment{
+		Type:          trimEnumPrefix(segment.GetType().String(), "SEGMENT_TYPE_"),
+		Text:          segment.GetText(),
+		Bold:          segment.GetFormatting().GetBold(),
+		Italic:        segment.GetFormatting().GetItalic(),
+		Strikethrough: segment.GetFormatting().GetStrikethrough(),
+		Underline:     segment.GetFormatting().GetUnderline(),
+	}
+	if segment.GetType() == hangouts.SegmentType_SEGMENT_TYPE_LINE_BREAK {
+		archived.Text = "\n"
+	}
+	if segment.GetType() == hangouts.SegmentType_SEGMENT_TYPE_LINK {
+		archived.Link = segment.GetLinkData().GetLinkTarget()
+	}
+	return archived
+}
+
+// newArchiveAttachment converts an attachment
+func newArchiveAttachment(attachment *hangups.Attachment) *ArchiveAttachment {
+	url := attachment.URL
+	if attachment.ContentURL != "" {
+		url = attachment.ContentURL
+	}
+	return &ArchiveAttachment{
+		Type:       attachment.Type.String(),
+		ID:         attachment.ID,
+		Name:       attachment.Name,
+		URL:        url,
+		ImageURL:   attachment.ImageURL,
+		Width:      attachment.Width,
+		Height:     attachment.Height,
+		Latitude:   attachment.Latitude,
+		Longitude:  attachment.Longitude,
+		Address:    attachment.Address,
+		attachment: attachment,
+	}
+}
+
+// timestamp converts a hangouts timestamp in microseconds
+func timestamp(usec uint64) time.Time {
+	return time.Unix(0, int64(usec)*int64(time.Microsecond)).UTC()
+}
+
+// trimEnumPrefix shortens an enum name, e.g. EVENT_TYPE_SMS to SMS
+func trimEnumPrefix(name, prefix string) string {
+	if len(name) > len(prefix) && name[:len(prefix)] == prefix {
+		return name[len(prefix):]
+	}
+	return name
+}
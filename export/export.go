@@ -0,0 +1,309 @@
+// Package export archives hangouts conversations as JSON, HTML or plain text.
+package export
+
+import (
+	"context"
+	"sort"
+
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+const (
+	// conversationsPerPage is the number of conversations requested per sync
+	conversationsPerPage = 100
+	// eventsPerPage is the number of events requested per history request
+	eventsPerPage = 100
+	// entitiesPerLookup is the number of users looked up per GetEntityByID request
+	entitiesPerLookup = 50
+)
+
+// Source is the part of hangups.Client needed to fetch history
+type Source interface {
+	SyncRecentConversationsBefore(lastEventTimestamp, maxConversations, maxEventsPerConversation uint64) (*hangouts.SyncRecentConversationsResponse, error)
+	GetConversationHistory(conversationID string, maxEventsPerConversation, eventTimestamp uint64) (*hangouts.GetConversationResponse, error)
+	GetEntityByID(ids []string) (*hangouts.GetEntityByIdResponse, error)
+}
+
+// Conversation is a conversation with its complete history
+type Conversation struct {
+	Conversation *hangouts.Conversation
+	Events       []*hangouts.Event // oldest first
+	Names        map[string]string // gaia id -> display name
+}
+
+// ID returns the id of the conversation
+func (c *Conversation) ID() string {
+	return c.Conversation.GetConversationId().GetId()
+}
+
+// NewConversation creates a Conversation from a conversation state, events are sorted and
+// participant names are taken from the participant data
+func NewConversation(state *hangouts.ConversationState) *Conversation {
+	conversation := &Conversation{
+		Conversation: state.GetConversation(),
+		Names:        make(map[string]string),
+	}
+	if conversation.Conversation == nil {
+		conversation.Conversation = &hangouts.Conversation{ConversationId: state.GetConversationId()}
+	}
+	conversation.AddEvents(state.GetEvent())
+	conversation.addParticipantNames()
+	return conversation
+}
+
+// Update merges a newer state of the conversation
+func (c *Conversation) Update(state *hangouts.ConversationState) {
+	if state.GetConversation() != nil {
+		c.Conversation = state.GetConversation()
+		c.addParticipantNames()
+	}
+	c.AddEvents(state.GetEvent())
+}
+
+// addParticipantNames takes the names of users from the participant data
+func (c *Conversation) addParticipantNames() {
+	for _, participant := range c.Conversation.GetParticipantData() {
+		if name := participant.GetFallbackName(); name != "" {
+			c.Names[participant.GetId().GetGaiaId()] = name
+		}
+	}
+}
+
+// AddEvents merges events into the history, skipping the ones already present
+func (c *Conversation) AddEvents(events []*hangouts.Event) {
+	seen := make(map[string]bool, len(c.Events))
+	for _, event := range c.Events {
+		seen[event.GetEventId()] = true
+	}
+	for _, event := range events {
+		if event.GetEventId() != "" && seen[event.GetEventId()] {
+			continue
+		}
+		seen[event.GetEventId()] = true
+		c.Events = append(c.Events, event)
+	}
+	sort.SliceStable(c.Events, func(i, j int) bool {
+		return c.Events[i].GetTimestamp() < c.Events[j].GetTimestamp()
+	})
+}
+
+// Name returns the display name of a user, falling back to the gaia id
+func (c *Conversation) Name(gaiaID string) string {
+	if name, ok := c.Names[gaiaID]; ok && name != "" {
+		return name
+	}
+	return gaiaID
+}
+
+// participantIDs returns every user referenced by the conversation or its events
+func (c *Conversation) participantIDs() []string {
+	ids := make(map[string]bool)
+	for _, participant := range c.Conversation.GetParticipantData() {
+		ids[participant.GetId().GetGaiaId()] = true
+	}
+	for _, event := range c.Events {
+		ids[event.GetSenderId().GetGaiaId()] = true
+		for _, participant := range event.GetMembershipChange().GetParticipantIds() {
+			ids[participant.GetGaiaId()] = true
+		}
+		for _, participant := range event.GetHangoutEvent().GetParticipantId() {
+			ids[participant.GetGaiaId()] = true
+		}
+	}
+	delete(ids, "")
+
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// FetchOptions limits what Fetch downloads
+type FetchOptions struct {
+	ConversationIDs []string // only fetch these conversations, all if empty
+	MaxEvents       int      // per conversation, 0 for the complete history
+	// Progress is called after every page of events
+	Progress func(conversationID string, events int)
+}
+
+// Fetch walks all conversations and their complete history
+func Fetch(ctx context.Context, source Source, options *FetchOptions) ([]*Conversation, error) {
+	if options == nil {
+		options = &FetchOptions{}
+	}
+	wanted := make(map[string]bool)
+	for _, id := range options.ConversationIDs {
+		wanted[id] = true
+	}
+
+	states, err := listConversations(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	conversations := make([]*Conversation, 0, len(states))
+	for _, state := range states {
+		if len(wanted) > 0 && !wanted[state.GetConversationId().GetId()] {
+			continue
+		}
+		conversation := NewConversation(state)
+		if err = fetchHistory(ctx, source, conversation, options); err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, conversation)
+	}
+
+	if err = resolveNames(ctx, source, conversations); err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+// FetchConversation fetches the complete history of a single conversation
+func FetchConversation(ctx context.Context, source Source, conversationID string, options *FetchOptions) (*Conversation, error) {
+	if options == nil {
+		options = &FetchOptions{}
+	}
+	state := &hangouts.ConversationState{
+		ConversationId: &hangouts.ConversationId{Id: &conversationID},
+	}
+	conversation := NewConversation(state)
+	if err := fetchHistory(ctx, source, conversation, options); err != nil {
+		return nil, err
+	}
+	if err := resolveNames(ctx, source, []*Conversation{conversation}); err != nil {
+		return nil, err
+	}
+	return conversation, nil
+}
+
+// listConversations pages through SyncRecentConversations
+func listConversations(ctx context.Context, source Source) ([]*hangouts.ConversationState, error) {
+	states := make([]*hangouts.ConversationState, 0)
+	seen := make(map[string]bool)
+
+	var before uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		response, err := source.SyncRecentConversationsBefore(before, conversationsPerPage, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err = hangups.CheckResponseHeader(response.GetResponseHeader()); err != nil {
+			return nil, err
+		}
+
+		added := 0
+		for _, state := range response.GetConversationState() {
+			id := state.GetConversationId().GetId()
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			states = append(states, state)
+			added++
+		}
+
+		next := response.GetContinuationEndTimestamp()
+		if added == 0 || next == 0 || next == before {
+			return states, nil
+		}
+		before = next
+	}
+}
+
+// fetchHistory fetches the events of a conversation, keeping the newest options.MaxEvents
+func fetchHistory(ctx context.Context, source Source, conversation *Conversation, options *FetchOptions) error {
+	if err := fetchPages(ctx, source, conversation, options); err != nil {
+		return err
+	}
+	if options.MaxEvents > 0 && len(conversation.Events) > options.MaxEvents {
+		conversation.Events = conversation.Events[len(conversation.Events)-options.MaxEvents:]
+	}
+	return nil
+}
+
+// fetchPages pages backwards through the events of a conversation
+func fetchPages(ctx context.Context, source Source, conversation *Conversation, options *FetchOptions) error {
+	// start from now, the sync response only contains the metadata
+	var before uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if options.MaxEvents > 0 && len(conversation.Events) >= options.MaxEvents {
+			return nil
+		}
+
+		response, err := source.GetConversationHistory(conversation.ID(), eventsPerPage, before)
+		if err != nil {
+			return err
+		}
+		if err = hangups.CheckResponseHeader(response.GetResponseHeader()); err != nil {
+			return err
+		}
+
+		state := response.GetConversationState()
+		count := len(conversation.Events)
+		conversation.Update(state)
+		if options.Progress != nil {
+			options.Progress(conversation.ID(), len(conversation.Events))
+		}
+
+		token := state.GetEventContinuationToken()
+		if len(conversation.Events) == count || token.GetEventTimestamp() == 0 || token.GetEventTimestamp() == before {
+			return nil
+		}
+		before = token.GetEventTimestamp()
+	}
+}
+
+// resolveNames looks up the users without a fallback name
+func resolveNames(ctx context.Context, source Source, conversations []*Conversation) error {
+	missing := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, conversation := range conversations {
+		for _, id := range conversation.participantIDs() {
+			if _, ok := conversation.Names[id]; !ok && !seen[id] {
+				seen[id] = true
+				missing = append(missing, id)
+			}
+		}
+	}
+
+	names := make(map[string]string)
+	for start := 0; start < len(missing); start += entitiesPerLookup {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := start + entitiesPerLookup
+		if end > len(missing) {
+			end = len(missing)
+		}
+		response, err := source.GetEntityByID(missing[start:end])
+		if err != nil {
+			return err
+		}
+		for _, result := range response.GetEntityResult() {
+			for _, entity := range result.GetEntity() {
+				if name := entity.GetProperties().GetDisplayName(); name != "" {
+					names[entity.GetId().GetGaiaId()] = name
+				}
+			}
+		}
+	}
+
+	for _, conversation := range conversations {
+		for id, name := range names {
+			if _, ok := conversation.Names[id]; !ok {
+				conversation.Names[id] = name
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mysqto/hangups"
+)
+
+// timeLayout is used for timestamps in text and HTML archives
+const timeLayout = "2006-01-02 15:04:05 MST"
+
+// DownloadFunc fetches an attachment, it has the signature of Client.DownloadAttachment
+type DownloadFunc func(ctx context.Context, attachment *hangups.Attachment, w io.Writer) (*hangups.DownloadInfo, error)
+
+// InlineImages downloads the photos of an archive so WriteHTML embeds them instead of linking them.
+// Failed downloads are skipped, the attachment keeps its link.
+func InlineImages(ctx context.Context, archive *Archive, download DownloadFunc) {
+	for _, event := range archive.Events {
+		for _, attachment := range event.Attachments {
+			if attachment.attachment == nil || attachment.attachment.Type != hangups.AttachmentPhoto {
+				continue
+			}
+			var buffer bytes.Buffer
+			info, err := download(ctx, attachment.attachment, &buffer)
+			if err != nil {
+				continue
+			}
+			mimeType := info.MimeType
+			if !strings.HasPrefix(mimeType, "image/") {
+				mimeType = http.DetectContentType(buffer.Bytes())
+			}
+			attachment.DataURI = "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(buffer.Bytes())
+		}
+	}
+}
+
+// WriteJSON writes the archive as indented JSON
+func WriteJSON(w io.Writer, archive *Archive) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(archive)
+}
+
+// WriteText writes the archive as plain text, one event per line
+func WriteText(w io.Writer, archive *Archive) error {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "%s\n", archive.Title())
+	fmt.Fprintf(&buffer, "conversation %s, %d events\n\n", archive.ConversationID, len(archive.Events))
+
+	for _, event := range archive.Events {
+		buffer.WriteString(FormatEvent(archive, event))
+		buffer.WriteString("\n")
+	}
+
+	_, err := buffer.WriteTo(w)
+	return err
+}
+
+// FormatEvent formats an event as text, attachments are listed on indented lines below it
+func FormatEvent(archive *Archive, event *ArchiveEvent) string {
+	var buffer strings.Builder
+	when := event.Timestamp.Format(timeLayout)
+	if event.Kind != KindMessage {
+		fmt.Fprintf(&buffer, "%s *** %s", when, Describe(archive, event))
+		return buffer.String()
+	}
+
+	sender := archive.Participant(event.SenderID)
+	text := strings.Replace(event.Text, "\n", "\n\t", -1)
+	if event.Action {
+		fmt.Fprintf(&buffer, "%s * %s %s", when, sender, text)
+	} else {
+		fmt.Fprintf(&buffer, "%s <%s> %s", when, sender, text)
+	}
+	for _, attachment := range event.Attachments {
+		fmt.Fprintf(&buffer, "\n\t[%s] %s", attachment.Type, attachmentLabel(attachment))
+	}
+	return buffer.String()
+}
+
+// WriteHTML writes the archive as a single HTML page, call InlineImages first to embed photos
+func WriteHTML(w io.Writer, archive *Archive) error {
+	return htmlTemplate.Execute(w, archive)
+}
+
+// Describe returns a sentence describing an event which is not a message
+func Describe(archive *Archive, event *ArchiveEvent) string {
+	sender := archive.Participant(event.SenderID)
+	names := make([]string, 0, len(event.ParticipantIDs))
+	for _, id := range event.ParticipantIDs {
+		names = append(names, archive.Participant(id))
+	}
+	participants := strings.Join(names, ", ")
+
+	switch event.Kind {
+	case KindMembership:
+		if event.Change == "LEAVE" {
+			if len(names) == 1 && event.ParticipantIDs[0] == event.SenderID {
+				return sender + " left the conversation"
+			}
+			return sender + " removed " + participants
+		}
+		return sender + " added " + participants
+	case KindRename:
+		if event.NewName == "" {
+			return sender + " removed the conversation name"
+		}
+		return fmt.Sprintf("%s renamed the conversation to %q", sender, event.NewName)
+	case KindOTR:
+		if event.OffTheRecord {
+			return sender + " turned history off"
+		}
+		return sender + " turned history on"
+	case KindHangout:
+		switch event.Change {
+		case "START":
+			return sender + " started a call"
+		case "END":
+			return "call ended"
+		case "JOIN":
+			return sender + " joined the call"
+		case "LEAVE":
+			return sender + " left the call"
+		default:
+			return "call " + strings.ToLower(event.Change)
+		}
+	default:
+		return fmt.Sprintf("%s event from %s", strings.ToLower(event.Type), sender)
+	}
+}
+
+// attachmentLabel returns the most useful text of an attachment
+func attachmentLabel(attachment *ArchiveAttachment) string {
+	switch {
+	case attachment.Address != "":
+		return fmt.Sprintf("%s (%f, %f)", attachment.Address, attachment.Latitude, attachment.Longitude)
+	case attachment.URL != "":
+		return attachment.URL
+	case attachment.ImageURL != "":
+		return attachment.ImageURL
+	default:
+		return attachment.Name
+	}
+}
+
+var htmlTemplate = template.Must(template.New("archive").Funcs(template.FuncMap{
+	"describe":    Describe,
+	"attachment":  attachmentLabel,
+	"formatTime":  func(event *ArchiveEvent) string { return event.Timestamp.Format(timeLayout) },
+	"dataURI":     func(uri string) template.URL { return template.URL(uri) },
+	"isMessage":   func(event *ArchiveEvent) bool { return event.Kind == KindMessage },
+	"isLineBreak": func(segment *ArchiveSegment) bool { return segment.Type == "LINE_BREAK" },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; color: #222; }
+.event { margin: 0.3em 0; }
+.time { color: #888; font-size: 0.8em; margin-right: 0.5em; }
+.sender { font-weight: bold; margin-right: 0.5em; }
+.notice { color: #666; font-style: italic; }
+.action { font-style: italic; }
+.attachment { margin: 0.3em 0 0.3em 2em; }
+.attachment img { max-width: 100%; }
+.b { font-weight: bold; } .i { font-style: italic; } .s { text-decoration: line-through; } .u { text-decoration: underline; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="notice">conversation {{.ConversationID}}, exported {{.ExportedAt.Format "2006-01-02 15:04:05 MST"}}</p>
+<p>Participants: {{range $i, $p := .Participants}}{{if $i}}, {{end}}{{$.Participant $p.GaiaID}}{{end}}</p>
+{{range .Events}}<div class="event" id="{{.ID}}">
+<span class="time">{{formatTime .}}</span>
+{{- if isMessage .}}
+<span class="sender">{{if .Action}}* {{end}}{{$.Participant .SenderID}}</span>
+<span{{if .Action}} class="action"{{end}}>
+{{- range .Segments}}{{if isLineBreak .}}<br>{{else if .Link}}<a href="{{.Link}}">{{.Text}}</a>{{else}}<span class="{{if .Bold}}b {{end}}{{if .Italic}}i {{end}}{{if .Strikethrough}}s {{end}}{{if .Underline}}u{{end}}">{{.Text}}</span>{{end}}{{end -}}
+</span>
+{{- range .Attachments}}
+<div class="attachment">{{if .DataURI}}<img src="{{dataURI .DataURI}}" alt="{{.Name}}">{{else if and (eq .Type "photo") .ImageURL}}<a href="{{.URL}}"><img src="{{.ImageURL}}" alt="{{.Name}}"></a>{{else}}[{{.Type}}] <a href="{{.URL}}">{{attachment .}}</a>{{end}}</div>
+{{- end}}
+{{- else}}
+<span class="notice">{{describe $ .}}</span>
+{{- end}}
+</div>
+{{end}}</body>
+</html>
+`))
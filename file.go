@@ -1,17 +1,26 @@
 package hangups
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"github.com/asaskevich/govalidator"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
 )
 
+const (
+	// imageDownloadTimeout bounds the download of an image given by url
+	imageDownloadTimeout = 30 * time.Second
+	// maxImageRedirects is the number of redirects followed when downloading an image
+	maxImageRedirects = 10
+)
+
 func randomName() string {
 	return time.Now().Format("2006-01-02")
 }
@@ -73,38 +82,98 @@ func getImageType(buffer []byte) (bool, string) {
 }
 
 // readImage try to read image data from a base64 encoded string or file or download from a url
-func readImage(v string) (*UploadFile, error) {
+func (c *Client) readImage(v string) (*UploadFile, error) {
+	// an existing file wins, since a file path can also be valid base64
+	if info, err := os.Stat(v); err == nil && info.Mode().IsRegular() {
+		return readImageFile(v)
+	}
+
 	if file, err := readBase64Image(v); err == nil {
 		return file, nil
 	}
 
-	if file, err := downloadImage(v); err == nil {
-		return file, nil
+	if strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") {
+		return c.downloadImage(context.Background(), v)
 	}
 
 	return readImageFile(v)
 }
 
-// downloadImage download image from a http/https url
-func downloadImage(url string) (*UploadFile, error) {
-	if !govalidator.IsURL(url) {
-		return nil, fmt.Errorf("%s is not an valid url", url)
+// IsImageFile checks if an image given to SendImage or UploadImage is read from the file system,
+// which it is for existing paths and for anything that is neither a url nor base64.
+// Servers sending images on behalf of others use it to keep local files private.
+func IsImageFile(v string) bool {
+	if _, err := os.Stat(v); err == nil {
+		return true
+	}
+	if strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") {
+		return false
+	}
+	_, err := base64.StdEncoding.DecodeString(v)
+	return err != nil
+}
+
+// checkImageURL checks that an image url is http or https and, if Client.ImageHosts is set,
+// on one of those hosts or their subdomains
+func (c *Client) checkImageURL(imageURL string) error {
+	uri, err := url.Parse(imageURL)
+	if err != nil || (uri.Scheme != "http" && uri.Scheme != "https") || uri.Hostname() == "" {
+		return fmt.Errorf("%s is not an valid url", imageURL)
+	}
+	if len(c.ImageHosts) == 0 {
+		return nil
+	}
+	host := strings.ToLower(uri.Hostname())
+	for _, allowed := range c.ImageHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return nil
+		}
 	}
+	return fmt.Errorf("downloading images from %s is not allowed", uri.Hostname())
+}
 
-	resp, err := http.Get(url)
+// downloadImage download image from a http/https url, within Client.MaxDownloadSize and
+// imageDownloadTimeout. Redirects are checked against Client.ImageHosts as well and
+// no session credentials are sent.
+func (c *Client) downloadImage(ctx context.Context, imageURL string) (*UploadFile, error) {
+	if err := c.checkImageURL(imageURL); err != nil {
+		return nil, err
+	}
 
+	ctx, cancel := context.WithTimeout(ctx, imageDownloadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error requesting image from %s : %v", url, err)
+		return nil, err
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	client := *c.httpClient()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxImageRedirects {
+			return errors.New("too many redirects")
+		}
+		return c.checkImageURL(req.URL.String())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting image from %s : %v", imageURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error requesting image from %s : %s", imageURL, resp.Status)
+	}
 
+	body, err := readLimited(resp, c.maxDownloadSize())
 	if err != nil {
-		return nil, fmt.Errorf("error downloading image from %s : %v", url, err)
+		return nil, fmt.Errorf("error downloading image from %s : %w", imageURL, err)
 	}
 
 	return &UploadFile{
-		name: filepath.Base(url),
+		name: filepath.Base(resp.Request.URL.Path),
 		size: len(body),
 		data: body,
 	}, nil
@@ -0,0 +1,71 @@
+package hangups
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDownloadImage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photo.png":
+			w.Write([]byte("0123456789"))
+		case "/chunked.png":
+			// no content length, the size is only known while reading
+			w.(http.Flusher).Flush()
+			w.Write([]byte("0123456789abcdef"))
+		case "/redirect.png":
+			http.Redirect(w, r, "http://metadata.internal/latest", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	host = host[:strings.LastIndex(host, ":")]
+
+	tests := []struct {
+		name    string
+		url     string
+		hosts   []string
+		maxSize int64
+		want    string
+		wantErr string
+	}{
+		{name: "download", url: server.URL + "/photo.png", want: "0123456789"},
+		{name: "allowed host", url: server.URL + "/photo.png", hosts: []string{"example.com", host}, want: "0123456789"},
+		{name: "host not allowed", url: server.URL + "/photo.png", hosts: []string{"example.com"}, wantErr: "not allowed"},
+		{name: "redirect to a host not allowed", url: server.URL + "/redirect.png", hosts: []string{host}, wantErr: "not allowed"},
+		{name: "content length too large", url: server.URL + "/photo.png", maxSize: 8, wantErr: ErrDownloadTooLarge.Error()},
+		{name: "body too large", url: server.URL + "/chunked.png", maxSize: 8, wantErr: ErrDownloadTooLarge.Error()},
+		{name: "not found", url: server.URL + "/missing.png", wantErr: "404"},
+		{name: "not http", url: "file:///etc/passwd", wantErr: "not an valid url"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &Client{ImageHosts: test.hosts, MaxDownloadSize: test.maxSize}
+
+			file, err := client.downloadImage(context.Background(), test.url)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("downloadImage() error = %v, want %q", err, test.wantErr)
+				}
+				if test.wantErr == ErrDownloadTooLarge.Error() && !errors.Is(err, ErrDownloadTooLarge) {
+					t.Errorf("error %v does not wrap ErrDownloadTooLarge", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("downloadImage() error = %v", err)
+			}
+			if string(file.data) != test.want || file.size != len(test.want) || file.name != "photo.png" {
+				t.Errorf("downloaded %s with %q (%d bytes)", file.name, file.data, file.size)
+			}
+		})
+	}
+}
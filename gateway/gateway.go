@@ -0,0 +1,214 @@
+// Package gateway exposes the operations of a Client as a REST/JSON API, with a server-sent events
+// stream of incoming events. Gateway is an http.Handler, mount it with http.StripPrefix to embed it
+// under a path of an existing server.
+//
+//	GET  /self                          the current user, User
+//	GET  /conversations?max=50          recent conversations, Conversations
+//	GET  /conversations/{id}            a conversation, Conversation
+//	GET  /conversations/{id}/events     history before ?before= (RFC 3339), ?max=50 events, Events
+//	POST /conversations/{id}/messages   sends a SendMessage, SentMessage
+//	POST /conversations/{id}/read       marks the conversation read with a MarkRead
+//	PUT  /conversations/{id}/name       renames the conversation with a Rename
+//	GET  /entities?email=&phone=&id=&q= looks up or searches users, Users
+//	GET  /presence?id=                  the presence of users, Presences
+//	PUT  /presence                      sets the presence of the current user with a SetPresence
+//	GET  /events                        server-sent events, ?conversation_id= filters them
+//
+// Every event of the stream is an "event" whose data is a webhook.Payload and whose id is its delivery id.
+// Errors are answered as Error with a 4xx or 5xx status.
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// maxRequestSize limits the body of requests, images may be posted base64 encoded
+const maxRequestSize = 32 << 20
+
+// Gateway is the http.Handler of the API
+type Gateway struct {
+	Client *hangups.Client
+	// APIKeys are accepted as "Authorization: Bearer <key>" or as X-API-Key header. The events stream
+	// also accepts an api_key query parameter for EventSource, which cannot set headers.
+	// Every request is allowed if empty, for servers authenticating on their own.
+	APIKeys []string
+	// AllowFiles lets images be given as a path on the server, only urls and base64 images are accepted otherwise.
+	// Images given by url are downloaded within Client.MaxDownloadSize and from Client.ImageHosts if set.
+	AllowFiles bool
+
+	once   sync.Once
+	stream *stream
+}
+
+// New creates a Gateway for client accepting apiKeys
+func New(client *hangups.Client, apiKeys ...string) *Gateway {
+	return &Gateway{Client: client, APIKeys: apiKeys}
+}
+
+// statusError is an error answered with a specific status
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+// badRequest returns an error answered with 400
+func badRequest(format string, args ...interface{}) error {
+	return &statusError{status: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+// errNotFound is answered for unknown routes
+var errNotFound = &statusError{status: http.StatusNotFound, err: errors.New("not found")}
+
+// handlerFunc answers a request with a JSON result
+type handlerFunc func(w http.ResponseWriter, r *http.Request) (interface{}, error)
+
+// ServeHTTP implements http.Handler
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	stream := path == "events" && r.Method == http.MethodGet
+	if !g.authorized(r, stream) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="hangups"`)
+		writeJSON(w, http.StatusUnauthorized, &Error{Error: "invalid api key"})
+		return
+	}
+
+	if stream {
+		g.streamEvents(w, r)
+		return
+	}
+
+	handlers, id := g.routes(path)
+	handler, ok := handlers[r.Method]
+	var result interface{}
+	var err error
+	switch {
+	case handlers == nil:
+		err = errNotFound
+	case !ok:
+		methods := make([]string, 0, len(handlers))
+		for method := range handlers {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		err = &statusError{status: http.StatusMethodNotAllowed, err: fmt.Errorf("only %s allowed", strings.Join(methods, ", "))}
+	default:
+		r = r.WithContext(withConversationID(r.Context(), id))
+		result, err = handler(w, r)
+	}
+
+	if err != nil {
+		status := http.StatusBadGateway
+		var statusErr *statusError
+		var responseErr *hangups.ResponseError
+		if errors.As(err, &statusErr) {
+			status = statusErr.status
+		} else if errors.As(err, &responseErr) && responseErr.Status == hangouts.ResponseStatus_RESPONSE_STATUS_INVALID_REQUEST {
+			status = http.StatusBadRequest
+		}
+		writeJSON(w, status, &Error{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// routes returns the handlers of a path by method and the conversation id of the path
+func (g *Gateway) routes(path string) (map[string]handlerFunc, string) {
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "self":
+		return map[string]handlerFunc{http.MethodGet: g.getSelf}, ""
+	case path == "conversations":
+		return map[string]handlerFunc{http.MethodGet: g.listConversations}, ""
+	case path == "entities":
+		return map[string]handlerFunc{http.MethodGet: g.getEntities}, ""
+	case path == "presence":
+		return map[string]handlerFunc{http.MethodGet: g.getPresence, http.MethodPut: g.setPresence}, ""
+	case path == "events":
+		// streamed by ServeHTTP, listed for the Allow header of other methods
+		return map[string]handlerFunc{http.MethodGet: nil}, ""
+	case len(parts) == 2 && parts[0] == "conversations" && parts[1] != "":
+		return map[string]handlerFunc{http.MethodGet: g.getConversation}, parts[1]
+	case len(parts) == 3 && parts[0] == "conversations" && parts[1] != "":
+		switch parts[2] {
+		case "events":
+			return map[string]handlerFunc{http.MethodGet: g.getEvents}, parts[1]
+		case "messages":
+			return map[string]handlerFunc{http.MethodPost: g.sendMessage}, parts[1]
+		case "read":
+			return map[string]handlerFunc{http.MethodPost: g.markRead}, parts[1]
+		case "name":
+			return map[string]handlerFunc{http.MethodPut: g.rename}, parts[1]
+		}
+	}
+	return nil, ""
+}
+
+// conversationIDKey is the context key of the conversation id of a route
+type conversationIDKey struct{}
+
+// withConversationID stores the conversation id of a route in ctx
+func withConversationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, conversationIDKey{}, id)
+}
+
+// conversationID returns the conversation id of the route of a request
+func conversationID(r *http.Request) string {
+	id, _ := r.Context().Value(conversationIDKey{}).(string)
+	return id
+}
+
+// authorized checks the api key of a request, the query parameter is only accepted if inQuery is set
+// as query strings end up in access logs
+func (g *Gateway) authorized(r *http.Request, inQuery bool) bool {
+	if len(g.APIKeys) == 0 {
+		return true
+	}
+	key := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key == "" && inQuery {
+		key = r.URL.Query().Get("api_key")
+	}
+	if key == "" {
+		return false
+	}
+	for _, apiKey := range g.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// readJSON decodes the body of a request
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err := decoder.Decode(v); err != nil {
+		return badRequest("invalid request : %v", err)
+	}
+	return nil
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
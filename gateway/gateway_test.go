@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorized(t *testing.T) {
+	g := New(nil, "secret")
+	tests := []struct {
+		name    string
+		target  string
+		header  map[string]string
+		inQuery bool
+		want    bool
+	}{
+		{"no key", "/self", nil, false, false},
+		{"bearer", "/self", map[string]string{"Authorization": "Bearer secret"}, false, true},
+		{"header", "/self", map[string]string{"X-API-Key": "secret"}, false, true},
+		{"wrong key", "/self", map[string]string{"X-API-Key": "other"}, false, false},
+		{"query", "/self?api_key=secret", nil, false, false},
+		{"query on stream", "/events?api_key=secret", nil, true, true},
+		{"wrong query on stream", "/events?api_key=other", nil, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for name, value := range tt.header {
+				r.Header.Set(name, value)
+			}
+			if got := g.authorized(r, tt.inQuery); got != tt.want {
+				t.Errorf("authorized() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryKeyOnlyForStream(t *testing.T) {
+	g := New(nil, "secret")
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/entities?id=&api_key=secret", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// the header is accepted, the request then fails for its blank id
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/entities?id=", nil)
+	r.Header.Set("X-API-Key", "secret")
+	g.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,276 @@
+package gateway
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mysqto/hangups"
+	"github.com/mysqto/hangups/export"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+const (
+	defaultMaxConversations = 50
+	defaultMaxEvents        = 50
+	maxResults              = 500
+	defaultPresenceTimeout  = 720
+)
+
+// presence states of SetPresence
+var presenceStates = map[string]hangouts.ClientPresenceStateType{
+	"active": hangouts.ClientPresenceStateType_CLIENT_PRESENCE_STATE_DESKTOP_ACTIVE,
+	"idle":   hangouts.ClientPresenceStateType_CLIENT_PRESENCE_STATE_DESKTOP_IDLE,
+	"none":   hangouts.ClientPresenceStateType_CLIENT_PRESENCE_STATE_NONE,
+}
+
+// checkResult returns the error of a failed request or of its response
+func checkResult(header *hangouts.ResponseHeader, err error) error {
+	if err != nil {
+		return err
+	}
+	return hangups.CheckResponseHeader(header)
+}
+
+// queryIDs splits comma separated query values, blank ids are dropped
+func queryIDs(values []string) []string {
+	ids := make([]string, 0)
+	for _, value := range values {
+		for _, id := range strings.Split(value, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// queryCount parses a count parameter, between 1 and maxResults
+func queryCount(r *http.Request, name string, defaultCount uint64) (uint64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultCount, nil
+	}
+	count, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || count == 0 || count > maxResults {
+		return 0, badRequest("%s must be a number between 1 and %d", name, maxResults)
+	}
+	return count, nil
+}
+
+func (g *Gateway) getSelf(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	info, err := g.Client.GetSelfInfo()
+	if err = checkResult(info.GetResponseHeader(), err); err != nil {
+		return nil, err
+	}
+	return newUser(info.GetSelfEntity()), nil
+}
+
+func (g *Gateway) listConversations(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	count, err := queryCount(r, "max", defaultMaxConversations)
+	if err != nil {
+		return nil, err
+	}
+	response, err := g.Client.SyncRecentConversations(count, 1)
+	if err = checkResult(response.GetResponseHeader(), err); err != nil {
+		return nil, err
+	}
+
+	conversations := &Conversations{Conversations: make([]*Conversation, 0)}
+	for _, state := range response.GetConversationState() {
+		conversations.Conversations = append(conversations.Conversations, newConversation(state.GetConversation()))
+	}
+	sort.SliceStable(conversations.Conversations, func(i, j int) bool {
+		return conversations.Conversations[i].LastActivity.After(conversations.Conversations[j].LastActivity)
+	})
+	return conversations, nil
+}
+
+func (g *Gateway) getConversation(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	response, err := g.Client.GetConversation(conversationID(r), false, 0)
+	if err = checkResult(response.GetResponseHeader(), err); err != nil {
+		return nil, err
+	}
+	return newConversation(response.GetConversationState().GetConversation()), nil
+}
+
+func (g *Gateway) getEvents(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	count, err := queryCount(r, "max", defaultMaxEvents)
+	if err != nil {
+		return nil, err
+	}
+	before := time.Now()
+	if value := r.URL.Query().Get("before"); value != "" {
+		if before, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return nil, badRequest("before must be an RFC 3339 time")
+		}
+	}
+
+	id := conversationID(r)
+	response, err := g.Client.GetConversationHistory(id, count, toMicros(before))
+	if err = checkResult(response.GetResponseHeader(), err); err != nil {
+		return nil, err
+	}
+
+	conversation := export.NewConversation(response.GetConversationState())
+	events := &Events{ConversationID: id, Events: make([]*export.ArchiveEvent, 0, len(conversation.Events))}
+	for _, event := range conversation.Events {
+		events.Events = append(events.Events, export.NewArchiveEvent(event))
+	}
+	return events, nil
+}
+
+func (g *Gateway) sendMessage(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var request SendMessage
+	if err := readJSON(w, r, &request); err != nil {
+		return nil, err
+	}
+	if request.Text == "" && request.Image == "" {
+		return nil, badRequest("text or image is required")
+	}
+	if request.Image != "" && !g.AllowFiles && hangups.IsImageFile(request.Image) {
+		return nil, badRequest("image files are not allowed")
+	}
+
+	var options []hangups.MessageOption
+	if request.Action {
+		options = append(options, hangups.WithAction())
+	}
+	var photo *hangups.Photo
+	if request.Image != "" {
+		var err error
+		if photo, err = g.Client.UploadImage(request.Image); err != nil {
+			return nil, err
+		}
+		options = append(options, hangups.WithPhoto(photo.ImageID))
+	}
+
+	message, err := g.Client.Send(conversationID(r), request.Text, options...)
+	if err != nil {
+		return nil, err
+	}
+	sent := &SentMessage{
+		ConversationID:    message.ConversationID,
+		ClientGeneratedID: message.ClientGeneratedID,
+		EventID:           message.Event.GetEventId(),
+		Parts:             1,
+	}
+	if len(message.Parts) > 0 {
+		sent.Parts = len(message.Parts)
+	}
+	if photo != nil {
+		sent.PhotoID = photo.ImageID
+	}
+	return sent, nil
+}
+
+func (g *Gateway) markRead(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var request MarkRead
+	if r.ContentLength != 0 {
+		if err := readJSON(w, r, &request); err != nil {
+			return nil, err
+		}
+	}
+	timestamp := time.Now()
+	if request.Timestamp != nil {
+		timestamp = *request.Timestamp
+	}
+
+	response, err := g.Client.UpdateWatermark(conversationID(r), toMicros(timestamp))
+	if err = checkResult(response.GetResponseHeader(), err); err != nil {
+		return nil, err
+	}
+	return &MarkRead{Timestamp: &timestamp}, nil
+}
+
+func (g *Gateway) rename(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var request Rename
+	if err := readJSON(w, r, &request); err != nil {
+		return nil, err
+	}
+	response, err := g.Client.RenameConversation(conversationID(r), request.Name)
+	if err = checkResult(response.GetResponseHeader(), err); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (g *Gateway) getEntities(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	users := &Users{Users: make([]*User, 0)}
+
+	if q := query.Get("q"); q != "" {
+		count, err := queryCount(r, "max", 20)
+		if err != nil {
+			return nil, err
+		}
+		response, err := g.Client.SearchEntities(q, count)
+		if err = checkResult(response.GetResponseHeader(), err); err != nil {
+			return nil, err
+		}
+		for _, entity := range response.GetEntity() {
+			users.Users = append(users.Users, newUser(entity))
+		}
+		return users, nil
+	}
+
+	// GetEntityByID tells gaia ids, emails and phone numbers apart by their form
+	ids := make([]string, 0)
+	for _, name := range []string{"id", "email", "phone"} {
+		ids = append(ids, queryIDs(query[name])...)
+	}
+	if len(ids) == 0 {
+		return nil, badRequest("one of id, email, phone or q is required")
+	}
+	response, err := g.Client.GetEntityByID(ids)
+	if err = checkResult(response.GetResponseHeader(), err); err != nil {
+		return nil, err
+	}
+	for _, result := range response.GetEntityResult() {
+		for _, entity := range result.GetEntity() {
+			users.Users = append(users.Users, newUser(entity))
+		}
+	}
+	return users, nil
+}
+
+func (g *Gateway) getPresence(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	ids := queryIDs(r.URL.Query()["id"])
+	if len(ids) == 0 {
+		return nil, badRequest("id is required")
+	}
+
+	presences := &Presences{Presences: make([]*Presence, 0)}
+	for _, id := range ids {
+		response, err := g.Client.QueryPresence(id)
+		if err = checkResult(response.GetResponseHeader(), err); err != nil {
+			return nil, err
+		}
+		for _, result := range response.GetPresenceResult() {
+			presences.Presences = append(presences.Presences, newPresence(result))
+		}
+	}
+	return presences, nil
+}
+
+func (g *Gateway) setPresence(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var request SetPresence
+	if err := readJSON(w, r, &request); err != nil {
+		return nil, err
+	}
+	state, ok := presenceStates[request.State]
+	if !ok {
+		return nil, badRequest("state must be active, idle or none")
+	}
+	if request.TimeoutSecs == 0 {
+		request.TimeoutSecs = defaultPresenceTimeout
+	}
+
+	response, err := g.Client.SetPresence(int32(state), request.TimeoutSecs)
+	if err = checkResult(response.GetResponseHeader(), err); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
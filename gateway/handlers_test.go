@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestQueryIDs(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string
+	}{
+		{"none", nil, []string{}},
+		{"empty", []string{""}, []string{}},
+		{"blank", []string{" , ,"}, []string{}},
+		{"one", []string{"a"}, []string{"a"}},
+		{"empty between", []string{"a,,b"}, []string{"a", "b"}},
+		{"trimmed", []string{" a , b "}, []string{"a", "b"}},
+		{"repeated", []string{"a", "", "b,c"}, []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := queryIDs(tt.values); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queryIDs(%q) = %q, want %q", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlankIDs(t *testing.T) {
+	// without a client, a request reaching the chat api would panic
+	g := &Gateway{}
+	for _, target := range []string{
+		"/entities",
+		"/entities?id=",
+		"/entities?email=,,",
+		"/entities?id=%20&phone=,",
+		"/presence?id=",
+		"/presence?id=,%20,",
+	} {
+		t.Run(target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d : %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+		})
+	}
+}
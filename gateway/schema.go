@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mysqto/hangups/export"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// Error is the body of every failed request
+type Error struct {
+	Error string `json:"error"`
+}
+
+// User is a hangouts user
+type User struct {
+	GaiaID    string   `json:"gaia_id"`
+	Name      string   `json:"name,omitempty"`
+	FirstName string   `json:"first_name,omitempty"`
+	PhotoURL  string   `json:"photo_url,omitempty"`
+	Emails    []string `json:"emails,omitempty"`
+	Phones    []string `json:"phones,omitempty"`
+}
+
+// Users is the body of GET /entities
+type Users struct {
+	Users []*User `json:"users"`
+}
+
+// Presence is the presence of a user
+type Presence struct {
+	GaiaID    string     `json:"gaia_id"`
+	Reachable bool       `json:"reachable"`
+	Available bool       `json:"available"`
+	Mood      string     `json:"mood,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+}
+
+// Presences is the body of GET /presence
+type Presences struct {
+	Presences []*Presence `json:"presences"`
+}
+
+// SetPresence is the request of PUT /presence
+type SetPresence struct {
+	State       string `json:"state"`        // active, idle or none
+	TimeoutSecs uint64 `json:"timeout_secs"` // 720 if not set
+}
+
+// Participant is a member of a conversation
+type Participant struct {
+	GaiaID string `json:"gaia_id"`
+	Name   string `json:"name,omitempty"`
+}
+
+// Conversation is a conversation without its events
+type Conversation struct {
+	ID           string         `json:"id"`
+	Name         string         `json:"name,omitempty"`
+	Type         string         `json:"type"` // STICKY_ONE_TO_ONE or GROUP
+	Participants []*Participant `json:"participants"`
+	LastActivity time.Time      `json:"last_activity"`
+	LastRead     *time.Time     `json:"last_read,omitempty"`
+	OffTheRecord bool           `json:"off_the_record,omitempty"`
+}
+
+// Conversations is the body of GET /conversations
+type Conversations struct {
+	Conversations []*Conversation `json:"conversations"`
+}
+
+// Events is the body of GET /conversations/{id}/events, events are oldest first
+type Events struct {
+	ConversationID string                 `json:"conversation_id"`
+	Events         []*export.ArchiveEvent `json:"events"`
+}
+
+// SendMessage is the request of POST /conversations/{id}/messages, text and image may both be set
+type SendMessage struct {
+	Text   string `json:"text,omitempty"`
+	Action bool   `json:"action,omitempty"` // send text as a "/me" message
+	Image  string `json:"image,omitempty"`  // a url or base64 encoded image
+}
+
+// SentMessage is the body of POST /conversations/{id}/messages
+type SentMessage struct {
+	ConversationID    string `json:"conversation_id"`
+	ClientGeneratedID uint64 `json:"client_generated_id,omitempty"`
+	EventID           string `json:"event_id,omitempty"`
+	Parts             int    `json:"parts,omitempty"`
+	PhotoID           string `json:"photo_id,omitempty"`
+}
+
+// MarkRead is the request of POST /conversations/{id}/read
+type MarkRead struct {
+	Timestamp *time.Time `json:"timestamp,omitempty"` // now if not set
+}
+
+// Rename is the request of PUT /conversations/{id}/name
+type Rename struct {
+	Name string `json:"name"`
+}
+
+// newUser converts an entity
+func newUser(entity *hangouts.Entity) *User {
+	properties := entity.GetProperties()
+	return &User{
+		GaiaID:    entity.GetId().GetGaiaId(),
+		Name:      properties.GetDisplayName(),
+		FirstName: properties.GetFirstName(),
+		PhotoURL:  properties.GetPhotoUrl(),
+		Emails:    properties.GetEmail(),
+		Phones:    properties.GetPhone(),
+	}
+}
+
+// newPresence converts a presence result
+func newPresence(result *hangouts.PresenceResult) *Presence {
+	presence := result.GetPresence()
+	p := &Presence{
+		GaiaID:    result.GetUserId().GetGaiaId(),
+		Reachable: presence.GetReachable(),
+		Available: presence.GetAvailable(),
+	}
+	for _, segment := range presence.GetMoodMessage().GetMoodContent().GetSegment() {
+		p.Mood += segment.GetText()
+	}
+	if usec := presence.GetLastSeen().GetLastSeenTimestampUsec(); usec > 0 {
+		lastSeen := fromMicros(usec)
+		p.LastSeen = &lastSeen
+	}
+	return p
+}
+
+// newConversation converts a conversation
+func newConversation(conversation *hangouts.Conversation) *Conversation {
+	self := conversation.GetSelfConversationState()
+	c := &Conversation{
+		ID:           conversation.GetConversationId().GetId(),
+		Name:         conversation.GetName(),
+		Type:         strings.TrimPrefix(conversation.GetType().String(), "CONVERSATION_TYPE_"),
+		Participants: make([]*Participant, 0),
+		LastActivity: fromMicros(self.GetSortTimestamp()),
+		OffTheRecord: conversation.GetOtrStatus() == hangouts.OffTheRecordStatus_OFF_THE_RECORD_STATUS_OFF_THE_RECORD,
+	}
+	if usec := self.GetSelfReadState().GetLatestReadTimestamp(); usec > 0 {
+		lastRead := fromMicros(usec)
+		c.LastRead = &lastRead
+	}
+	for _, participant := range conversation.GetParticipantData() {
+		c.Participants = append(c.Participants, &Participant{
+			GaiaID: participant.GetId().GetGaiaId(),
+			Name:   participant.GetFallbackName(),
+		})
+	}
+	return c
+}
+
+// fromMicros converts a hangouts timestamp
+func fromMicros(usec uint64) time.Time {
+	return time.Unix(0, int64(usec)*int64(time.Microsecond)).UTC()
+}
+
+// toMicros converts a time to a hangouts timestamp
+func toMicros(t time.Time) uint64 {
+	return uint64(t.UnixNano() / int64(time.Microsecond))
+}
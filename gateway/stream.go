@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+	"github.com/mysqto/hangups/webhook"
+)
+
+const (
+	subscriberBuffer  = 64
+	heartbeatInterval = 30 * time.Second
+)
+
+// stream polls for events while at least one client is subscribed and fans them out
+type stream struct {
+	mu          sync.Mutex
+	subscribers map[chan *webhook.Payload]struct{}
+	cancel      context.CancelFunc
+}
+
+// subscribe adds a subscriber, starting the poller for the first one
+func (s *stream) subscribe(client *hangups.Client) chan *webhook.Payload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan *webhook.Payload]struct{})
+	}
+	subscriber := make(chan *webhook.Payload, subscriberBuffer)
+	s.subscribers[subscriber] = struct{}{}
+
+	if s.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.cancel = cancel
+		poller := hangups.NewPoller(client)
+		go func() {
+			err := poller.Run(ctx, func(conversation *hangouts.Conversation, event *hangouts.Event) {
+				s.publish(webhook.NewPayload(conversation, event, nil))
+			})
+			if err != nil && ctx.Err() == nil {
+				// the poller could not start, subscribers reconnect to try again
+				s.closeAll()
+			}
+		}()
+	}
+	return subscriber
+}
+
+// unsubscribe removes a subscriber, stopping the poller after the last one
+func (s *stream) unsubscribe(subscriber chan *webhook.Payload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.subscribers[subscriber]; ok {
+		delete(s.subscribers, subscriber)
+		close(subscriber)
+	}
+	if len(s.subscribers) == 0 && s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+}
+
+// publish passes a payload to every subscriber, slow subscribers are dropped
+func (s *stream) publish(payload *webhook.Payload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- payload:
+		default:
+			delete(s.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// closeAll drops every subscriber
+func (s *stream) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for subscriber := range s.subscribers {
+		delete(s.subscribers, subscriber)
+		close(subscriber)
+	}
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+}
+
+// streamEvents answers GET /events with server-sent events
+func (g *Gateway) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, &Error{Error: "streaming is not supported"})
+		return
+	}
+
+	filter := r.URL.Query().Get("conversation_id")
+	subscriber := g.events().subscribe(g.Client)
+	defer g.events().unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case payload, ok := <-subscriber:
+			if !ok {
+				return
+			}
+			if filter != "" && payload.Conversation.ID != filter {
+				continue
+			}
+			data, err := json.Marshal(payload)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: event\nid: %s\ndata: %s\n\n", payload.DeliveryID, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// events returns the event stream of the gateway
+func (g *Gateway) events() *stream {
+	g.once.Do(func() {
+		g.stream = &stream{}
+	})
+	return g.stream
+}
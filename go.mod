@@ -8,4 +8,5 @@ require (
 	github.com/tidwall/gjson v1.6.1
 	golang.org/x/net v0.0.0-20200923182212-328152dc79b1 // indirect
 	golang.org/x/oauth2 v0.0.0-20200902213428-5d25da1a8d43
+	google.golang.org/protobuf v1.25.0
 )
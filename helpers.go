@@ -2,12 +2,15 @@ package hangups
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -27,17 +30,17 @@ func GetAuthHeaders(sapisid string) map[string]string {
 	return map[string]string{"Authorization": sapisidHash, "X-Origin": originURL, "X-Goog-Authuser": "0"}
 }
 
-// APIRequest performs an API Request
-func (c *Client) APIRequest(endpointURL, responseType string, headers map[string]string, payload []byte) ([]byte, error) {
-
-	authHeaders := GetAuthHeaders(c.Session.Sapisid)
-
-	for headerKey, headerVal := range authHeaders {
-		headers[headerKey] = headerVal
-	}
+// newAPIRequest creates an authenticated POST request for the hangouts APIs
+func (c *Client) newAPIRequest(ctx context.Context, endpointURL, responseType string, headers map[string]string, body io.Reader) (*http.Request, error) {
 
 	headers["User-Agent"] = c.UserAgent
-	headers["Cookie"] = c.Session.Cookies
+	// requests are sent without authentication when replaying without a session
+	if c.Session != nil {
+		for headerKey, headerVal := range GetAuthHeaders(c.Session.Sapisid) {
+			headers[headerKey] = headerVal
+		}
+		headers["Cookie"] = c.Session.Cookies
+	}
 	// This header is required for Protocol Buffer responses, which causes
 	// them to be base64 encoded:
 	headers["X-Goog-Encode-Response-If-Executable"] = "base64"
@@ -51,18 +54,76 @@ func (c *Client) APIRequest(endpointURL, responseType string, headers map[string
 	urlParams.Set("key", apiKey)
 	uri.RawQuery = urlParams.Encode()
 
-	req, err := http.NewRequest(http.MethodPost, uri.String(), bytes.NewReader(payload))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri.String(), body)
+	if err != nil {
+		return nil, err
+	}
 	for headerKey, headerVal := range headers {
 		req.Header.Set(headerKey, headerVal)
 	}
+	return req, nil
+}
+
+// APIRequest performs an API Request
+func (c *Client) APIRequest(endpointURL, responseType string, headers map[string]string, payload []byte) ([]byte, error) {
+	// upload urls carry session tokens in the query
+	endpoint := endpointURL
+	if i := strings.IndexByte(endpoint, '?'); i >= 0 {
+		endpoint = endpoint[:i]
+	}
+	trace := c.startTrace(endpoint)
+	output, status, err := c.apiRequest(endpointURL, responseType, headers, payload)
+	trace.HTTPStatus = status
+	c.endTrace(trace, nil, err)
+	return output, err
+}
+
+// apiRequest performs an API Request and returns the body and the HTTP status of the response
+func (c *Client) apiRequest(endpointURL, responseType string, headers map[string]string, payload []byte) ([]byte, int, error) {
+
+	req, err := c.newAPIRequest(context.Background(), endpointURL, responseType, headers, bytes.NewReader(payload))
+	if err != nil {
+		return nil, 0, err
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.httpClient().Do(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
-	bodyBytes, _ := ioutil.ReadAll(resp.Body)
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logTo(c.Logger, LevelWarn, "cannot read response body", "url", req.URL.Path, "http_status", resp.StatusCode, "error", err)
+	}
+
+	return bodyBytes, resp.StatusCode, nil
+}
 
-	return bodyBytes, nil
+// HTTPError is returned for API responses with an HTTP error status which cannot be decoded,
+// error statuses with a protobuf body are returned as ResponseError
+type HTTPError struct {
+	StatusCode int
+	Body       string // the start of the body
+}
+
+// Error implements error
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("http status %d %s : %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// newHTTPError returns an HTTPError for error statuses and err for successful ones
+func newHTTPError(status int, body []byte, err error) error {
+	if status >= 200 && status < 300 {
+		return err
+	}
+	return &HTTPError{StatusCode: status, Body: truncateBody(body)}
+}
+
+// truncateBody shortens a response body for logging
+func truncateBody(body []byte) string {
+	const maxLength = 256
+	if len(body) > maxLength {
+		return string(body[:maxLength]) + "..."
+	}
+	return string(body)
 }
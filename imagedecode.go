@@ -0,0 +1,184 @@
+package hangups
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// the standard library has no bmp and ico decoders, these cover the variants cameras,
+// screenshot tools and favicons produce: uncompressed 1, 4, 8, 24 and 32 bits per pixel
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// decodeBMP decodes a .bmp file, images larger than maxPixels are scaled down while decoding
+// unless maxPixels is 0
+func decodeBMP(data []byte, maxPixels int) (image.Image, error) {
+	if len(data) < 14 || data[0] != 'B' || data[1] != 'M' {
+		return nil, errors.New("not a bmp file")
+	}
+	pixelOffset := int(binary.LittleEndian.Uint32(data[10:14]))
+	return decodeDIB(data[14:], pixelOffset-14, false, maxPixels)
+}
+
+// decodeDIB decodes a device independent bitmap, pixelOffset is relative to the start of the DIB header
+// or negative if the pixels directly follow the header and palette, as they do in ico files.
+// Bitmaps in ico files store twice their height because of the trailing AND mask.
+// Images larger than maxPixels are sampled down, so the full size image is never allocated.
+func decodeDIB(data []byte, pixelOffset int, inIcon bool, maxPixels int) (image.Image, error) {
+	if len(data) < 40 {
+		return nil, errors.New("bmp header is too short")
+	}
+	headerSize := int(binary.LittleEndian.Uint32(data[0:4]))
+	width := int(int32(binary.LittleEndian.Uint32(data[4:8])))
+	height := int(int32(binary.LittleEndian.Uint32(data[8:12])))
+	bpp := int(binary.LittleEndian.Uint16(data[14:16]))
+	compression := binary.LittleEndian.Uint32(data[16:20])
+	colorsUsed := int(binary.LittleEndian.Uint32(data[32:36]))
+
+	// BI_RGB and BI_BITFIELDS with the default masks are supported
+	if compression != 0 && compression != 3 {
+		return nil, fmt.Errorf("unsupported bmp compression %d", compression)
+	}
+
+	if inIcon {
+		height /= 2
+	}
+	topDown := height < 0
+	if topDown {
+		height = -height
+	}
+	// every pixel takes at least one bit and every row at least 4 bytes, larger dimensions cannot
+	// be backed by the data and would overflow the size computations below
+	if width <= 0 || height <= 0 || width > len(data)*8 || height > len(data) ||
+		headerSize < 40 || headerSize > len(data) {
+		return nil, errors.New("invalid bmp dimensions")
+	}
+
+	var palette color.Palette
+	if bpp <= 8 {
+		if colorsUsed == 0 {
+			colorsUsed = 1 << uint(bpp)
+		}
+		paletteStart := headerSize
+		if compression == 3 {
+			paletteStart += 12
+		}
+		if paletteStart+colorsUsed*4 > len(data) {
+			return nil, errors.New("bmp palette is truncated")
+		}
+		palette = make(color.Palette, colorsUsed)
+		for i := range palette {
+			entry := data[paletteStart+i*4:]
+			palette[i] = color.RGBA{R: entry[2], G: entry[1], B: entry[0], A: 0xff}
+		}
+		if pixelOffset < 0 {
+			pixelOffset = paletteStart + colorsUsed*4
+		}
+	} else if pixelOffset < 0 {
+		pixelOffset = headerSize
+		if compression == 3 {
+			pixelOffset += 12
+		}
+	}
+
+	switch bpp {
+	case 1, 4, 8, 24, 32:
+	default:
+		return nil, fmt.Errorf("unsupported bmp depth %d", bpp)
+	}
+
+	stride := ((bpp*width + 31) / 32) * 4
+	if pixelOffset < 0 || pixelOffset > len(data) || stride > (len(data)-pixelOffset)/height {
+		return nil, errors.New("bmp pixel data is truncated")
+	}
+
+	dstW, dstH := width, height
+	if maxPixels > 0 {
+		dstW, dstH = fitSize(width, height, maxPixels)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	hasAlpha := false
+	for dstY := 0; dstY < dstH; dstY++ {
+		// rows are stored bottom up unless the height is negative
+		y := height - 1 - dstY*height/dstH
+		if topDown {
+			y = dstY * height / dstH
+		}
+		row := data[pixelOffset+y*stride : pixelOffset+(y+1)*stride]
+		for dstX := 0; dstX < dstW; dstX++ {
+			x := dstX * width / dstW
+			var c color.NRGBA
+			switch bpp {
+			case 32:
+				p := row[x*4:]
+				c = color.NRGBA{R: p[2], G: p[1], B: p[0], A: p[3]}
+				hasAlpha = hasAlpha || p[3] != 0
+			case 24:
+				p := row[x*3:]
+				c = color.NRGBA{R: p[2], G: p[1], B: p[0], A: 0xff}
+			default:
+				pixelsPerByte := 8 / bpp
+				shift := uint(8 - bpp*(x%pixelsPerByte+1))
+				index := int(row[x/pixelsPerByte]>>shift) & (1<<uint(bpp) - 1)
+				if index >= len(palette) {
+					return nil, errors.New("bmp palette index out of range")
+				}
+				c = color.NRGBAModel.Convert(palette[index]).(color.NRGBA)
+			}
+			img.SetNRGBA(dstX, dstY, c)
+		}
+	}
+
+	// most 32 bit bitmaps leave the alpha channel empty, they are meant to be opaque
+	if bpp == 32 && !hasAlpha {
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = 0xff
+		}
+	}
+	return img, nil
+}
+
+// decodeICO decodes the largest image of an .ico file, see decodeBMP for maxPixels
+func decodeICO(data []byte, maxPixels int) (image.Image, error) {
+	if len(data) < 6 || binary.LittleEndian.Uint16(data[0:2]) != 0 || binary.LittleEndian.Uint16(data[2:4]) != 1 {
+		return nil, errors.New("not an ico file")
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+	if count == 0 || len(data) < 6+count*16 {
+		return nil, errors.New("ico directory is truncated")
+	}
+
+	best, bestArea := -1, 0
+	for i := 0; i < count; i++ {
+		entry := data[6+i*16:]
+		width, height := int(entry[0]), int(entry[1])
+		// a size of 0 means 256
+		if width == 0 {
+			width = 256
+		}
+		if height == 0 {
+			height = 256
+		}
+		if width*height > bestArea {
+			best, bestArea = i, width*height
+		}
+	}
+
+	entry := data[6+best*16:]
+	size := int(binary.LittleEndian.Uint32(entry[8:12]))
+	offset := int(binary.LittleEndian.Uint32(entry[12:16]))
+	if offset < 0 || size <= 0 || offset+size > len(data) {
+		return nil, errors.New("ico image is truncated")
+	}
+
+	imageData := data[offset : offset+size]
+	if bytes.HasPrefix(imageData, pngSignature) {
+		return png.Decode(bytes.NewReader(imageData))
+	}
+	return decodeDIB(imageData, -1, true, maxPixels)
+}
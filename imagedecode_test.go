@@ -0,0 +1,339 @@
+package hangups
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+var (
+	red   = color.NRGBA{R: 0xff, A: 0xff}
+	green = color.NRGBA{G: 0xff, A: 0xff}
+	blue  = color.NRGBA{B: 0xff, A: 0xff}
+	white = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+)
+
+// quadrants returns the color of a pixel of a w by h image with red, green, blue and white quadrants,
+// starting at the top left
+func quadrants(w, h int) func(x, y int) color.NRGBA {
+	return func(x, y int) color.NRGBA {
+		switch {
+		case y < h/2 && x < w/2:
+			return red
+		case y < h/2:
+			return green
+		case x < w/2:
+			return blue
+		default:
+			return white
+		}
+	}
+}
+
+// dibOptions describes a generated device independent bitmap
+type dibOptions struct {
+	width, height int
+	bpp           int  // 8, 24 or 32, 8 bit bitmaps use a palette of red, green, blue and white
+	topDown       bool // store the rows top down with a negative height
+	inIcon        bool // double the height for the AND mask of ico files
+	alpha         bool // store the alpha channel of 32 bit pixels instead of 0
+}
+
+// palette8 is the palette of generated 8 bit bitmaps
+var palette8 = []color.NRGBA{red, green, blue, white}
+
+// makeDIB encodes the pixels of a w by h image as a BITMAPINFOHEADER, palette and pixel rows
+func makeDIB(options dibOptions, pixel func(x, y int) color.NRGBA) []byte {
+	colors := 0
+	if options.bpp == 8 {
+		colors = len(palette8)
+	}
+	stride := ((options.bpp*options.width + 31) / 32) * 4
+	height := options.height
+	if options.inIcon {
+		height *= 2
+	}
+	if options.topDown {
+		height = -height
+	}
+
+	data := make([]byte, 40+colors*4+stride*options.height)
+	binary.LittleEndian.PutUint32(data[0:4], 40)
+	binary.LittleEndian.PutUint32(data[4:8], uint32(options.width))
+	binary.LittleEndian.PutUint32(data[8:12], uint32(int32(height)))
+	binary.LittleEndian.PutUint16(data[12:14], 1)
+	binary.LittleEndian.PutUint16(data[14:16], uint16(options.bpp))
+	binary.LittleEndian.PutUint32(data[32:36], uint32(colors))
+	for i := 0; i < colors; i++ {
+		c := palette8[i]
+		copy(data[40+i*4:], []byte{c.B, c.G, c.R, 0})
+	}
+
+	pixels := data[40+colors*4:]
+	for y := 0; y < options.height; y++ {
+		row := y
+		if !options.topDown {
+			row = options.height - 1 - y
+		}
+		for x := 0; x < options.width; x++ {
+			c := pixel(x, y)
+			p := pixels[row*stride:]
+			switch options.bpp {
+			case 8:
+				for i, entry := range palette8 {
+					if entry == c {
+						p[x] = byte(i)
+					}
+				}
+			case 24:
+				copy(p[x*3:], []byte{c.B, c.G, c.R})
+			case 32:
+				a := byte(0)
+				if options.alpha {
+					a = c.A
+				}
+				copy(p[x*4:], []byte{c.B, c.G, c.R, a})
+			}
+		}
+	}
+	return data
+}
+
+// makeBMP wraps a bitmap into a .bmp file
+func makeBMP(options dibOptions, pixel func(x, y int) color.NRGBA) []byte {
+	dib := makeDIB(options, pixel)
+	colors := 0
+	if options.bpp == 8 {
+		colors = len(palette8)
+	}
+	header := make([]byte, 14)
+	header[0], header[1] = 'B', 'M'
+	binary.LittleEndian.PutUint32(header[2:6], uint32(14+len(dib)))
+	binary.LittleEndian.PutUint32(header[10:14], uint32(14+40+colors*4))
+	return append(header, dib...)
+}
+
+// makeICO creates an .ico file of images, which are bitmaps or png files of the given sizes
+func makeICO(sizes [][2]int, images [][]byte) []byte {
+	data := make([]byte, 6+16*len(images))
+	binary.LittleEndian.PutUint16(data[2:4], 1)
+	binary.LittleEndian.PutUint16(data[4:6], uint16(len(images)))
+	for i, img := range images {
+		entry := data[6+i*16:]
+		entry[0], entry[1] = byte(sizes[i][0]), byte(sizes[i][1])
+		binary.LittleEndian.PutUint32(entry[8:12], uint32(len(img)))
+		binary.LittleEndian.PutUint32(entry[12:16], uint32(len(data)))
+		data = append(data, img...)
+	}
+	return data
+}
+
+// checkPixels compares the pixels of img to a w by h image drawn by pixel
+func checkPixels(t *testing.T, img image.Image, w, h int, pixel func(x, y int) color.NRGBA) {
+	t.Helper()
+	if size := img.Bounds().Size(); size.X != w || size.Y != h {
+		t.Fatalf("size = %v, want %dx%d", size, w, h)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			got := color.NRGBAModel.Convert(img.At(img.Bounds().Min.X+x, img.Bounds().Min.Y+y))
+			if got != pixel(x, y) {
+				t.Fatalf("pixel %d,%d = %v, want %v", x, y, got, pixel(x, y))
+			}
+		}
+	}
+}
+
+// checkCorners checks the corners of an image drawn by quadrants
+func checkCorners(t *testing.T, img image.Image) {
+	t.Helper()
+	bounds := img.Bounds()
+	corners := []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{bounds.Min.X, bounds.Min.Y, red},
+		{bounds.Max.X - 1, bounds.Min.Y, green},
+		{bounds.Min.X, bounds.Max.Y - 1, blue},
+		{bounds.Max.X - 1, bounds.Max.Y - 1, white},
+	}
+	for _, corner := range corners {
+		if got := color.NRGBAModel.Convert(img.At(corner.x, corner.y)); got != corner.want {
+			t.Errorf("pixel %d,%d = %v, want %v", corner.x, corner.y, got, corner.want)
+		}
+	}
+}
+
+func TestDecodeBMP(t *testing.T) {
+	tests := []struct {
+		name    string
+		options dibOptions
+	}{
+		{name: "24 bit", options: dibOptions{width: 6, height: 4, bpp: 24}},
+		{name: "24 bit with row padding", options: dibOptions{width: 5, height: 3, bpp: 24}},
+		{name: "24 bit top down", options: dibOptions{width: 6, height: 4, bpp: 24, topDown: true}},
+		{name: "8 bit palette", options: dibOptions{width: 7, height: 4, bpp: 8}},
+		{name: "32 bit without alpha", options: dibOptions{width: 4, height: 4, bpp: 32}},
+		{name: "32 bit with alpha", options: dibOptions{width: 4, height: 4, bpp: 32, alpha: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pixel := quadrants(test.options.width, test.options.height)
+			img, err := decodeBMP(makeBMP(test.options, pixel), 0)
+			if err != nil {
+				t.Fatalf("decodeBMP() error = %v", err)
+			}
+			checkPixels(t, img, test.options.width, test.options.height, pixel)
+		})
+	}
+}
+
+func TestDecodeBMPMaxPixels(t *testing.T) {
+	data := makeBMP(dibOptions{width: 200, height: 100, bpp: 24}, quadrants(200, 100))
+	img, err := decodeBMP(data, 5000)
+	if err != nil {
+		t.Fatalf("decodeBMP() error = %v", err)
+	}
+	size := img.Bounds().Size()
+	if size.X*size.Y > 5000 || size.X < 90 || size.X != 2*size.Y {
+		t.Fatalf("size = %v, want at most 5000 pixels at 2:1", size)
+	}
+	checkCorners(t, img)
+}
+
+func TestDecodeBMPErrors(t *testing.T) {
+	valid := makeBMP(dibOptions{width: 4, height: 4, bpp: 24}, quadrants(4, 4))
+	with := func(offset int, value uint32) []byte {
+		data := append([]byte{}, valid...)
+		binary.LittleEndian.PutUint32(data[offset:], value)
+		return data
+	}
+	paletted := makeBMP(dibOptions{width: 4, height: 4, bpp: 8}, quadrants(4, 4))
+	badIndex := append([]byte{}, paletted...)
+	badIndex[len(badIndex)-1] = 200
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "not a bmp", data: []byte("GIF89a, not a bitmap at all, but long enough for a header......")},
+		{name: "short header", data: valid[:30]},
+		{name: "truncated pixels", data: valid[:len(valid)-10]},
+		{name: "huge width", data: with(18, 0x7fffffff)},
+		{name: "huge height", data: with(22, 0x7fffffff)},
+		{name: "negative width", data: with(18, 0xfffffff0)},
+		{name: "zero height", data: with(22, 0)},
+		{name: "pixel offset past the end", data: with(10, 0xffffff00)},
+		{name: "rle compression", data: with(30, 1)},
+		{name: "unsupported depth", data: func() []byte {
+			data := append([]byte{}, valid...)
+			binary.LittleEndian.PutUint16(data[28:30], 16)
+			return data
+		}()},
+		{name: "palette index out of range", data: badIndex},
+		{name: "palette too large", data: func() []byte {
+			data := append([]byte{}, paletted...)
+			binary.LittleEndian.PutUint32(data[46:50], 0x10000000)
+			return data
+		}()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decodeBMP(test.data, 0); err == nil {
+				t.Error("decodeBMP() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestDecodeBMPTruncated(t *testing.T) {
+	for _, bpp := range []int{8, 24, 32} {
+		data := makeBMP(dibOptions{width: 5, height: 3, bpp: bpp}, quadrants(5, 3))
+		for n := 0; n < len(data); n++ {
+			if _, err := decodeBMP(data[:n], 0); err == nil {
+				t.Errorf("%d bit bmp truncated to %d bytes: decodeBMP() error = nil", bpp, n)
+			}
+		}
+	}
+}
+
+func TestDecodeICO(t *testing.T) {
+	small := makeDIB(dibOptions{width: 4, height: 4, bpp: 32, alpha: true, inIcon: true}, quadrants(4, 4))
+	large := makeDIB(dibOptions{width: 8, height: 6, bpp: 24, inIcon: true}, quadrants(8, 6))
+
+	var encoded bytes.Buffer
+	pngImage := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			pngImage.SetNRGBA(x, y, quadrants(16, 16)(x, y))
+		}
+	}
+	if err := png.Encode(&encoded, pngImage); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		width  int
+		height int
+	}{
+		{name: "single bitmap", data: makeICO([][2]int{{4, 4}}, [][]byte{small}), width: 4, height: 4},
+		{name: "largest bitmap", data: makeICO([][2]int{{4, 4}, {8, 6}}, [][]byte{small, large}), width: 8, height: 6},
+		{name: "png", data: makeICO([][2]int{{4, 4}, {16, 16}}, [][]byte{small, encoded.Bytes()}), width: 16, height: 16},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img, err := decodeICO(test.data, 0)
+			if err != nil {
+				t.Fatalf("decodeICO() error = %v", err)
+			}
+			checkPixels(t, img, test.width, test.height, quadrants(test.width, test.height))
+		})
+	}
+}
+
+func TestDecodeICOErrors(t *testing.T) {
+	valid := makeICO([][2]int{{4, 4}}, [][]byte{
+		makeDIB(dibOptions{width: 4, height: 4, bpp: 24, inIcon: true}, quadrants(4, 4)),
+	})
+	with := func(offset int, value uint32) []byte {
+		data := append([]byte{}, valid...)
+		binary.LittleEndian.PutUint32(data[offset:], value)
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "not an ico", data: []byte("\x00\x00\x02\x00\x01\x00")},
+		{name: "no images", data: []byte("\x00\x00\x01\x00\x00\x00")},
+		{name: "truncated directory", data: valid[:10]},
+		{name: "offset past the end", data: with(6+12, 0xfffffff0)},
+		{name: "size past the end", data: with(6+8, 0x7ffffff0)},
+		{name: "zero size", data: with(6+8, 0)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decodeICO(test.data, 0); err == nil {
+				t.Error("decodeICO() error = nil, want an error")
+			}
+		})
+	}
+
+	for n := 0; n < len(valid); n++ {
+		if _, err := decodeICO(valid[:n], 0); err == nil {
+			t.Errorf("ico truncated to %d bytes: decodeICO() error = nil", n)
+		}
+	}
+}
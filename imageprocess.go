@@ -0,0 +1,398 @@
+package hangups
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultJPEGQuality = 85
+	minJPEGQuality     = 50
+	// maxShrinkSteps limits how often an image is scaled down to meet ImageProcessor.MaxBytes
+	maxShrinkSteps = 8
+)
+
+// jpeg markers used while walking the segments of a file
+const (
+	markerSOI  = 0xd8
+	markerSOS  = 0xda
+	markerAPP1 = 0xe1
+)
+
+// ImageProcessor prepares images for upload: formats Hangouts clients cannot show are converted
+// to PNG or JPEG, large images are downsized, the EXIF orientation is applied and location data
+// is removed. It only uses the standard library decoders plus the bmp and ico decoders of this package.
+// WebP and GIF images are passed through untouched, the standard library cannot encode WebP
+// and re-encoding would drop GIF animations.
+type ImageProcessor struct {
+	MaxPixels    int   // maximum width*height, 0 for no limit
+	MaxBytes     int64 // maximum encoded size, 0 for no limit
+	JPEGQuality  int   // 85 if not set
+	KeepLocation bool  // keep EXIF data, including GPS coordinates, when the image is not re-encoded
+}
+
+// jpegMetadata is what the processor needs to know about the EXIF data of a jpeg
+type jpegMetadata struct {
+	orientation int  // EXIF orientation, 1 if absent
+	hasLocation bool // EXIF data has a GPS IFD
+	hasAPP1     bool // file has EXIF or XMP segments
+}
+
+// Process runs an image through the pipeline and returns the possibly renamed file and its new content
+func (p *ImageProcessor) Process(name string, data []byte) (string, []byte, error) {
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	isImage, imageType := getImageType(head)
+	if !isImage {
+		return "", nil, errors.New("not an image file")
+	}
+
+	var metadata jpegMetadata
+	metadata.orientation = 1
+	if imageType == "jpeg" {
+		metadata = readJPEGMetadata(data)
+	}
+
+	mustDecode := imageType == "bmp" || imageType == "ico" || metadata.orientation != 1 ||
+		(p.MaxBytes > 0 && int64(len(data)) > p.MaxBytes)
+	if !mustDecode && p.MaxPixels > 0 && (imageType == "png" || imageType == "jpeg") {
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err == nil && config.Width*config.Height > p.MaxPixels {
+			mustDecode = true
+		}
+	}
+	if imageType == "webp" || imageType == "gif" {
+		mustDecode = false
+	}
+
+	if !mustDecode {
+		if metadata.hasAPP1 && metadata.hasLocation && !p.KeepLocation {
+			return name, stripJPEGMetadata(data), nil
+		}
+		return name, data, nil
+	}
+
+	img, err := decodeImage(imageType, data, p.MaxPixels)
+	if err != nil {
+		return "", nil, err
+	}
+	img = applyOrientation(img, metadata.orientation)
+	if p.MaxPixels > 0 {
+		img = fitPixels(img, p.MaxPixels)
+	}
+
+	// lossless sources stay lossless, photos are encoded as jpeg
+	format := "jpeg"
+	if imageType == "png" || imageType == "bmp" || imageType == "ico" {
+		format = "png"
+	}
+
+	quality := p.JPEGQuality
+	if quality <= 0 || quality > 100 {
+		quality = defaultJPEGQuality
+	}
+
+	encoded, err := encodeImage(img, format, quality)
+	if err != nil {
+		return "", nil, err
+	}
+	for step := 0; p.MaxBytes > 0 && int64(len(encoded)) > p.MaxBytes; step++ {
+		if step == maxShrinkSteps {
+			return "", nil, errors.New("cannot shrink image below the size limit")
+		}
+		switch {
+		case format == "png" && isOpaque(img):
+			// opaque images get much smaller as jpeg
+			format = "jpeg"
+		case format == "jpeg" && quality > minJPEGQuality:
+			quality -= 10
+		default:
+			bounds := img.Bounds()
+			img = resize(img, bounds.Dx()*3/4, bounds.Dy()*3/4)
+		}
+		if encoded, err = encodeImage(img, format, quality); err != nil {
+			return "", nil, err
+		}
+	}
+
+	newName := strings.TrimSuffix(name, filepath.Ext(name)) + "." + format
+	if format == "jpeg" {
+		newName = strings.TrimSuffix(name, filepath.Ext(name)) + ".jpg"
+	}
+	return newName, encoded, nil
+}
+
+// encodeImage encodes an image as png or jpeg
+func encodeImage(img image.Image, format string, quality int) ([]byte, error) {
+	var buffer bytes.Buffer
+	if format == "png" {
+		err := png.Encode(&buffer, img)
+		return buffer.Bytes(), err
+	}
+
+	// jpeg has no alpha, transparent images are flattened onto white
+	err := jpeg.Encode(&buffer, flatten(img), &jpeg.Options{Quality: quality})
+	return buffer.Bytes(), err
+}
+
+// decodeImage decodes data of the image type detected by getImageType,
+// bmp and ico images are scaled down to maxPixels while decoding
+func decodeImage(imageType string, data []byte, maxPixels int) (image.Image, error) {
+	switch imageType {
+	case "bmp":
+		return decodeBMP(data, maxPixels)
+	case "ico":
+		return decodeICO(data, maxPixels)
+	case "png":
+		return png.Decode(bytes.NewReader(data))
+	case "jpeg":
+		return jpeg.Decode(bytes.NewReader(data))
+	case "gif":
+		return gif.Decode(bytes.NewReader(data))
+	default:
+		return nil, errors.New("cannot decode " + imageType + " images")
+	}
+}
+
+// readJPEGMetadata looks for the EXIF orientation and GPS data of a jpeg
+func readJPEGMetadata(data []byte) jpegMetadata {
+	metadata := jpegMetadata{orientation: 1}
+	walkJPEGSegments(data, func(marker byte, segment []byte) {
+		if marker != markerAPP1 {
+			return
+		}
+		metadata.hasAPP1 = true
+		if !bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			// XMP packets may carry location too
+			metadata.hasLocation = metadata.hasLocation || bytes.Contains(segment, []byte("GPS"))
+			return
+		}
+		orientation, hasLocation := parseEXIF(segment[6:])
+		if orientation >= 1 && orientation <= 8 {
+			metadata.orientation = orientation
+		}
+		metadata.hasLocation = metadata.hasLocation || hasLocation
+	})
+	return metadata
+}
+
+// walkJPEGSegments calls fn for every marker segment before the image data
+func walkJPEGSegments(data []byte, fn func(marker byte, segment []byte)) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != markerSOI {
+		return
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return
+		}
+		marker := data[i+1]
+		if marker == markerSOS {
+			return
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			return
+		}
+		fn(marker, data[i+4:i+2+length])
+		i += 2 + length
+	}
+}
+
+// parseEXIF reads the orientation and checks for a GPS IFD in the first IFD of a TIFF structure
+func parseEXIF(tiff []byte) (int, bool) {
+	if len(tiff) < 8 {
+		return 0, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, false
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0, false
+	}
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+
+	orientation, hasLocation := 0, false
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		switch order.Uint16(tiff[entry : entry+2]) {
+		case 0x0112: // Orientation, a SHORT stored in the value field
+			orientation = int(order.Uint16(tiff[entry+8 : entry+10]))
+		case 0x8825: // GPSInfo IFD pointer
+			hasLocation = true
+		}
+	}
+	return orientation, hasLocation
+}
+
+// stripJPEGMetadata removes the EXIF and XMP segments of a jpeg without re-encoding it
+func stripJPEGMetadata(data []byte) []byte {
+	var buffer bytes.Buffer
+	buffer.Write(data[:2])
+	end := 2
+	walkJPEGSegments(data, func(marker byte, segment []byte) {
+		start := end
+		end = start + 4 + len(segment)
+		if marker != markerAPP1 {
+			buffer.Write(data[start:end])
+		}
+	})
+	buffer.Write(data[end:])
+	return buffer.Bytes()
+}
+
+// toRGBA converts any image to an RGBA image with bounds starting at 0,0
+func toRGBA(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// applyOrientation rotates and flips an image according to its EXIF orientation
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	src := toRGBA(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotated 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotated 90 clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotated 90 counter clockwise
+				sx, sy = w-1-y, x
+			}
+			si := src.PixOffset(sx, sy)
+			di := dst.PixOffset(x, y)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// fitPixels scales an image down to at most maxPixels pixels, keeping its aspect ratio
+func fitPixels(img image.Image, maxPixels int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w*h <= maxPixels {
+		return img
+	}
+	newW, newH := fitSize(w, h, maxPixels)
+	return resize(img, newW, newH)
+}
+
+// fitSize returns the size of a w by h image scaled down to at most maxPixels pixels
+func fitSize(w, h, maxPixels int) (int, int) {
+	// shrink both sides by the same factor until the area fits
+	newW, newH := w, h
+	for newW*newH > maxPixels && newW > 1 && newH > 1 {
+		newW = newW * 9 / 10
+		newH = h * newW / w
+	}
+	if newH < 1 {
+		newH = 1
+	}
+	return newW, newH
+}
+
+// resize scales an image down by averaging the source pixels covered by every destination pixel
+func resize(img image.Image, newW, newH int) image.Image {
+	if newW < 1 {
+		newW = 1
+	}
+	if newH < 1 {
+		newH = 1
+	}
+	src := toRGBA(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
+
+	for y := 0; y < newH; y++ {
+		y0, y1 := y*h/newH, (y+1)*h/newH
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < newW; x++ {
+			x0, x1 := x*w/newW, (x+1)*w/newW
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					i := src.PixOffset(sx, sy)
+					r += int(src.Pix[i])
+					g += int(src.Pix[i+1])
+					b += int(src.Pix[i+2])
+					a += int(src.Pix[i+3])
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// isOpaque checks if an image has no transparent pixels
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// flatten draws an image onto a white background
+func flatten(img image.Image) image.Image {
+	if isOpaque(img) {
+		return img
+	}
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Over)
+	return dst
+}
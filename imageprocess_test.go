@@ -0,0 +1,349 @@
+package hangups
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// quadrantImage draws a w by h image with red, green, blue and white quadrants
+func quadrantImage(w, h int) *image.NRGBA {
+	pixel := quadrants(w, h)
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, pixel(x, y))
+		}
+	}
+	return img
+}
+
+// exifSegment creates an APP1 EXIF segment with an orientation and optionally a GPS IFD pointer
+func exifSegment(order binary.ByteOrder, orientation int, gps bool) []byte {
+	entries := 1
+	if gps {
+		entries++
+	}
+	tiff := make([]byte, 8+2+entries*12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:4], 42)
+	order.PutUint32(tiff[4:8], 8)
+	order.PutUint16(tiff[8:10], uint16(entries))
+	entry := tiff[10:]
+	order.PutUint16(entry[0:2], 0x0112)
+	order.PutUint16(entry[2:4], 3)
+	order.PutUint32(entry[4:8], 1)
+	order.PutUint16(entry[8:10], uint16(orientation))
+	if gps {
+		entry = tiff[22:]
+		order.PutUint16(entry[0:2], 0x8825)
+		order.PutUint16(entry[2:4], 4)
+		order.PutUint32(entry[4:8], 1)
+		order.PutUint32(entry[8:12], uint32(len(tiff)))
+	}
+	return app1Segment(append([]byte("Exif\x00\x00"), tiff...))
+}
+
+// app1Segment wraps a payload into an APP1 marker segment
+func app1Segment(payload []byte) []byte {
+	segment := []byte{0xff, markerAPP1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:4], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// encodeJPEG encodes an image as jpeg and inserts segments right after the SOI marker
+func encodeJPEG(t *testing.T, img image.Image, segments ...[]byte) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	out := append([]byte{}, data[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return append(out, data[2:]...)
+}
+
+// encodePNG encodes an image as png
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// near checks if a color is within a jpeg compression tolerance of want
+func near(c color.Color, want color.NRGBA) bool {
+	got := color.NRGBAModel.Convert(c).(color.NRGBA)
+	diff := func(a, b uint8) int {
+		if a > b {
+			return int(a - b)
+		}
+		return int(b - a)
+	}
+	return diff(got.R, want.R) < 48 && diff(got.G, want.G) < 48 && diff(got.B, want.B) < 48
+}
+
+func TestProcessOrientation(t *testing.T) {
+	// where the quadrants of the stored image end up once the orientation is applied,
+	// listed from the top left, top right, bottom left and bottom right of the result
+	tests := []struct {
+		orientation int
+		rotated     bool
+		want        [4]color.NRGBA
+	}{
+		{orientation: 1, want: [4]color.NRGBA{red, green, blue, white}},
+		{orientation: 2, want: [4]color.NRGBA{green, red, white, blue}},
+		{orientation: 3, want: [4]color.NRGBA{white, blue, green, red}},
+		{orientation: 4, want: [4]color.NRGBA{blue, white, red, green}},
+		{orientation: 5, rotated: true, want: [4]color.NRGBA{red, blue, green, white}},
+		{orientation: 6, rotated: true, want: [4]color.NRGBA{blue, red, white, green}},
+		{orientation: 7, rotated: true, want: [4]color.NRGBA{white, green, blue, red}},
+		{orientation: 8, rotated: true, want: [4]color.NRGBA{green, white, red, blue}},
+	}
+
+	for _, test := range tests {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			data := encodeJPEG(t, quadrantImage(64, 32), exifSegment(order, test.orientation, false))
+			processor := &ImageProcessor{}
+			name, processed, err := processor.Process("photo.jpeg", data)
+			if err != nil {
+				t.Fatalf("orientation %d: Process() error = %v", test.orientation, err)
+			}
+			if test.orientation != 1 && name != "photo.jpg" {
+				t.Errorf("orientation %d: name = %s, want photo.jpg", test.orientation, name)
+			}
+			if metadata := readJPEGMetadata(processed); test.orientation != 1 && metadata.orientation != 1 {
+				t.Errorf("orientation %d: processed image has orientation %d, it would be rotated twice",
+					test.orientation, metadata.orientation)
+			}
+
+			img, err := jpeg.Decode(bytes.NewReader(processed))
+			if err != nil {
+				t.Fatalf("orientation %d: %v", test.orientation, err)
+			}
+			w, h := 64, 32
+			if test.rotated {
+				w, h = 32, 64
+			}
+			if size := img.Bounds().Size(); size.X != w || size.Y != h {
+				t.Fatalf("orientation %d: size = %v, want %dx%d", test.orientation, size, w, h)
+			}
+			points := [4]image.Point{{w / 4, h / 4}, {w * 3 / 4, h / 4}, {w / 4, h * 3 / 4}, {w * 3 / 4, h * 3 / 4}}
+			for i, point := range points {
+				if c := img.At(point.X, point.Y); !near(c, test.want[i]) {
+					t.Errorf("orientation %d: pixel %v = %v, want %v", test.orientation, point, c, test.want[i])
+				}
+			}
+		}
+	}
+}
+
+func TestProcessLocation(t *testing.T) {
+	photo := quadrantImage(16, 16)
+	xmp := app1Segment([]byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta><exif:GPSLatitude>52,31N</exif:GPSLatitude></x:xmpmeta>"))
+
+	tests := []struct {
+		name         string
+		data         []byte
+		keepLocation bool
+		wantLocation bool
+		unchanged    bool
+	}{
+		{name: "gps removed", data: encodeJPEG(t, photo, exifSegment(binary.BigEndian, 1, true))},
+		{name: "gps in xmp removed", data: encodeJPEG(t, photo, xmp)},
+		{name: "gps removed when rotating", data: encodeJPEG(t, photo, exifSegment(binary.LittleEndian, 6, true))},
+		{name: "gps kept", data: encodeJPEG(t, photo, exifSegment(binary.BigEndian, 1, true)),
+			keepLocation: true, wantLocation: true, unchanged: true},
+		{name: "exif without gps kept", data: encodeJPEG(t, photo, exifSegment(binary.BigEndian, 1, false)), unchanged: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			processor := &ImageProcessor{KeepLocation: test.keepLocation}
+			_, processed, err := processor.Process("photo.jpg", test.data)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if metadata := readJPEGMetadata(processed); metadata.hasLocation != test.wantLocation {
+				t.Errorf("processed image has location = %v, want %v", metadata.hasLocation, test.wantLocation)
+			}
+			if unchanged := bytes.Equal(processed, test.data); unchanged != test.unchanged {
+				t.Errorf("processed image unchanged = %v, want %v", unchanged, test.unchanged)
+			}
+			if _, err = jpeg.Decode(bytes.NewReader(processed)); err != nil {
+				t.Errorf("processed image does not decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestProcessLimits(t *testing.T) {
+	noise := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+	random := rand.New(rand.NewSource(1))
+	random.Read(noise.Pix)
+	for i := 3; i < len(noise.Pix); i += 4 {
+		noise.Pix[i] = 0xff
+	}
+
+	tests := []struct {
+		name      string
+		file      string
+		data      []byte
+		processor ImageProcessor
+		wantName  string
+		maxPixels int
+		maxBytes  int
+	}{
+		{name: "small png unchanged", file: "a.png", data: encodePNG(t, quadrantImage(40, 20)),
+			processor: ImageProcessor{MaxPixels: 1000, MaxBytes: 1 << 20}, wantName: "a.png", maxPixels: 800},
+		{name: "png over MaxPixels", file: "a.png", data: encodePNG(t, quadrantImage(200, 100)),
+			processor: ImageProcessor{MaxPixels: 5000}, wantName: "a.png", maxPixels: 5000},
+		{name: "jpeg over MaxPixels", file: "a.jpg", data: encodeJPEG(t, quadrantImage(200, 100)),
+			processor: ImageProcessor{MaxPixels: 5000}, wantName: "a.jpg", maxPixels: 5000},
+		{name: "bmp converted to png", file: "a.bmp", data: makeBMP(dibOptions{width: 40, height: 20, bpp: 24}, quadrants(40, 20)),
+			wantName: "a.png", maxPixels: 800},
+		{name: "bmp over MaxPixels", file: "a.bmp", data: makeBMP(dibOptions{width: 200, height: 100, bpp: 24}, quadrants(200, 100)),
+			processor: ImageProcessor{MaxPixels: 5000}, wantName: "a.png", maxPixels: 5000},
+		{name: "ico converted to png", file: "favicon.ico", wantName: "favicon.png", maxPixels: 256,
+			data: makeICO([][2]int{{16, 16}}, [][]byte{makeDIB(dibOptions{width: 16, height: 16, bpp: 32, alpha: true, inIcon: true}, quadrants(16, 16))})},
+		{name: "opaque png over MaxBytes becomes jpeg", file: "noise.png", data: encodePNG(t, noise),
+			processor: ImageProcessor{MaxBytes: 8000}, wantName: "noise.jpg", maxBytes: 8000},
+		{name: "jpeg over MaxBytes", file: "noise.jpg", data: encodeJPEG(t, noise),
+			processor: ImageProcessor{MaxBytes: 6000}, wantName: "noise.jpg", maxBytes: 6000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, processed, err := test.processor.Process(test.file, test.data)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if name != test.wantName {
+				t.Errorf("name = %s, want %s", name, test.wantName)
+			}
+			if test.maxBytes > 0 && len(processed) > test.maxBytes {
+				t.Errorf("processed image has %d bytes, want at most %d", len(processed), test.maxBytes)
+			}
+			img, _, err := image.Decode(bytes.NewReader(processed))
+			if err != nil {
+				t.Fatalf("processed image does not decode: %v", err)
+			}
+			if size := img.Bounds().Size(); test.maxPixels > 0 && size.X*size.Y > test.maxPixels {
+				t.Errorf("processed image is %v, want at most %d pixels", size, test.maxPixels)
+			}
+			if test.maxBytes == 0 {
+				checkCornersNear(t, img)
+			}
+		})
+	}
+}
+
+// checkCornersNear checks the corners of a possibly lossy image drawn by quadrants
+func checkCornersNear(t *testing.T, img image.Image) {
+	t.Helper()
+	bounds := img.Bounds()
+	corners := []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{bounds.Min.X + 1, bounds.Min.Y + 1, red},
+		{bounds.Max.X - 2, bounds.Min.Y + 1, green},
+		{bounds.Min.X + 1, bounds.Max.Y - 2, blue},
+		{bounds.Max.X - 2, bounds.Max.Y - 2, white},
+	}
+	for _, corner := range corners {
+		if c := img.At(corner.x, corner.y); !near(c, corner.want) {
+			t.Errorf("pixel %d,%d = %v, want %v", corner.x, corner.y, c, corner.want)
+		}
+	}
+}
+
+func TestProcessErrors(t *testing.T) {
+	bmp := makeBMP(dibOptions{width: 4, height: 4, bpp: 24}, quadrants(4, 4))
+	noise := make([]byte, 64*64*4)
+	rand.New(rand.NewSource(2)).Read(noise)
+	noisy := &image.NRGBA{Pix: noise, Stride: 64 * 4, Rect: image.Rect(0, 0, 64, 64)}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		processor ImageProcessor
+		want      string
+	}{
+		{name: "not an image", data: []byte("just some text"), want: "not an image"},
+		{name: "truncated bmp", data: bmp[:40], want: "bmp"},
+		{name: "size limit out of reach", data: encodePNG(t, noisy), processor: ImageProcessor{MaxBytes: 10}, want: "size limit"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := test.processor.Process("file", test.data)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Process() error = %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestMalformedEXIF(t *testing.T) {
+	photo := encodeJPEG(t, quadrantImage(8, 8), exifSegment(binary.LittleEndian, 6, true))
+	tiff := exifSegment(binary.BigEndian, 6, true)[10:]
+
+	// no prefix of a jpeg or its TIFF structure panics
+	for n := 0; n <= len(photo); n++ {
+		metadata := readJPEGMetadata(photo[:n])
+		if metadata.hasAPP1 {
+			if stripped := stripJPEGMetadata(photo[:n]); len(stripped) >= n {
+				t.Fatalf("stripping a %d byte prefix returned %d bytes", n, len(stripped))
+			}
+		}
+		if metadata.orientation < 1 || metadata.orientation > 8 {
+			t.Fatalf("orientation of a %d byte prefix = %d", n, metadata.orientation)
+		}
+	}
+	for n := 0; n <= len(tiff); n++ {
+		parseEXIF(tiff[:n])
+	}
+
+	tests := []struct {
+		name            string
+		tiff            []byte
+		wantOrientation int
+		wantLocation    bool
+	}{
+		{name: "valid", tiff: tiff, wantOrientation: 6, wantLocation: true},
+		{name: "unknown byte order", tiff: append([]byte("XX"), tiff[2:]...)},
+		{name: "ifd offset past the end", tiff: append(append([]byte{}, tiff[:4]...), 0x7f, 0xff, 0xff, 0xff)},
+		{name: "ifd offset inside the header", tiff: append(append([]byte{}, tiff[:4]...), 0, 0, 0, 2)},
+		{name: "entry count past the end", tiff: append(append([]byte{}, tiff[:8]...), 0xff, 0xff)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			orientation, hasLocation := parseEXIF(test.tiff)
+			if orientation != test.wantOrientation || hasLocation != test.wantLocation {
+				t.Errorf("parseEXIF() = %d, %v, want %d, %v", orientation, hasLocation, test.wantOrientation, test.wantLocation)
+			}
+		})
+	}
+
+	// an orientation out of range is ignored
+	data := encodeJPEG(t, quadrantImage(8, 8), exifSegment(binary.LittleEndian, 9, false))
+	if metadata := readJPEGMetadata(data); metadata.orientation != 1 {
+		t.Errorf("orientation 9 read as %d, want 1", metadata.orientation)
+	}
+}
@@ -0,0 +1,48 @@
+// Package idset provides the bounded set of ids used to recognise delivered, relayed and sent messages.
+package idset
+
+import "sync"
+
+// Set is a set of ids evicting the oldest above its capacity, it is safe for concurrent use
+type Set struct {
+	capacity int
+
+	mu    sync.Mutex
+	ids   map[string]struct{}
+	order []string // insertion order for eviction
+}
+
+// New creates an empty set, a capacity of 0 or less is not enforced
+func New(capacity int) *Set {
+	return &Set{capacity: capacity, ids: make(map[string]struct{})}
+}
+
+// Add adds an id and reports if it was new, empty ids are never stored and always new
+func (s *Set) Add(id string) bool {
+	if id == "" {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+	s.ids[id] = struct{}{}
+	s.order = append(s.order, id)
+	if s.capacity > 0 && len(s.order) > s.capacity {
+		delete(s.ids, s.order[0])
+		s.order = s.order[1:]
+	}
+	return true
+}
+
+// Has checks if an id is in the set
+func (s *Set) Has(id string) bool {
+	if id == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.ids[id]
+	return ok
+}
@@ -0,0 +1,37 @@
+package idset
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	set := New(3)
+	if !set.Add("a") || set.Add("a") {
+		t.Error("Add() does not report new ids")
+	}
+	if !set.Add("") || set.Has("") {
+		t.Error("empty id stored")
+	}
+	for i := 0; i < 3; i++ {
+		set.Add(strconv.Itoa(i))
+	}
+	if set.Has("a") {
+		t.Error("oldest id not evicted above the capacity")
+	}
+	for i := 0; i < 3; i++ {
+		if !set.Has(strconv.Itoa(i)) {
+			t.Errorf("id %d evicted", i)
+		}
+	}
+}
+
+func TestSetUnbounded(t *testing.T) {
+	set := New(0)
+	for i := 0; i < 100; i++ {
+		set.Add(strconv.Itoa(i))
+	}
+	if !set.Has("0") {
+		t.Error("id evicted without a capacity")
+	}
+}
@@ -0,0 +1,108 @@
+package irc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// Backend is the hangouts account behind the gateway
+type Backend interface {
+	// Self returns the entity of the current user
+	Self() (*hangouts.Entity, error)
+	// Conversations returns the recent conversations
+	Conversations() ([]*hangouts.Conversation, error)
+	// Conversation returns a conversation, used for conversations which appear after the start
+	Conversation(conversationID string) (*hangouts.Conversation, error)
+	// Send sends a message, as a "/me" action message if action is set
+	Send(conversationID, text string, action bool) error
+	// Rename renames a conversation
+	Rename(conversationID, name string) error
+	// Leave leaves a conversation
+	Leave(conversationID string) error
+	// Run receives new events until ctx is done, messages sent by Send are not received
+	Run(ctx context.Context, handler hangups.EventHandler) error
+}
+
+// maxConversations is the number of recent conversations turned into channels
+const maxConversations = 100
+
+// clientBackend is the Backend of a Client
+type clientBackend struct {
+	client *hangups.Client
+}
+
+// NewClientBackend creates the Backend of client
+func NewClientBackend(client *hangups.Client) Backend {
+	if client.EchoSuppressor == nil {
+		client.EchoSuppressor = hangups.NewEchoSuppressor()
+	}
+	return &clientBackend{client: client}
+}
+
+// checkResult returns the error of a failed request or of its response
+func checkResult(header *hangouts.ResponseHeader, err error) error {
+	if err != nil {
+		return err
+	}
+	return hangups.CheckResponseHeader(header)
+}
+
+func (b *clientBackend) Self() (*hangouts.Entity, error) {
+	info, err := b.client.GetSelfInfo()
+	if err = checkResult(info.GetResponseHeader(), err); err != nil {
+		return nil, err
+	}
+	return info.GetSelfEntity(), nil
+}
+
+func (b *clientBackend) Conversations() ([]*hangouts.Conversation, error) {
+	response, err := b.client.SyncRecentConversations(maxConversations, 1)
+	if err = checkResult(response.GetResponseHeader(), err); err != nil {
+		return nil, err
+	}
+	conversations := make([]*hangouts.Conversation, 0)
+	for _, state := range response.GetConversationState() {
+		if conversation := state.GetConversation(); conversation != nil {
+			conversations = append(conversations, conversation)
+		}
+	}
+	return conversations, nil
+}
+
+func (b *clientBackend) Conversation(conversationID string) (*hangouts.Conversation, error) {
+	response, err := b.client.GetConversation(conversationID, false, 0)
+	if err = checkResult(response.GetResponseHeader(), err); err != nil {
+		return nil, err
+	}
+	conversation := response.GetConversationState().GetConversation()
+	if conversation == nil {
+		return nil, errors.New("no such conversation " + conversationID)
+	}
+	return conversation, nil
+}
+
+func (b *clientBackend) Send(conversationID, text string, action bool) error {
+	var options []hangups.MessageOption
+	if action {
+		options = append(options, hangups.WithAction())
+	}
+	_, err := b.client.Send(conversationID, text, options...)
+	return err
+}
+
+func (b *clientBackend) Rename(conversationID, name string) error {
+	response, err := b.client.RenameConversation(conversationID, name)
+	return checkResult(response.GetResponseHeader(), err)
+}
+
+func (b *clientBackend) Leave(conversationID string) error {
+	response, err := b.client.RemoveUser(conversationID)
+	return checkResult(response.GetResponseHeader(), err)
+}
+
+func (b *clientBackend) Run(ctx context.Context, handler hangups.EventHandler) error {
+	return hangups.NewPoller(b.client).Run(ctx, handler)
+}
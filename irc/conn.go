@@ -0,0 +1,560 @@
+package irc
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// idleTimeout closes connections which do not answer a PING in time
+	idleTimeout = 5 * time.Minute
+	pingTimeout = time.Minute
+	// maxInputLength closes connections sending longer lines, IRCv3 tags may exceed the 512 bytes of RFC 1459
+	maxInputLength = 8192
+)
+
+// conn is a connected IRC client
+type conn struct {
+	server  *Server
+	netConn net.Conn
+
+	wmu    sync.Mutex
+	writer *bufio.Writer
+
+	mu         sync.Mutex
+	nick       string
+	user       string
+	passOK     bool
+	registered bool
+	closed     bool
+}
+
+// newConn creates the connection of a client
+func newConn(server *Server, netConn net.Conn) *conn {
+	return &conn{
+		server:  server,
+		netConn: netConn,
+		writer:  bufio.NewWriter(netConn),
+		passOK:  server.Password == "",
+	}
+}
+
+// nickName returns the nick of the client
+func (c *conn) nickName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nick == "" {
+		return "*"
+	}
+	return c.nick
+}
+
+// isRegistered checks if the client completed registration
+func (c *conn) isRegistered() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.registered
+}
+
+// prefix returns the nick!user@host of the client
+func (c *conn) prefix() string {
+	return c.nickName() + "!" + c.server.selfID() + "@" + userHost
+}
+
+// serve reads commands until the client disconnects
+func (c *conn) serve() {
+	defer c.server.removeConn(c)
+	defer c.netConn.Close()
+
+	reader := bufio.NewReader(c.netConn)
+	var pending strings.Builder
+	pinged := false
+	for {
+		timeout := idleTimeout
+		if pinged {
+			timeout = pingTimeout
+		}
+		_ = c.netConn.SetReadDeadline(time.Now().Add(timeout))
+		data, err := reader.ReadString('\n')
+		pending.WriteString(data)
+		if pending.Len() > maxInputLength {
+			c.close("line too long")
+			return
+		}
+		if err != nil {
+			// an idle client is pinged once before it is dropped, a timeout keeps the partial line
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() && !pinged {
+				pinged = true
+				c.send(&Message{Command: "PING", Params: []string{c.server.serverName()}})
+				continue
+			}
+			return
+		}
+		pinged = false
+
+		line := pending.String()
+		pending.Reset()
+		if !utf8.ValidString(line) {
+			line = strings.ToValidUTF8(line, "\uFFFD")
+		}
+		if message := ParseMessage(line); message != nil {
+			if quit := c.handle(message); quit {
+				return
+			}
+		}
+	}
+}
+
+// close closes the connection with an ERROR line
+func (c *conn) close(reason string) {
+	c.mu.Lock()
+	closed := c.closed
+	c.closed = true
+	c.mu.Unlock()
+	if !closed {
+		c.send(&Message{Command: "ERROR", Params: []string{"Closing link: " + reason}})
+		c.netConn.Close()
+	}
+}
+
+// send writes a message to the client
+func (c *conn) send(message *Message) {
+	line := message.String()
+	if len(line) > maxLineLength {
+		line = truncateUTF8(line, maxLineLength)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_ = c.netConn.SetWriteDeadline(time.Now().Add(pingTimeout))
+	if _, err := c.writer.WriteString(line + "\r\n"); err == nil {
+		_ = c.writer.Flush()
+	}
+}
+
+// reply sends a numeric reply to the client
+func (c *conn) reply(numeric string, params ...string) {
+	c.send(&Message{
+		Prefix:  c.server.serverName(),
+		Command: numeric,
+		Params:  append([]string{c.nickName()}, params...),
+	})
+}
+
+// notice sends a server notice to the client
+func (c *conn) notice(text string) {
+	c.send(&Message{Prefix: c.server.serverName(), Command: "NOTICE", Params: []string{c.nickName(), text}})
+}
+
+// privmsg sends a message to the client, line by line and split to fit the line length
+func (c *conn) privmsg(prefix, target, text string, action bool) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		for _, part := range splitText(line, maxTextLength) {
+			if action {
+				part = "\x01ACTION " + part + "\x01"
+			}
+			c.send(&Message{Prefix: prefix, Command: "PRIVMSG", Params: []string{target, part}})
+		}
+	}
+}
+
+// splitText splits text into parts of at most n bytes, preferring to split at spaces
+func splitText(text string, n int) []string {
+	if text == "" {
+		return []string{" "}
+	}
+	parts := make([]string, 0, 1)
+	for len(text) > n {
+		cut := truncateUTF8(text, n)
+		if i := strings.LastIndexByte(cut, ' '); i > n/2 {
+			cut = cut[:i]
+		}
+		parts = append(parts, cut)
+		text = strings.TrimLeft(text[len(cut):], " ")
+	}
+	return append(parts, text)
+}
+
+// handle runs a command and reports if the connection has to be closed
+func (c *conn) handle(message *Message) bool {
+	switch message.Command {
+	case "PASS":
+		c.handlePass(message)
+		return false
+	case "NICK":
+		c.handleNick(message)
+		return false
+	case "USER":
+		c.handleUser(message)
+		return false
+	case "CAP":
+		// no capabilities are supported, the list is empty
+		if strings.ToUpper(message.Param(0)) == "LS" || strings.ToUpper(message.Param(0)) == "LIST" {
+			c.send(&Message{Prefix: c.server.serverName(), Command: "CAP", Params: []string{"*", strings.ToUpper(message.Param(0)), ""}})
+		}
+		return false
+	case "PING":
+		c.send(&Message{Prefix: c.server.serverName(), Command: "PONG", Params: []string{c.server.serverName(), message.Param(0)}})
+		return false
+	case "PONG":
+		return false
+	case "QUIT":
+		c.close("quit")
+		return true
+	}
+
+	if !c.isRegistered() {
+		c.reply("451", "You have not registered")
+		return false
+	}
+
+	switch message.Command {
+	case "JOIN":
+		c.handleJoin(message)
+	case "PART":
+		c.handlePart(message)
+	case "PRIVMSG", "NOTICE":
+		c.handlePrivmsg(message)
+	case "TOPIC":
+		c.handleTopic(message)
+	case "NAMES":
+		c.handleNames(message)
+	case "LIST":
+		c.handleList(message)
+	case "WHO":
+		c.handleWho(message)
+	case "WHOIS":
+		c.handleWhois(message)
+	case "MODE":
+		c.handleMode(message)
+	case "MOTD":
+		c.reply("422", "MOTD File is missing")
+	case "AWAY", "USERHOST", "ISON":
+		// accepted but not supported, clients send them periodically
+	default:
+		c.reply("421", message.Command, "Unknown command")
+	}
+	return false
+}
+
+func (c *conn) handlePass(message *Message) {
+	if c.isRegistered() {
+		c.reply("462", "You may not reregister")
+		return
+	}
+	c.mu.Lock()
+	c.passOK = c.server.Password == "" || message.Param(0) == c.server.Password
+	c.mu.Unlock()
+}
+
+func (c *conn) handleNick(message *Message) {
+	nick := message.Param(0)
+	if nick == "" {
+		c.reply("431", "No nickname given")
+		return
+	}
+	if !validNick(nick) {
+		c.reply("432", nick, "Erroneous nickname")
+		return
+	}
+	if c.server.nickTaken(nick) {
+		c.reply("433", nick, "Nickname is already in use")
+		return
+	}
+
+	oldPrefix := c.prefix()
+	c.mu.Lock()
+	c.nick = nick
+	registered := c.registered
+	c.mu.Unlock()
+
+	if registered {
+		c.send(&Message{Prefix: oldPrefix, Command: "NICK", Params: []string{nick}})
+	} else {
+		c.register()
+	}
+}
+
+func (c *conn) handleUser(message *Message) {
+	if c.isRegistered() {
+		c.reply("462", "You may not reregister")
+		return
+	}
+	if len(message.Params) < 4 {
+		c.reply("461", "USER", "Not enough parameters")
+		return
+	}
+	c.mu.Lock()
+	c.user = message.Param(0)
+	c.mu.Unlock()
+	c.register()
+}
+
+// register completes the registration once NICK and USER were received
+func (c *conn) register() {
+	c.mu.Lock()
+	if c.registered || c.nick == "" || c.user == "" {
+		c.mu.Unlock()
+		return
+	}
+	if !c.passOK {
+		c.mu.Unlock()
+		c.reply("464", "Password incorrect")
+		c.close("bad password")
+		return
+	}
+	c.registered = true
+	c.mu.Unlock()
+
+	server := c.server.serverName()
+	c.reply("001", fmt.Sprintf("Welcome to the hangouts IRC gateway %s", c.prefix()))
+	c.reply("002", fmt.Sprintf("Your host is %s, running hangups", server))
+	c.reply("003", fmt.Sprintf("This server was created %s", c.server.created.Format(time.RFC1123)))
+	c.reply("004", server, "hangups", "i", "nt")
+	c.reply("005", "CHANTYPES=#", "CASEMAPPING=ascii", "NETWORK=hangouts", "NICKLEN=30", "are supported by this server")
+	c.reply("422", "MOTD File is missing")
+
+	for _, ch := range c.server.channelList() {
+		c.join(ch)
+	}
+}
+
+// join shows a channel to the client as if it joined it
+func (c *conn) join(ch *channel) {
+	c.send(&Message{Prefix: c.prefix(), Command: "JOIN", Params: []string{ch.name}})
+	c.sendTopic(ch)
+	c.sendNames(ch)
+}
+
+// sendTopic sends the topic of a channel
+func (c *conn) sendTopic(ch *channel) {
+	if topic := ch.topic(); topic != "" {
+		c.reply("332", ch.name, topic)
+	} else {
+		c.reply("331", ch.name, "No topic is set")
+	}
+}
+
+// sendNames sends the nicks of a channel
+func (c *conn) sendNames(ch *channel) {
+	nicks := make([]string, 0)
+	for _, id := range c.server.members(ch) {
+		nicks = append(nicks, c.server.nickOf(c, id))
+	}
+	// split into lines of a safe length
+	line := make([]string, 0)
+	length := 0
+	for _, nick := range nicks {
+		if length+len(nick) > maxTextLength && len(line) > 0 {
+			c.reply("353", "=", ch.name, strings.Join(line, " "))
+			line, length = line[:0], 0
+		}
+		line = append(line, nick)
+		length += len(nick) + 1
+	}
+	if len(line) > 0 {
+		c.reply("353", "=", ch.name, strings.Join(line, " "))
+	}
+	c.reply("366", ch.name, "End of /NAMES list")
+}
+
+func (c *conn) handleJoin(message *Message) {
+	if message.Param(0) == "" {
+		c.reply("461", "JOIN", "Not enough parameters")
+		return
+	}
+	if message.Param(0) == "0" {
+		return
+	}
+	for _, name := range strings.Split(message.Param(0), ",") {
+		ch, ok := c.server.channel(name)
+		if !ok {
+			// conversations cannot be created by name
+			c.reply("403", name, "No such channel")
+			continue
+		}
+		c.join(ch)
+	}
+}
+
+func (c *conn) handlePart(message *Message) {
+	if message.Param(0) == "" {
+		c.reply("461", "PART", "Not enough parameters")
+		return
+	}
+	for _, name := range strings.Split(message.Param(0), ",") {
+		ch, ok := c.server.channel(name)
+		if !ok {
+			c.reply("403", name, "No such channel")
+			continue
+		}
+		if err := c.server.Backend.Leave(ch.conversation.GetConversationId().GetId()); err != nil {
+			c.notice(fmt.Sprintf("cannot leave %s : %v", ch.name, err))
+			continue
+		}
+		c.server.removeChannel(ch)
+		for _, other := range c.server.registered() {
+			other.send(&Message{Prefix: other.prefix(), Command: "PART", Params: []string{ch.name, message.Param(1)}})
+		}
+	}
+}
+
+func (c *conn) handlePrivmsg(message *Message) {
+	target, text := message.Param(0), message.Param(1)
+	if target == "" {
+		c.reply("411", "No recipient given ("+message.Command+")")
+		return
+	}
+	if len(message.Params) < 2 || text == "" {
+		c.reply("412", "No text to send")
+		return
+	}
+
+	action := false
+	if strings.HasPrefix(text, "\x01") {
+		ctcp := strings.TrimSuffix(strings.TrimPrefix(text, "\x01"), "\x01")
+		if !strings.HasPrefix(ctcp, "ACTION ") {
+			// other CTCP requests like VERSION are not answered
+			return
+		}
+		text, action = strings.TrimPrefix(ctcp, "ACTION "), true
+	}
+
+	var ch *channel
+	var ok bool
+	if strings.HasPrefix(target, "#") {
+		if ch, ok = c.server.channel(target); !ok {
+			c.reply("403", target, "No such channel")
+			return
+		}
+	} else {
+		gaiaID, known := c.server.userOf(c, target)
+		if !known {
+			c.reply("401", target, "No such nick/channel")
+			return
+		}
+		if ch, ok = c.server.directChannel(gaiaID); !ok {
+			c.reply("401", target, "No one to one conversation with "+target)
+			return
+		}
+	}
+
+	if err := c.server.Backend.Send(ch.conversation.GetConversationId().GetId(), text, action); err != nil {
+		c.reply("404", target, "Cannot send to channel: "+err.Error())
+		return
+	}
+	// other connections of the account see the message, the sender does not need an echo
+	for _, other := range c.server.registered() {
+		if other != c {
+			other.privmsg(other.prefix(), ch.name, text, action)
+		}
+	}
+}
+
+func (c *conn) handleTopic(message *Message) {
+	ch, ok := c.server.channel(message.Param(0))
+	if !ok {
+		c.reply("403", message.Param(0), "No such channel")
+		return
+	}
+	if len(message.Params) < 2 {
+		c.sendTopic(ch)
+		return
+	}
+
+	name := message.Param(1)
+	if err := c.server.Backend.Rename(ch.conversation.GetConversationId().GetId(), name); err != nil {
+		c.notice(fmt.Sprintf("cannot rename %s : %v", ch.name, err))
+		return
+	}
+	// the rename event of the conversation announces the new topic
+}
+
+func (c *conn) handleNames(message *Message) {
+	if message.Param(0) == "" {
+		for _, ch := range c.server.channelList() {
+			c.sendNames(ch)
+		}
+		return
+	}
+	for _, name := range strings.Split(message.Param(0), ",") {
+		if ch, ok := c.server.channel(name); ok {
+			c.sendNames(ch)
+		} else {
+			c.reply("366", name, "End of /NAMES list")
+		}
+	}
+}
+
+func (c *conn) handleList(message *Message) {
+	c.reply("321", "Channel", "Users  Name")
+	for _, ch := range c.server.channelList() {
+		c.reply("322", ch.name, fmt.Sprint(len(c.server.members(ch))), ch.topic())
+	}
+	c.reply("323", "End of /LIST")
+}
+
+func (c *conn) handleWho(message *Message) {
+	mask := message.Param(0)
+	server := c.server.serverName()
+	if ch, ok := c.server.channel(mask); ok {
+		for _, id := range c.server.members(ch) {
+			nick := c.server.nickOf(c, id)
+			c.reply("352", ch.name, id, userHost, server, nick, "H", "0 "+nick)
+		}
+	} else if id, ok := c.server.userOf(c, mask); ok {
+		nick := c.server.nickOf(c, id)
+		c.reply("352", "*", id, userHost, server, nick, "H", "0 "+nick)
+	}
+	c.reply("315", mask, "End of /WHO list")
+}
+
+func (c *conn) handleWhois(message *Message) {
+	nick := message.Param(len(message.Params) - 1)
+	id, ok := c.server.userOf(c, nick)
+	if !ok {
+		c.reply("401", nick, "No such nick/channel")
+		c.reply("318", nick, "End of /WHOIS list")
+		return
+	}
+	nick = c.server.nickOf(c, id)
+	c.reply("311", nick, id, userHost, "*", nick)
+
+	channels := make([]string, 0)
+	for _, ch := range c.server.channelList() {
+		for _, member := range c.server.members(ch) {
+			if member == id {
+				channels = append(channels, ch.name)
+				break
+			}
+		}
+	}
+	if len(channels) > 0 {
+		c.reply("319", nick, strings.Join(channels, " "))
+	}
+	c.reply("318", nick, "End of /WHOIS list")
+}
+
+func (c *conn) handleMode(message *Message) {
+	target := message.Param(0)
+	if strings.HasPrefix(target, "#") {
+		if _, ok := c.server.channel(target); !ok {
+			c.reply("403", target, "No such channel")
+			return
+		}
+		// modes cannot be changed, queries get the fixed modes
+		if len(message.Params) < 2 {
+			c.reply("324", target, "+nt")
+		} else if strings.TrimLeft(message.Param(1), "+-") == "b" {
+			c.reply("368", target, "End of channel ban list")
+		}
+		return
+	}
+	c.reply("221", "+i")
+}
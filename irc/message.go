@@ -0,0 +1,92 @@
+package irc
+
+import (
+	"strings"
+)
+
+// maxLineLength is the maximum length of an IRC line without the CRLF
+const maxLineLength = 510
+
+// Message is a line of the IRC protocol
+type Message struct {
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// ParseMessage parses a line without its line ending, nil for empty lines
+func ParseMessage(line string) *Message {
+	line = strings.TrimRight(line, "\r\n")
+	// IRCv3 tags are not supported and skipped
+	if strings.HasPrefix(line, "@") {
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			line = strings.TrimLeft(line[i+1:], " ")
+		} else {
+			return nil
+		}
+	}
+
+	message := &Message{}
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return nil
+		}
+		message.Prefix, line = line[1:i], strings.TrimLeft(line[i+1:], " ")
+	}
+
+	for line != "" {
+		if strings.HasPrefix(line, ":") && message.Command != "" {
+			message.Params = append(message.Params, line[1:])
+			break
+		}
+		var word string
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			word, line = line[:i], strings.TrimLeft(line[i+1:], " ")
+		} else {
+			word, line = line, ""
+		}
+		if message.Command == "" {
+			message.Command = strings.ToUpper(word)
+		} else {
+			message.Params = append(message.Params, word)
+		}
+	}
+
+	if message.Command == "" {
+		return nil
+	}
+	return message
+}
+
+// Param returns the i-th parameter, or "" if there are fewer parameters
+func (m *Message) Param(i int) string {
+	if i < 0 || i >= len(m.Params) {
+		return ""
+	}
+	return m.Params[i]
+}
+
+// lineBreaks replaces the characters which cannot appear in a parameter
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ", "\x00", "")
+
+// String formats the message as a line without line ending. The last of several parameters is
+// always sent as trailing parameter, some clients expect message text to be one. Line breaks in
+// parameters become spaces.
+func (m *Message) String() string {
+	var line strings.Builder
+	if m.Prefix != "" {
+		line.WriteString(":" + m.Prefix + " ")
+	}
+	line.WriteString(m.Command)
+	for i, param := range m.Params {
+		// remote text must not end the line early or inject further commands
+		param = lineBreaks.Replace(param)
+		line.WriteByte(' ')
+		if i == len(m.Params)-1 && (i > 0 || param == "" || strings.ContainsAny(param, " :")) {
+			line.WriteByte(':')
+		}
+		line.WriteString(param)
+	}
+	return line.String()
+}
@@ -0,0 +1,124 @@
+package irc
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+const (
+	maxNickLength    = 30
+	maxChannelLength = 50
+)
+
+// nickName turns a display name into a nick, e.g. "Jane Doe" into "Jane_Doe"
+func nickName(name, gaiaID string) string {
+	var nick strings.Builder
+	for _, r := range name {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-[]\\`^{}|_", r)):
+			nick.WriteRune(r)
+		case r == ' ' || r == '.':
+			nick.WriteByte('_')
+		}
+	}
+	result := strings.Trim(nick.String(), "_")
+	if result == "" {
+		result = "user" + lastDigits(gaiaID, 6)
+	}
+	// nicks must not start with a digit or a dash
+	if c := result[0]; (c >= '0' && c <= '9') || c == '-' {
+		result = "_" + result
+	}
+	if len(result) > maxNickLength {
+		result = result[:maxNickLength]
+	}
+	return result
+}
+
+// validNick checks if a nick chosen by a client is valid
+func validNick(nick string) bool {
+	if nick == "" || len(nick) > maxNickLength || (nick[0] >= '0' && nick[0] <= '9') || nick[0] == '-' {
+		return false
+	}
+	for _, r := range nick {
+		if r >= unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-[]\\`^{}|_", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// channelName turns a conversation into a channel name, e.g. "Ops Team" into "#ops-team"
+func channelName(conversation *hangouts.Conversation, selfID string) string {
+	name := conversation.GetName()
+	if name == "" {
+		names := make([]string, 0)
+		for _, participant := range conversation.GetParticipantData() {
+			if participant.GetId().GetGaiaId() != selfID && participant.GetFallbackName() != "" {
+				names = append(names, participant.GetFallbackName())
+			}
+		}
+		name = strings.Join(names, "-")
+	}
+
+	var channel strings.Builder
+	channel.WriteByte('#')
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' {
+			channel.WriteRune(r)
+			dash = false
+		} else if !dash && channel.Len() > 1 {
+			channel.WriteByte('-')
+			dash = true
+		}
+	}
+	result := strings.TrimRight(channel.String(), "-")
+	if result == "#" {
+		result = "#conversation-" + lastDigits(conversation.GetConversationId().GetId(), 6)
+	}
+	if len(result) > maxChannelLength {
+		result = strings.TrimRight(truncateUTF8(result, maxChannelLength), "-")
+	}
+	return result
+}
+
+// unique appends a number to name until taken returns false
+func unique(name string, taken func(string) bool) string {
+	if !taken(name) {
+		return name
+	}
+	for i := 2; ; i++ {
+		candidate := name + strconv.Itoa(i)
+		if !taken(candidate) {
+			return candidate
+		}
+	}
+}
+
+// lastDigits returns the last n characters of an id
+func lastDigits(id string, n int) string {
+	if len(id) > n {
+		return id[len(id)-n:]
+	}
+	return id
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
+
+// fold returns the case-insensitive form of a nick or channel name
+func fold(name string) string {
+	return strings.ToLower(name)
+}
@@ -0,0 +1,408 @@
+// Package irc is a local IRC server giving IRC clients access to a hangouts account.
+// Every conversation is a channel and its participants are nicks. Messages and "/me" actions sent
+// to a channel are sent to the conversation, TOPIC renames it and PART leaves it. New events of the
+// conversations are relayed as channel messages, topic changes, joins and parts.
+//
+// All connections share the account of the Backend, only local clients should be let in.
+package irc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+const (
+	defaultServerName = "hangups"
+	// userHost is the host part of nick!user@host prefixes
+	userHost = "hangouts"
+	// maxTextLength leaves room for the prefix and the target of a PRIVMSG line
+	maxTextLength = 400
+)
+
+// Server is an IRC server for the account of a Backend
+type Server struct {
+	Backend  Backend
+	Name     string // server name, "hangups" if not set
+	Password string // required as PASS if set
+	// OnError is called for backend errors which cannot be reported to a client, optional
+	OnError func(err error)
+
+	mu             sync.Mutex
+	created        time.Time
+	self           *hangouts.Entity
+	channels       map[string]*channel // by folded name
+	byConversation map[string]*channel // by conversation id
+	nicks          map[string]string   // gaia id -> nick
+	users          map[string]string   // folded nick -> gaia id
+	conns          map[*conn]struct{}
+}
+
+// channel is a conversation as IRC channel
+type channel struct {
+	name         string
+	conversation *hangouts.Conversation
+}
+
+// topic returns the topic of the channel
+func (c *channel) topic() string {
+	return c.conversation.GetName()
+}
+
+// NewServer creates a server for backend
+func NewServer(backend Backend) *Server {
+	return &Server{Backend: backend}
+}
+
+// ListenAndServe listens on addr, e.g. "localhost:6667", and serves until ctx is done
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve loads the conversations, accepts connections on listener and relays events until ctx is done
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	defer listener.Close()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go func() {
+		err := s.Backend.Run(ctx, s.handleEvent)
+		if err != nil && ctx.Err() == nil {
+			s.error(err)
+			cancel()
+		}
+	}()
+
+	for {
+		netConn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				s.closeAll()
+				return ctx.Err()
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		c := newConn(s, netConn)
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// load creates the channels of the recent conversations
+func (s *Server) load() error {
+	self, err := s.Backend.Self()
+	if err != nil {
+		return err
+	}
+	conversations, err := s.Backend.Conversations()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.created = time.Now()
+	s.self = self
+	s.channels = make(map[string]*channel)
+	s.byConversation = make(map[string]*channel)
+	s.nicks = make(map[string]string)
+	s.users = make(map[string]string)
+	s.conns = make(map[*conn]struct{})
+
+	// the most recent conversations get the names without number suffix
+	sort.SliceStable(conversations, func(i, j int) bool {
+		return conversations[i].GetSelfConversationState().GetSortTimestamp() >
+			conversations[j].GetSelfConversationState().GetSortTimestamp()
+	})
+	for _, conversation := range conversations {
+		s.addChannel(conversation)
+	}
+	return nil
+}
+
+// serverName returns the name of the server
+func (s *Server) serverName() string {
+	if s.Name == "" {
+		return defaultServerName
+	}
+	return s.Name
+}
+
+// selfID returns the gaia id of the account
+func (s *Server) selfID() string {
+	return s.self.GetId().GetGaiaId()
+}
+
+// addChannel creates the channel of a conversation, s.mu must be held
+func (s *Server) addChannel(conversation *hangouts.Conversation) *channel {
+	name := unique(channelName(conversation, s.selfID()), func(name string) bool {
+		_, taken := s.channels[fold(name)]
+		return taken
+	})
+	ch := &channel{name: name, conversation: conversation}
+	s.channels[fold(name)] = ch
+	s.byConversation[conversation.GetConversationId().GetId()] = ch
+	for _, participant := range conversation.GetParticipantData() {
+		s.nickLocked(participant.GetId().GetGaiaId(), participant.GetFallbackName())
+	}
+	return ch
+}
+
+// nickLocked returns the nick of a user, assigning one if needed, s.mu must be held
+func (s *Server) nickLocked(gaiaID, name string) string {
+	if nick, ok := s.nicks[gaiaID]; ok {
+		return nick
+	}
+	if gaiaID == s.selfID() {
+		name = s.self.GetProperties().GetDisplayName()
+	}
+	nick := unique(nickName(name, gaiaID), func(nick string) bool {
+		_, taken := s.users[fold(nick)]
+		return taken
+	})
+	s.nicks[gaiaID] = nick
+	s.users[fold(nick)] = gaiaID
+	return nick
+}
+
+// nickOf returns the nick of a user for a connection, the account is the nick of the connection
+func (s *Server) nickOf(c *conn, gaiaID string) string {
+	if gaiaID == s.selfID() {
+		return c.nickName()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nickLocked(gaiaID, "")
+}
+
+// userOf returns the gaia id of a nick, the account for the nick of the connection
+func (s *Server) userOf(c *conn, nick string) (string, bool) {
+	if fold(nick) == fold(c.nickName()) {
+		return s.selfID(), true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gaiaID, ok := s.users[fold(nick)]
+	return gaiaID, ok
+}
+
+// nickTaken checks if a nick belongs to a hangouts user other than the account
+func (s *Server) nickTaken(nick string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gaiaID, ok := s.users[fold(nick)]
+	return ok && gaiaID != s.selfID()
+}
+
+// channel returns a channel by name
+func (s *Server) channel(name string) (*channel, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.channels[fold(name)]
+	return ch, ok
+}
+
+// channelList returns the channels sorted by name
+func (s *Server) channelList() []*channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channels := make([]*channel, 0, len(s.channels))
+	for _, ch := range s.channels {
+		channels = append(channels, ch)
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].name < channels[j].name
+	})
+	return channels
+}
+
+// members returns the gaia ids of the participants of a channel
+func (s *Server) members(ch *channel) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, participant := range ch.conversation.GetParticipantData() {
+		id := participant.GetId().GetGaiaId()
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// directChannel returns the one to one conversation with a user
+func (s *Server) directChannel(gaiaID string) (*channel, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ch := range s.channels {
+		if ch.conversation.GetType() != hangouts.ConversationType_CONVERSATION_TYPE_ONE_TO_ONE {
+			continue
+		}
+		for _, participant := range ch.conversation.GetParticipantData() {
+			if participant.GetId().GetGaiaId() == gaiaID {
+				return ch, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// removeChannel forgets the channel of a conversation
+func (s *Server) removeChannel(ch *channel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.channels, fold(ch.name))
+	delete(s.byConversation, ch.conversation.GetConversationId().GetId())
+}
+
+// registered returns the connections which completed registration
+func (s *Server) registered() []*conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		if c.isRegistered() {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+// closeAll closes every connection
+func (s *Server) closeAll() {
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.close("server shutting down")
+	}
+}
+
+// removeConn forgets a closed connection
+func (s *Server) removeConn(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+}
+
+// handleEvent relays a new event to every connection
+func (s *Server) handleEvent(conversation *hangouts.Conversation, event *hangouts.Event) {
+	conversationID := event.GetConversationId().GetId()
+
+	s.mu.Lock()
+	ch, known := s.byConversation[conversationID]
+	if known && len(conversation.GetParticipantData()) > 0 {
+		ch.conversation = conversation
+	}
+	s.mu.Unlock()
+
+	if !known {
+		// a new conversation, the event may come with a partial conversation only
+		if len(conversation.GetParticipantData()) == 0 {
+			full, err := s.Backend.Conversation(conversationID)
+			if err != nil {
+				s.error(err)
+				return
+			}
+			conversation = full
+		}
+		s.mu.Lock()
+		ch = s.addChannel(conversation)
+		s.mu.Unlock()
+		for _, c := range s.registered() {
+			c.join(ch)
+		}
+		if event.GetMembershipChange() != nil {
+			return
+		}
+	}
+
+	senderID := event.GetSenderId().GetGaiaId()
+	for _, c := range s.registered() {
+		sender := s.nickOf(c, senderID) + "!" + senderID + "@" + userHost
+		switch {
+		case event.GetChatMessage() != nil:
+			message := hangups.NewMessage(event)
+			text := message.Text()
+			for _, attachment := range message.Attachments() {
+				if url := firstNonEmpty(attachment.URL, attachment.ContentURL, attachment.ImageURL, attachment.MapURL); url != "" {
+					text = strings.TrimSpace(text + "\n" + url)
+				}
+			}
+			c.privmsg(sender, ch.name, text, message.IsAction())
+		case event.GetConversationRename() != nil:
+			c.send(&Message{Prefix: sender, Command: "TOPIC", Params: []string{ch.name, event.GetConversationRename().GetNewName()}})
+		case event.GetMembershipChange() != nil:
+			s.membershipChange(c, ch, event.GetMembershipChange())
+		}
+	}
+
+	if change := event.GetMembershipChange(); change != nil &&
+		change.GetType() == hangouts.MembershipChangeType_MEMBERSHIP_CHANGE_TYPE_LEAVE {
+		for _, id := range change.GetParticipantIds() {
+			if id.GetGaiaId() == s.selfID() {
+				s.removeChannel(ch)
+			}
+		}
+	}
+}
+
+// membershipChange relays users joining or leaving a conversation
+func (s *Server) membershipChange(c *conn, ch *channel, change *hangouts.MembershipChange) {
+	for _, id := range change.GetParticipantIds() {
+		nick := s.nickOf(c, id.GetGaiaId())
+		prefix := nick + "!" + id.GetGaiaId() + "@" + userHost
+		if change.GetType() == hangouts.MembershipChangeType_MEMBERSHIP_CHANGE_TYPE_LEAVE {
+			c.send(&Message{Prefix: prefix, Command: "PART", Params: []string{ch.name, "left the conversation"}})
+		} else {
+			c.send(&Message{Prefix: prefix, Command: "JOIN", Params: []string{ch.name}})
+		}
+	}
+}
+
+// error reports an error to OnError
+func (s *Server) error(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+// firstNonEmpty returns the first non empty string
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
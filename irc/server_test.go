@@ -0,0 +1,349 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+const (
+	selfID        = "1001"
+	otherID       = "1002"
+	teamID        = "UgwTeam"
+	directID      = "UgwDirect"
+	testTimeout   = 5 * time.Second
+	teamChannel   = "#ops-team"
+	directChannel = "#john-roe"
+)
+
+// fakeBackend is a Backend with fixed conversations recording the requests of the clients
+type fakeBackend struct {
+	events chan *hangouts.Event
+
+	mu      sync.Mutex
+	sent    []string // "conversation id|text|action"
+	renamed []string // "conversation id|name"
+	left    []string
+}
+
+// newFakeBackend creates a backend with a group and a one to one conversation
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{events: make(chan *hangouts.Event)}
+}
+
+// participant creates the participant data of a user
+func participant(gaiaID, name string) *hangouts.ConversationParticipantData {
+	return &hangouts.ConversationParticipantData{
+		Id:           &hangouts.ParticipantId{GaiaId: proto.String(gaiaID), ChatId: proto.String(gaiaID)},
+		FallbackName: proto.String(name),
+	}
+}
+
+func (b *fakeBackend) Self() (*hangouts.Entity, error) {
+	return &hangouts.Entity{
+		Id:         &hangouts.ParticipantId{GaiaId: proto.String(selfID)},
+		Properties: &hangouts.EntityProperties{DisplayName: proto.String("Jane Doe")},
+	}, nil
+}
+
+func (b *fakeBackend) Conversations() ([]*hangouts.Conversation, error) {
+	team, _ := b.Conversation(teamID)
+	direct, _ := b.Conversation(directID)
+	return []*hangouts.Conversation{team, direct}, nil
+}
+
+func (b *fakeBackend) Conversation(conversationID string) (*hangouts.Conversation, error) {
+	conversation := &hangouts.Conversation{
+		ConversationId:  &hangouts.ConversationId{Id: proto.String(conversationID)},
+		Type:            hangouts.ConversationType_CONVERSATION_TYPE_ONE_TO_ONE.Enum(),
+		ParticipantData: []*hangouts.ConversationParticipantData{participant(selfID, "Jane Doe"), participant(otherID, "John Roe")},
+	}
+	if conversationID == teamID {
+		// remote text with a line break must not inject commands
+		conversation.Name = proto.String("Ops\r\nTeam")
+		conversation.Type = hangouts.ConversationType_CONVERSATION_TYPE_GROUP.Enum()
+	}
+	return conversation, nil
+}
+
+func (b *fakeBackend) Send(conversationID, text string, action bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	flag := ""
+	if action {
+		flag = "action"
+	}
+	b.sent = append(b.sent, conversationID+"|"+text+"|"+flag)
+	return nil
+}
+
+func (b *fakeBackend) Rename(conversationID, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.renamed = append(b.renamed, conversationID+"|"+name)
+	return nil
+}
+
+func (b *fakeBackend) Leave(conversationID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.left = append(b.left, conversationID)
+	return nil
+}
+
+func (b *fakeBackend) Run(ctx context.Context, handler hangups.EventHandler) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event := <-b.events:
+			conversation, _ := b.Conversation(event.GetConversationId().GetId())
+			handler(conversation, event)
+		}
+	}
+}
+
+// requests returns a copy of a recorded list
+func (b *fakeBackend) requests(list *[]string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string{}, *list...)
+}
+
+// testClient is an IRC client connected to a test server
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// startServer serves backend on a local port until the test ends and connects a client
+func startServer(t *testing.T, backend Backend, password string) *testClient {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(backend)
+	server.Password = password
+	server.OnError = func(err error) {
+		t.Errorf("server error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		server.Serve(ctx, listener)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// send writes lines to the server
+func (c *testClient) send(lines ...string) {
+	c.t.Helper()
+	for _, line := range lines {
+		if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+// expect reads lines until one contains want and returns the lines read
+func (c *testClient) expect(want string) []string {
+	c.t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(testTimeout))
+	lines := make([]string, 0)
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("no line containing %q, got %q: %v", want, lines, err)
+		}
+		if !strings.HasSuffix(line, "\r\n") {
+			c.t.Fatalf("line %q does not end with CRLF", line)
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		lines = append(lines, line)
+		if strings.Contains(line, want) {
+			return lines
+		}
+	}
+}
+
+// register registers as jane and reads the channels joined on registration
+func (c *testClient) register() []string {
+	c.t.Helper()
+	c.send("NICK jane", "USER jane 0 * :Jane Doe")
+	lines := c.expect(" 001 jane ")
+	lines = append(lines, c.expect(" 366 jane "+directChannel+" ")...)
+	return append(lines, c.expect(" 366 jane "+teamChannel+" ")...)
+}
+
+// waitRequests waits until a recorded list of the backend has n entries
+func waitRequests(t *testing.T, backend *fakeBackend, list *[]string, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		requests := backend.requests(list)
+		if len(requests) >= n {
+			return requests
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d backend requests, want %d: %q", len(requests), n, requests)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	client := startServer(t, newFakeBackend(), "")
+
+	client.send("PRIVMSG " + teamChannel + " :too early")
+	client.expect(" 451 ")
+
+	lines := client.register()
+	joined := strings.Join(lines, "\n")
+	for _, want := range []string{
+		":jane!" + selfID + "@hangouts JOIN " + teamChannel,
+		":jane!" + selfID + "@hangouts JOIN " + directChannel,
+		" 332 jane " + teamChannel + " :Ops Team",
+		" 353 jane = " + teamChannel + " :jane John_Roe",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("registration output has no %q:\n%s", want, joined)
+		}
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "Team") {
+			t.Errorf("the topic was split into a line of its own: %q", line)
+		}
+	}
+
+	client.send("LIST")
+	if lines = client.expect(" 323 "); !strings.Contains(strings.Join(lines, "\n"), " 322 jane "+teamChannel+" 2 :Ops Team") {
+		t.Errorf("LIST output = %q", lines)
+	}
+}
+
+func TestRegistrationPassword(t *testing.T) {
+	tests := []struct {
+		name string
+		pass string
+		want string
+	}{
+		{name: "correct password", pass: "secret", want: " 001 jane "},
+		{name: "wrong password", pass: "guess", want: " 464 jane "},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := startServer(t, newFakeBackend(), "secret")
+			client.send("PASS "+test.pass, "NICK jane", "USER jane 0 * :Jane Doe")
+			client.expect(test.want)
+		})
+	}
+}
+
+func TestPrivmsg(t *testing.T) {
+	backend := newFakeBackend()
+	client := startServer(t, backend, "")
+	client.register()
+
+	client.send(
+		"PRIVMSG "+teamChannel+" :hello team",
+		"PRIVMSG "+teamChannel+" :\x01ACTION waves\x01",
+		"PRIVMSG John_Roe :hello john",
+		"PRIVMSG "+teamChannel+" :\x01VERSION\x01",
+	)
+	want := []string{teamID + "|hello team|", teamID + "|waves|action", directID + "|hello john|"}
+	got := waitRequests(t, backend, &backend.sent, len(want))
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+
+	client.send("PRIVMSG #nowhere :hello")
+	client.expect(" 403 jane #nowhere ")
+}
+
+func TestIncomingEvents(t *testing.T) {
+	backend := newFakeBackend()
+	client := startServer(t, backend, "")
+	client.register()
+
+	message := func(text string) *hangouts.Event {
+		return &hangouts.Event{
+			ConversationId: &hangouts.ConversationId{Id: proto.String(teamID)},
+			SenderId:       &hangouts.ParticipantId{GaiaId: proto.String(otherID)},
+			EventId:        proto.String("event-" + text),
+			ChatMessage: &hangouts.ChatMessage{
+				MessageContent: &hangouts.MessageContent{
+					Segment: []*hangouts.Segment{{Type: hangouts.SegmentType_SEGMENT_TYPE_TEXT.Enum(), Text: proto.String(text)}},
+				},
+			},
+		}
+	}
+
+	sender := ":John_Roe!" + otherID + "@hangouts "
+	backend.events <- message("first\r\nQUIT :injected")
+	lines := client.expect(sender + "PRIVMSG " + teamChannel + " :QUIT :injected")
+	if len(lines) != 2 || lines[0] != sender+"PRIVMSG "+teamChannel+" :first" {
+		t.Errorf("multi-line message relayed as %q", lines)
+	}
+
+	backend.events <- &hangouts.Event{
+		ConversationId:     &hangouts.ConversationId{Id: proto.String(teamID)},
+		SenderId:           &hangouts.ParticipantId{GaiaId: proto.String(otherID)},
+		EventId:            proto.String("event-rename"),
+		ConversationRename: &hangouts.ConversationRename{NewName: proto.String("Ops\nOnly")},
+	}
+	client.expect(sender + "TOPIC " + teamChannel + " :Ops Only")
+}
+
+func TestTopic(t *testing.T) {
+	backend := newFakeBackend()
+	client := startServer(t, backend, "")
+	client.register()
+
+	client.send("TOPIC " + teamChannel)
+	client.expect(" 332 jane " + teamChannel + " :Ops Team")
+
+	client.send("TOPIC " + teamChannel + " :Ops and Dev")
+	renamed := waitRequests(t, backend, &backend.renamed, 1)
+	if renamed[0] != teamID+"|Ops and Dev" {
+		t.Errorf("renamed %q", renamed)
+	}
+}
+
+func TestPart(t *testing.T) {
+	backend := newFakeBackend()
+	client := startServer(t, backend, "")
+	client.register()
+
+	client.send("PART " + teamChannel + " :bye")
+	client.expect(":jane!" + selfID + "@hangouts PART " + teamChannel + " :bye")
+	if left := backend.requests(&backend.left); len(left) != 1 || left[0] != teamID {
+		t.Errorf("left %q, want %s", left, teamID)
+	}
+
+	// the channel is gone
+	client.send("PRIVMSG " + teamChannel + " :hello")
+	client.expect(" 403 jane " + teamChannel + " ")
+}
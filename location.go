@@ -0,0 +1,63 @@
+package hangups
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// getLocation creates a Location embed for a point with an optional name and address
+func getLocation(latitude, longitude float64, name, address string) *hangouts.Location {
+	mapURL := fmt.Sprintf("https://maps.google.com/maps?q=%f,%f", latitude, longitude)
+	if name == "" {
+		name = fmt.Sprintf("%f, %f", latitude, longitude)
+	}
+
+	place := &hangouts.EMPlace{
+		Name:   proto.String(name),
+		URL:    proto.String(mapURL),
+		MapURL: proto.String(mapURL),
+		Geo: &hangouts.EMGeoCoordinates{
+			Latitude:  proto.Float64(latitude),
+			Longitude: proto.Float64(longitude),
+		},
+	}
+	if address != "" {
+		place.Address = &hangouts.EMPostalAddress{
+			Name:          proto.String(address),
+			StreetAddress: proto.String(address),
+		}
+	}
+	return &hangouts.Location{Place: place}
+}
+
+// SendLocation sends a location to a user/group
+// to can be phoneNumber, email chatID/GaiaID or conversation ID
+// name and address are optional
+func (c *Client) SendLocation(to string, latitude, longitude float64, name, address string) error {
+	if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+		return errors.New("invalid coordinates")
+	}
+
+	conv, err := c.Create1On1Conversation(to)
+	if err != nil {
+		return fmt.Errorf("cannot determine one on one conversation for %s : %w", to, err)
+	}
+
+	request := c.newChatMessageRequest(conv)
+	request.Location = getLocation(latitude, longitude, name, address)
+	_, err = c.sendTo(to, request)
+	return err
+}
+
+// EventLocation returns the location shared in a chat message event, or nil if there is none
+func EventLocation(event *hangouts.Event) *Attachment {
+	for _, attachment := range EventAttachments(event) {
+		if attachment.Type == AttachmentLocation {
+			return attachment
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package hangups
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// Level is the severity of a log record, the values are the ones of log/slog
+type Level int
+
+// Log levels
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+// String returns the name of the level as log/slog does
+func (l Level) String() string {
+	name, base := "DEBUG", LevelDebug
+	switch {
+	case l >= LevelError:
+		name, base = "ERROR", LevelError
+	case l >= LevelWarn:
+		name, base = "WARN", LevelWarn
+	case l >= LevelInfo:
+		name, base = "INFO", LevelInfo
+	}
+	if l == base {
+		return name
+	}
+	return fmt.Sprintf("%s%+d", name, l-base)
+}
+
+// Logger receives the log records of Client and Session. keyvals are alternating keys and values
+// as for slog.Logger.Log, a *slog.Logger is used with
+//
+//	hangups.LoggerFunc(func(level hangups.Level, msg string, keyvals ...interface{}) {
+//		logger.Log(context.Background(), slog.Level(level), msg, keyvals...)
+//	})
+type Logger interface {
+	Log(level Level, msg string, keyvals ...interface{})
+}
+
+// LoggerFunc is a function used as Logger
+type LoggerFunc func(level Level, msg string, keyvals ...interface{})
+
+// Log implements Logger
+func (f LoggerFunc) Log(level Level, msg string, keyvals ...interface{}) {
+	f(level, msg, keyvals...)
+}
+
+// NewStdLogger returns a Logger writing the records of at least minLevel to logger,
+// formatted as "LEVEL msg key=value ...". The standard logger is used if logger is nil.
+func NewStdLogger(logger *log.Logger, minLevel Level) Logger {
+	if logger == nil {
+		logger = log.New(log.Writer(), log.Prefix(), log.Flags())
+	}
+	return LoggerFunc(func(level Level, msg string, keyvals ...interface{}) {
+		if level < minLevel {
+			return
+		}
+		var line strings.Builder
+		line.WriteString(level.String() + " " + msg)
+		for i := 0; i < len(keyvals); i += 2 {
+			key, value := fmt.Sprint(keyvals[i]), interface{}("!MISSING")
+			if i+1 < len(keyvals) {
+				value = keyvals[i+1]
+			}
+			text := fmt.Sprint(value)
+			if text == "" || strings.ContainsAny(text, " \"=\n") {
+				text = fmt.Sprintf("%q", text)
+			}
+			line.WriteString(" " + key + "=" + text)
+		}
+		logger.Print(line.String())
+	})
+}
+
+// logTo writes a record to logger if it is set
+func logTo(logger Logger, level Level, msg string, keyvals ...interface{}) {
+	if logger != nil {
+		logger.Log(level, msg, keyvals...)
+	}
+}
+
+// RequestTrace describes an API request, see Tracer
+type RequestTrace struct {
+	Endpoint   string // e.g. "conversations/sendchatmessage", the url for requests outside of the chat API
+	Start      time.Time
+	Duration   time.Duration // set when the request has ended
+	HTTPStatus int           // 0 if no response was received
+	// Status, TraceID, DebugURL and ErrorDescription are copied from the response header of protobuf
+	// requests, TraceID and DebugURL identify the request when reporting failures to Google
+	Status           hangouts.ResponseStatus
+	TraceID          string
+	DebugURL         string
+	ErrorDescription string
+	Err              error // transport or decoding error, responses with an error status are not one
+}
+
+// Tracer is called at the start and the end of every API request of a Client, both hooks are optional.
+// The trace passed to RequestEnd is the one passed to RequestStart.
+type Tracer struct {
+	RequestStart func(trace *RequestTrace)
+	RequestEnd   func(trace *RequestTrace)
+}
+
+// startTrace creates the trace of a request and reports its start
+func (c *Client) startTrace(endpoint string) *RequestTrace {
+	trace := &RequestTrace{Endpoint: endpoint, Start: time.Now()}
+	if c.Tracer != nil && c.Tracer.RequestStart != nil {
+		c.Tracer.RequestStart(trace)
+	}
+	logTo(c.Logger, LevelDebug, "request started", "endpoint", endpoint)
+	return trace
+}
+
+// endTrace completes a trace with the response header, which may be nil, and reports the end of the request
+func (c *Client) endTrace(trace *RequestTrace, header *hangouts.ResponseHeader, err error) {
+	trace.Duration = time.Since(trace.Start)
+	trace.Err = err
+	if header != nil {
+		trace.Status = header.GetStatus()
+		trace.TraceID = header.GetRequestTraceId()
+		trace.DebugURL = header.GetDebugUrl()
+		trace.ErrorDescription = header.GetErrorDescription()
+	}
+	if c.Tracer != nil && c.Tracer.RequestEnd != nil {
+		c.Tracer.RequestEnd(trace)
+	}
+	if c.Stats != nil {
+		c.Stats.RequestDone(trace)
+	}
+
+	if c.Logger == nil {
+		return
+	}
+	keyvals := []interface{}{"endpoint", trace.Endpoint, "duration", trace.Duration, "http_status", trace.HTTPStatus}
+	if header != nil {
+		keyvals = append(keyvals, "status", trace.Status, "request_trace_id", trace.TraceID)
+	}
+	switch {
+	case err != nil:
+		c.Logger.Log(LevelError, "request failed", append(keyvals, "error", err)...)
+	case header != nil && trace.Status != hangouts.ResponseStatus_RESPONSE_STATUS_OK:
+		keyvals = append(keyvals, "error_description", trace.ErrorDescription, "debug_url", trace.DebugURL)
+		c.Logger.Log(LevelWarn, "request rejected", keyvals...)
+	default:
+		c.Logger.Log(LevelDebug, "request finished", keyvals...)
+	}
+}
@@ -0,0 +1,142 @@
+package hangups
+
+import (
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// actionAnnotationType is the EventAnnotation type of "/me" actions
+const actionAnnotationType int32 = 4
+
+// messageOptions collects the MessageOption of a send
+type messageOptions struct {
+	action            bool
+	deliveryMedium    hangouts.DeliveryMediumType
+	phoneNumber       string
+	clientGeneratedID uint64
+	photoID           string
+}
+
+// MessageOption modifies an outgoing chat message
+type MessageOption func(*messageOptions)
+
+// WithAction sends the message as a "/me" action
+func WithAction() MessageOption {
+	return func(o *messageOptions) {
+		o.action = true
+	}
+}
+
+// WithDeliveryMedium sends the message through a specific medium, e.g. DELIVERY_MEDIUM_GOOGLE_VOICE for SMS
+func WithDeliveryMedium(mediumType hangouts.DeliveryMediumType) MessageOption {
+	return func(o *messageOptions) {
+		o.deliveryMedium = mediumType
+	}
+}
+
+// WithPhoneNumber sends a Google Voice message from one of the account's numbers, in E.164 format
+func WithPhoneNumber(e164 string) MessageOption {
+	return func(o *messageOptions) {
+		o.deliveryMedium = hangouts.DeliveryMediumType_DELIVERY_MEDIUM_GOOGLE_VOICE
+		o.phoneNumber = e164
+	}
+}
+
+// WithClientGeneratedID sends the message with a known client generated id instead of a random one,
+// retries of the same message should reuse the id so that they are not duplicated
+func WithClientGeneratedID(id uint64) MessageOption {
+	return func(o *messageOptions) {
+		o.clientGeneratedID = id
+	}
+}
+
+// WithPhoto attaches a photo uploaded with UploadImage or a photo id from a hangouts message
+func WithPhoto(photoID string) MessageOption {
+	return func(o *messageOptions) {
+		o.photoID = photoID
+	}
+}
+
+// applyMessageOptions applies the options to a chat message request for a conversation
+func applyMessageOptions(request *hangouts.SendChatMessageRequest, conversation *hangouts.Conversation, options []MessageOption) {
+	o := &messageOptions{}
+	for _, option := range options {
+		option(o)
+	}
+
+	if o.action {
+		request.Annotation = append(request.Annotation, &hangouts.EventAnnotation{
+			Type: proto.Int32(actionAnnotationType),
+		})
+	}
+
+	if o.deliveryMedium != hangouts.DeliveryMediumType_DELIVERY_MEDIUM_UNKNOWN {
+		request.EventRequestHeader.DeliveryMedium = findDeliveryMedium(conversation, o.deliveryMedium, o.phoneNumber)
+	}
+
+	if o.clientGeneratedID != 0 {
+		request.EventRequestHeader.ClientGeneratedId = proto.Uint64(o.clientGeneratedID)
+	}
+
+	if o.photoID != "" {
+		request.ExistingMedia = getExistingMedia(o.photoID)
+	}
+}
+
+// Message wraps a received chat message event
+type Message struct {
+	*hangouts.Event
+}
+
+// NewMessage wraps an event, it returns nil if the event is not a chat message
+func NewMessage(event *hangouts.Event) *Message {
+	if event.GetChatMessage() == nil {
+		return nil
+	}
+	return &Message{Event: event}
+}
+
+// ConversationID returns the id of the conversation the message was sent to
+func (m *Message) ConversationID() string {
+	return m.GetConversationId().GetId()
+}
+
+// SenderID returns the gaia id of the sender
+func (m *Message) SenderID() string {
+	return m.GetSenderId().GetGaiaId()
+}
+
+// IsAction returns true for "/me" action messages
+func (m *Message) IsAction() bool {
+	for _, annotation := range m.GetChatMessage().GetAnnotation() {
+		if annotation.GetType() == actionAnnotationType {
+			return true
+		}
+	}
+	return false
+}
+
+// Segments returns the segments of the message
+func (m *Message) Segments() []*hangouts.Segment {
+	return m.GetChatMessage().GetMessageContent().GetSegment()
+}
+
+// Text reassembles the plain text of the message from its segments
+func (m *Message) Text() string {
+	var text strings.Builder
+	for _, segment := range m.Segments() {
+		if segment.GetType() == hangouts.SegmentType_SEGMENT_TYPE_LINE_BREAK {
+			text.WriteString("\n")
+			continue
+		}
+		text.WriteString(segment.GetText())
+	}
+	return text.String()
+}
+
+// Attachments returns the normalised attachments of the message
+func (m *Message) Attachments() []*Attachment {
+	return EventAttachments(m.Event)
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Publish exposes the snapshots of the metrics as the expvar variable name.
+// Like expvar.Publish it panics if the name is already used.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return m.Snapshot()
+	}))
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	_ = m.WriteText(w)
+}
+
+// WriteText writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WriteText(w io.Writer) error {
+	snapshot := m.Snapshot()
+	out := bufio.NewWriter(w)
+
+	writeHeader(out, "hangups_rpc_duration_seconds", "histogram", "Duration of the API requests.")
+	for _, endpoint := range sortedKeys(snapshot.Requests) {
+		writeHistogram(out, "hangups_rpc_duration_seconds", `endpoint="`+escapeLabel(endpoint)+`"`, snapshot.Requests[endpoint])
+	}
+
+	writeHeader(out, "hangups_rpc_errors_total", "counter", "Failed API requests.")
+	endpoints := make([]string, 0, len(snapshot.Errors))
+	for endpoint := range snapshot.Errors {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		statuses := make([]string, 0, len(snapshot.Errors[endpoint]))
+		for status := range snapshot.Errors[endpoint] {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(out, "hangups_rpc_errors_total{endpoint=\"%s\",status=\"%s\"} %d\n",
+				escapeLabel(endpoint), escapeLabel(status), snapshot.Errors[endpoint][status])
+		}
+	}
+
+	writeHeader(out, "hangups_syncs_total", "counter", "Polls for new events.")
+	fmt.Fprintf(out, "hangups_syncs_total %d\n", snapshot.Syncs)
+	writeHeader(out, "hangups_sync_errors_total", "counter", "Failed polls for new events.")
+	fmt.Fprintf(out, "hangups_sync_errors_total %d\n", snapshot.SyncErrors)
+	writeHeader(out, "hangups_last_sync_timestamp_seconds", "gauge", "Unix time of the last successful poll, 0 if none.")
+	lastSync := 0.0
+	if !snapshot.LastSync.IsZero() {
+		lastSync = float64(snapshot.LastSync.UnixNano()) / 1e9
+	}
+	fmt.Fprintf(out, "hangups_last_sync_timestamp_seconds %s\n", formatFloat(lastSync))
+
+	writeHeader(out, "hangups_events_received_total", "counter", "Events delivered by pollers.")
+	fmt.Fprintf(out, "hangups_events_received_total %d\n", snapshot.EventsReceived)
+	writeHeader(out, "hangups_event_lag_seconds", "histogram", "Time between sending and receiving events.")
+	writeHistogram(out, "hangups_event_lag_seconds", "", snapshot.EventLag)
+
+	writeHeader(out, "hangups_upload_bytes_total", "counter", "Bytes of uploaded media.")
+	fmt.Fprintf(out, "hangups_upload_bytes_total %d\n", snapshot.UploadBytes)
+
+	return out.Flush()
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeHistogram writes the bucket, sum and count samples of a histogram, labels may be empty
+func writeHistogram(w io.Writer, name, labels string, h *Histogram) {
+	separator := ""
+	if labels != "" {
+		separator = ","
+	}
+	for i, bound := range h.Buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, separator, formatFloat(bound), h.Counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, separator, h.Count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(h.Sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.Count)
+}
+
+// formatFloat formats a sample value
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// labelEscaper escapes label values as the text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
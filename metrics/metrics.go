@@ -0,0 +1,209 @@
+// Package metrics collects the measurements of a hangups.Client and exposes them through expvar
+// and the Prometheus text format, without dependencies.
+//
+//	m := metrics.New()
+//	client.Stats = m
+//	m.Publish("hangups")          // expvar, served on /debug/vars
+//	http.Handle("/metrics", m)    // Prometheus
+//
+// The Prometheus metrics are
+//
+//	hangups_rpc_duration_seconds         histogram of the API requests by endpoint
+//	hangups_rpc_errors_total             failed API requests by endpoint and status
+//	hangups_syncs_total                  polls for new events
+//	hangups_sync_errors_total            failed polls
+//	hangups_last_sync_timestamp_seconds  unix time of the last successful poll
+//	hangups_events_received_total        events delivered by pollers
+//	hangups_event_lag_seconds            histogram of the time between sending and receiving events
+//	hangups_upload_bytes_total           bytes of uploaded media
+//
+// The status label of errors is the name of the ResponseStatus without its prefix, e.g. INVALID_REQUEST,
+// "http_<code>" for HTTP errors of requests without response header and "error" for transport
+// and decoding errors.
+package metrics
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+var (
+	// LatencyBuckets are the upper bounds in seconds of the request duration histograms
+	LatencyBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+	// LagBuckets are the upper bounds in seconds of the event lag histogram
+	LagBuckets = []float64{1, 2.5, 5, 10, 30, 60, 300, 900}
+)
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	Buckets []float64 `json:"buckets"` // upper bounds
+	Counts  []uint64  `json:"counts"`  // observations less or equal than the bound of the same index
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+}
+
+// newHistogram creates an empty histogram with buckets
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{Buckets: buckets, Counts: make([]uint64, len(buckets))}
+}
+
+// observe adds a value
+func (h *Histogram) observe(v float64) {
+	for i, bound := range h.Buckets {
+		if v <= bound {
+			h.Counts[i]++
+		}
+	}
+	h.Count++
+	h.Sum += v
+}
+
+// copy returns a copy of the histogram
+func (h *Histogram) copy() *Histogram {
+	c := *h
+	c.Counts = append([]uint64{}, h.Counts...)
+	return &c
+}
+
+// errorKey identifies the error counter of an endpoint and a status
+type errorKey struct {
+	endpoint string
+	status   string
+}
+
+// Metrics implements hangups.Stats, it is safe for concurrent use
+type Metrics struct {
+	mu         sync.Mutex
+	requests   map[string]*Histogram
+	errors     map[errorKey]uint64
+	syncs      uint64
+	syncErrors uint64
+	lastSync   time.Time
+	events     uint64
+	lag        *Histogram
+	uploaded   uint64
+}
+
+var _ hangups.Stats = (*Metrics)(nil)
+
+// New creates empty metrics
+func New() *Metrics {
+	return &Metrics{
+		requests: make(map[string]*Histogram),
+		errors:   make(map[errorKey]uint64),
+		lag:      newHistogram(LagBuckets),
+	}
+}
+
+// RequestDone implements hangups.Stats
+func (m *Metrics) RequestDone(trace *hangups.RequestTrace) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	latency, ok := m.requests[trace.Endpoint]
+	if !ok {
+		latency = newHistogram(LatencyBuckets)
+		m.requests[trace.Endpoint] = latency
+	}
+	latency.observe(trace.Duration.Seconds())
+
+	if status := errorStatus(trace); status != "" {
+		m.errors[errorKey{trace.Endpoint, status}]++
+	}
+}
+
+// errorStatus returns the status label of a failed request, "" for successful requests
+func errorStatus(trace *hangups.RequestTrace) string {
+	switch {
+	case trace.Err != nil:
+		return "error"
+	case trace.Status != hangouts.ResponseStatus_RESPONSE_STATUS_UNKNOWN &&
+		trace.Status != hangouts.ResponseStatus_RESPONSE_STATUS_OK:
+		return strings.TrimPrefix(trace.Status.String(), "RESPONSE_STATUS_")
+	case trace.HTTPStatus >= 400:
+		return "http_" + strconv.Itoa(trace.HTTPStatus)
+	}
+	return ""
+}
+
+// SyncDone implements hangups.Stats
+func (m *Metrics) SyncDone(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.syncs++
+	if err != nil {
+		m.syncErrors++
+	} else {
+		m.lastSync = time.Now()
+	}
+}
+
+// EventReceived implements hangups.Stats
+func (m *Metrics) EventReceived(lag time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events++
+	m.lag.observe(lag.Seconds())
+}
+
+// UploadedBytes implements hangups.Stats
+func (m *Metrics) UploadedBytes(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploaded += uint64(n)
+}
+
+// Snapshot is a copy of the metrics at one point in time
+type Snapshot struct {
+	Requests       map[string]*Histogram        `json:"requests"` // request durations in seconds by endpoint
+	Errors         map[string]map[string]uint64 `json:"errors"`   // failed requests by endpoint and status
+	Syncs          uint64                       `json:"syncs"`
+	SyncErrors     uint64                       `json:"sync_errors"`
+	LastSync       time.Time                    `json:"last_sync"` // zero if no poll has succeeded
+	EventsReceived uint64                       `json:"events_received"`
+	EventLag       *Histogram                   `json:"event_lag"` // in seconds
+	UploadBytes    uint64                       `json:"upload_bytes"`
+}
+
+// Snapshot returns a copy of the current metrics
+func (m *Metrics) Snapshot() *Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := &Snapshot{
+		Requests:       make(map[string]*Histogram, len(m.requests)),
+		Errors:         make(map[string]map[string]uint64),
+		Syncs:          m.syncs,
+		SyncErrors:     m.syncErrors,
+		LastSync:       m.lastSync,
+		EventsReceived: m.events,
+		EventLag:       m.lag.copy(),
+		UploadBytes:    m.uploaded,
+	}
+	for endpoint, latency := range m.requests {
+		snapshot.Requests[endpoint] = latency.copy()
+	}
+	for key, count := range m.errors {
+		if snapshot.Errors[key.endpoint] == nil {
+			snapshot.Errors[key.endpoint] = make(map[string]uint64)
+		}
+		snapshot.Errors[key.endpoint][key.status] = count
+	}
+	return snapshot
+}
+
+// sortedKeys returns the keys of a map of endpoints in order
+func sortedKeys(m map[string]*Histogram) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,394 @@
+package hangups
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+const (
+	defaultOutboxRetries    = 5
+	defaultOutboxRetryDelay = time.Second
+	maxOutboxRetryDelay     = time.Minute
+)
+
+// ErrOutboxClosed is returned when enqueuing to a closed Outbox
+var ErrOutboxClosed = errors.New("outbox is closed")
+
+// MessageSender sends chat messages, it is implemented by Client
+type MessageSender interface {
+	Send(to, content string, options ...MessageOption) (*SentMessage, error)
+}
+
+// OutboxMessage is a message waiting in an Outbox
+type OutboxMessage struct {
+	ID                string    `json:"id"`
+	To                string    `json:"to"` // phoneNumber, email chatID/GaiaID or conversation ID
+	Content           string    `json:"content,omitempty"`
+	PhotoID           string    `json:"photo_id,omitempty"`
+	Action            bool      `json:"action,omitempty"`
+	ClientGeneratedID uint64    `json:"client_generated_id"` // reused by retries so the server can drop duplicates
+	Attempts          int       `json:"attempts"`
+	QueuedAt          time.Time `json:"queued_at"`
+}
+
+// options returns the send options of the message
+func (m *OutboxMessage) options() []MessageOption {
+	options := []MessageOption{WithClientGeneratedID(m.ClientGeneratedID)}
+	if m.PhotoID != "" {
+		options = append(options, WithPhoto(m.PhotoID))
+	}
+	if m.Action {
+		options = append(options, WithAction())
+	}
+	return options
+}
+
+// OutboxStore persists the pending messages of an Outbox so they survive restarts
+type OutboxStore interface {
+	Save(message *OutboxMessage) error
+	Delete(id string) error
+	Load() ([]*OutboxMessage, error)
+}
+
+// DeliveryCallback reports the final outcome of a queued message, sent is nil if err is not
+type DeliveryCallback func(message *OutboxMessage, sent *SentMessage, err error)
+
+// outboxEntry is a queued message and its callback
+type outboxEntry struct {
+	message  *OutboxMessage
+	callback DeliveryCallback
+}
+
+// outboxQueue holds the messages of one target, it is drained by a single goroutine
+type outboxQueue struct {
+	entries []*outboxEntry
+}
+
+// Outbox sends messages in order per target and in parallel across targets.
+// Transient failures are retried with exponential backoff, pending messages are kept in Store.
+// The zero value is usable once Sender is set, NewOutbox is a shortcut.
+// Targets are compared literally, a conversation addressed by id and by email are two queues.
+type Outbox struct {
+	Sender     MessageSender
+	Store      OutboxStore      // optional
+	MaxRetries int              // 5 if not set
+	RetryDelay time.Duration    // delay before the first retry, doubled for every retry, 1s if not set
+	OnDelivery DeliveryCallback // optional, called for every message after its own callback
+	Logger     Logger           // optional, receives the logs of retries
+
+	mu     sync.Mutex
+	queues map[string]*outboxQueue
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// NewOutbox creates an Outbox sending through sender, store may be nil
+func NewOutbox(sender MessageSender, store OutboxStore) *Outbox {
+	return &Outbox{Sender: sender, Store: store}
+}
+
+// init creates the queues and the context of the workers on first use, o.mu must be held
+func (o *Outbox) init() {
+	if o.queues == nil {
+		o.queues = make(map[string]*outboxQueue)
+		o.ctx, o.cancel = context.WithCancel(context.Background())
+	}
+}
+
+// Restore queues the messages left in the Store by a previous run, in their original order
+func (o *Outbox) Restore() error {
+	if o.Store == nil {
+		return nil
+	}
+	messages, err := o.Store.Load()
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].QueuedAt.Before(messages[j].QueuedAt)
+	})
+	for _, message := range messages {
+		if err := o.push(&outboxEntry{message: message}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Enqueue queues a text message for to, callback may be nil
+func (o *Outbox) Enqueue(to, content string, callback DeliveryCallback) (*OutboxMessage, error) {
+	return o.EnqueueMessage(&OutboxMessage{To: to, Content: content}, callback)
+}
+
+// EnqueueMessage queues a message, filling in its ID, ClientGeneratedID and QueuedAt if missing
+func (o *Outbox) EnqueueMessage(message *OutboxMessage, callback DeliveryCallback) (*OutboxMessage, error) {
+	if message.ClientGeneratedID == 0 {
+		message.ClientGeneratedID = newClientGeneratedID()
+	}
+	if message.ID == "" {
+		message.ID = strconv.FormatUint(message.ClientGeneratedID, 10)
+	}
+	if message.QueuedAt.IsZero() {
+		message.QueuedAt = time.Now()
+	}
+
+	if o.Store != nil {
+		if err := o.Store.Save(message); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.push(&outboxEntry{message: message, callback: callback}); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// push adds an entry to the queue of its target and starts a worker if there is none
+func (o *Outbox) push(entry *outboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return ErrOutboxClosed
+	}
+	o.init()
+
+	queue, running := o.queues[entry.message.To]
+	if !running {
+		queue = &outboxQueue{}
+		o.queues[entry.message.To] = queue
+	}
+	queue.entries = append(queue.entries, entry)
+
+	if !running {
+		o.wg.Add(1)
+		go o.drain(entry.message.To, queue)
+	}
+	return nil
+}
+
+// drain delivers the messages of a queue one by one and exits when it is empty
+func (o *Outbox) drain(to string, queue *outboxQueue) {
+	defer o.wg.Done()
+
+	for {
+		o.mu.Lock()
+		if len(queue.entries) == 0 || o.ctx.Err() != nil {
+			delete(o.queues, to)
+			o.mu.Unlock()
+			return
+		}
+		entry := queue.entries[0]
+		o.mu.Unlock()
+
+		o.deliver(entry)
+
+		o.mu.Lock()
+		queue.entries = queue.entries[1:]
+		o.mu.Unlock()
+	}
+}
+
+// deliver sends a message, retrying transient failures, and reports the outcome
+func (o *Outbox) deliver(entry *outboxEntry) {
+	maxRetries := o.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultOutboxRetries
+	}
+	delay := o.RetryDelay
+	if delay <= 0 {
+		delay = defaultOutboxRetryDelay
+	}
+
+	message := entry.message
+	var sent *SentMessage
+	var err error
+	for {
+		message.Attempts++
+		sent, err = o.Sender.Send(message.To, message.Content, message.options()...)
+		if err == nil || !isTransientError(err) || message.Attempts > maxRetries {
+			break
+		}
+
+		logTo(o.Logger, LevelWarn, "send failed, retrying", "to", message.To, "id", message.ID,
+			"attempt", message.Attempts, "delay", delay, "error", err)
+		if o.Store != nil {
+			// keep the attempt count across restarts
+			_ = o.Store.Save(message)
+		}
+
+		select {
+		case <-o.ctx.Done():
+			// closed while waiting, the message stays in the store for the next run
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxOutboxRetryDelay {
+			delay = maxOutboxRetryDelay
+		}
+	}
+
+	if o.Store != nil {
+		_ = o.Store.Delete(message.ID)
+	}
+	if entry.callback != nil {
+		entry.callback(message, sent, err)
+	}
+	if o.OnDelivery != nil {
+		o.OnDelivery(message, sent, err)
+	}
+}
+
+// isTransientError checks if a failed send is worth retrying: network errors, timeouts, throttling
+// and server failures are, requests rejected as invalid and unknown targets are not
+func isTransientError(err error) bool {
+	var responseErr *ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.Status != hangouts.ResponseStatus_RESPONSE_STATUS_INVALID_REQUEST
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Pending returns the number of messages waiting to be delivered
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	count := 0
+	for _, queue := range o.queues {
+		count += len(queue.entries)
+	}
+	return count
+}
+
+// Close stops accepting messages and waits for the messages being sent.
+// Messages still waiting for a retry are left in the Store.
+func (o *Outbox) Close() {
+	o.mu.Lock()
+	o.closed = true
+	o.init()
+	o.mu.Unlock()
+
+	o.cancel()
+	o.wg.Wait()
+}
+
+// FileOutboxStore is an OutboxStore keeping pending messages in a JSON file
+type FileOutboxStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// NewFileOutboxStore creates a store writing to path
+func NewFileOutboxStore(path string) *FileOutboxStore {
+	return &FileOutboxStore{Path: path}
+}
+
+// read loads the messages in the file, a missing file is an empty store
+func (s *FileOutboxStore) read() (map[string]*OutboxMessage, error) {
+	messages := make(map[string]*OutboxMessage)
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return messages, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return messages, nil
+	}
+	if err = json.Unmarshal(data, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// write replaces the file atomically
+func (s *FileOutboxStore) write(messages map[string]*OutboxMessage) error {
+	data, err := json.MarshalIndent(messages, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// Save implements OutboxStore
+func (s *FileOutboxStore) Save(message *OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages, err := s.read()
+	if err != nil {
+		return err
+	}
+	copied := *message
+	messages[message.ID] = &copied
+	return s.write(messages)
+}
+
+// Delete implements OutboxStore
+func (s *FileOutboxStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := messages[id]; !ok {
+		return nil
+	}
+	delete(messages, id)
+	return s.write(messages)
+}
+
+// Load implements OutboxStore
+func (s *FileOutboxStore) Load() ([]*OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	loaded := make([]*OutboxMessage, 0, len(messages))
+	for _, message := range messages {
+		loaded = append(loaded, message)
+	}
+	return loaded, nil
+}
@@ -0,0 +1,166 @@
+package hangups
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mysqto/hangups/internal/idset"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+const (
+	defaultPollInterval    = 5 * time.Second
+	defaultMaxResponseSize = 1048576
+	// maxDeliveredEvents is the number of delivered event ids remembered to skip the overlap of syncs
+	maxDeliveredEvents = 10000
+)
+
+// EventHandler is called by a Poller for every new event with the conversation it belongs to.
+// It runs in the poll loop: events are handled one at a time and the next sync waits for the
+// handler, long running work should be done in a goroutine.
+type EventHandler func(conversation *hangouts.Conversation, event *hangouts.Event)
+
+// Poller watches for new events by polling SyncAllNewEvents.
+// Every event is delivered once, in timestamp order within a conversation.
+// Events recognised by Client.EchoSuppressor are skipped.
+type Poller struct {
+	Client          *Client
+	Interval        time.Duration   // 5s if not set
+	MaxResponseSize uint64          // 1MB if not set
+	OnError         func(err error) // optional, failed syncs are retried on the next tick
+	// Since is the server timestamp to sync from, e.g. the time of a previous SyncRecentConversations,
+	// the start of Run if not set
+	Since uint64
+	// OnConversation is called in the poll loop with the new state of every updated conversation,
+	// before its events, e.g. for read states which change without events. Optional.
+	OnConversation func(conversation *hangouts.Conversation)
+
+	mu            sync.Mutex
+	selfID        string
+	conversations map[string]*hangouts.Conversation
+	delivered     *idset.Set // ids of the delivered events
+}
+
+// NewPoller creates a Poller for client
+func NewPoller(client *Client) *Poller {
+	return &Poller{Client: client}
+}
+
+// SelfID returns the gaia id of the current user, it is known once Run has started
+func (p *Poller) SelfID() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.selfID
+}
+
+// Conversation returns the latest known state of a conversation, nil if it has not been seen
+func (p *Poller) Conversation(conversationID string) *hangouts.Conversation {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conversations[conversationID]
+}
+
+// Run polls until ctx is done and calls handler for every new event.
+// Only events newer than Since or the start of Run are delivered.
+func (p *Poller) Run(ctx context.Context, handler EventHandler) error {
+	info, err := p.Client.GetSelfInfo()
+	if err != nil {
+		return err
+	}
+	if err = CheckResponseHeader(info.GetResponseHeader()); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.selfID = info.GetSelfEntity().GetId().GetGaiaId()
+	p.conversations = make(map[string]*hangouts.Conversation)
+	p.delivered = idset.New(maxDeliveredEvents)
+	p.mu.Unlock()
+
+	interval := p.Interval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	maxResponseSize := p.MaxResponseSize
+	if maxResponseSize == 0 {
+		maxResponseSize = defaultMaxResponseSize
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	syncTimestamp := p.Since
+	if syncTimestamp == 0 {
+		syncTimestamp = info.GetResponseHeader().GetCurrentServerTime()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		response, err := p.Client.SyncAllNewEvents(syncTimestamp, maxResponseSize)
+		if err == nil {
+			err = CheckResponseHeader(response.GetResponseHeader())
+		}
+		if err != nil {
+			logTo(p.Client.Logger, LevelWarn, "sync failed, retrying on the next poll", "error", err)
+			if p.Client.Stats != nil {
+				p.Client.Stats.SyncDone(err)
+			}
+			if p.OnError != nil {
+				p.OnError(err)
+			}
+			continue
+		}
+		if p.Client.Stats != nil {
+			p.Client.Stats.SyncDone(nil)
+		}
+		syncTimestamp = response.GetResponseHeader().GetCurrentServerTime()
+
+		for _, state := range response.GetConversationState() {
+			p.deliver(state, handler)
+		}
+	}
+}
+
+// deliver passes the new events of a conversation state to handler
+func (p *Poller) deliver(state *hangouts.ConversationState, handler EventHandler) {
+	conversationID := state.GetConversationId().GetId()
+
+	p.mu.Lock()
+	if conversation := state.GetConversation(); conversation != nil {
+		p.conversations[conversationID] = conversation
+	}
+	conversation := p.conversations[conversationID]
+	p.mu.Unlock()
+
+	if conversation == nil {
+		conversation = &hangouts.Conversation{ConversationId: state.GetConversationId()}
+	} else if p.OnConversation != nil && state.GetConversation() != nil {
+		p.OnConversation(conversation)
+	}
+
+	events := append([]*hangouts.Event{}, state.GetEvent()...)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].GetTimestamp() < events[j].GetTimestamp()
+	})
+	for _, event := range events {
+		// the sync window overlaps the previous one
+		if !p.delivered.Add(event.GetEventId()) {
+			continue
+		}
+
+		if p.Client.Stats != nil {
+			sent := time.Unix(0, int64(event.GetTimestamp())*int64(time.Microsecond))
+			p.Client.Stats.EventReceived(time.Since(sent))
+		}
+		if p.Client.EchoSuppressor != nil && p.Client.EchoSuppressor.IsEcho(event) {
+			continue
+		}
+		handler(conversation, event)
+	}
+}
@@ -0,0 +1,247 @@
+package hangups
+
+import (
+	"sort"
+	"sync"
+
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// maxTrackedEvents limits how many events are remembered per conversation
+const maxTrackedEvents = 1000
+
+// ReadReceipt is emitted when a participant's watermark passes one of our sent events
+type ReadReceipt struct {
+	ConversationID string
+	ParticipantID  string // gaia id of the reader
+	EventID        string
+	EventTimestamp uint64
+	ReadTimestamp  uint64 // latest_read_timestamp of the reader
+}
+
+// trackedEvent is the minimal information kept for every event seen by the tracker
+type trackedEvent struct {
+	id        string
+	senderID  string
+	timestamp uint64
+	countable bool // whether the event counts towards unread messages
+}
+
+// conversationReadState holds the watermarks and recent events of a conversation
+type conversationReadState struct {
+	watermarks map[string]uint64 // gaia id -> latest read timestamp
+	events     []*trackedEvent   // sorted by timestamp
+}
+
+// ReadStateTracker keeps track of the read watermark of every participant and
+// reports read receipts for the events sent by SelfID.
+// It is fed with conversations, events and watermark notifications from sync responses.
+type ReadStateTracker struct {
+	SelfID        string             // gaia id of the current user
+	OnReadReceipt func(*ReadReceipt) // called when someone reads one of our events
+
+	mu            sync.Mutex
+	conversations map[string]*conversationReadState
+}
+
+// NewReadStateTracker creates a tracker for the user with gaia id selfID
+func NewReadStateTracker(selfID string) *ReadStateTracker {
+	return &ReadStateTracker{
+		SelfID:        selfID,
+		conversations: make(map[string]*conversationReadState),
+	}
+}
+
+// conversation returns the read state of a conversation, creating it if needed
+func (t *ReadStateTracker) conversation(conversationID string) *conversationReadState {
+	if t.conversations == nil {
+		t.conversations = make(map[string]*conversationReadState)
+	}
+	state, ok := t.conversations[conversationID]
+	if !ok {
+		state = &conversationReadState{watermarks: make(map[string]uint64)}
+		t.conversations[conversationID] = state
+	}
+	return state
+}
+
+// advance moves the watermark of participantID forward and returns the receipts it produces
+func (t *ReadStateTracker) advance(conversationID, participantID string, timestamp uint64) []*ReadReceipt {
+	state := t.conversation(conversationID)
+	previous := state.watermarks[participantID]
+	if timestamp <= previous {
+		return nil
+	}
+	state.watermarks[participantID] = timestamp
+
+	if participantID == t.SelfID {
+		return nil
+	}
+
+	receipts := make([]*ReadReceipt, 0)
+	for _, event := range state.events {
+		if event.timestamp <= previous || event.timestamp > timestamp || event.senderID != t.SelfID {
+			continue
+		}
+		receipts = append(receipts, &ReadReceipt{
+			ConversationID: conversationID,
+			ParticipantID:  participantID,
+			EventID:        event.id,
+			EventTimestamp: event.timestamp,
+			ReadTimestamp:  timestamp,
+		})
+	}
+	return receipts
+}
+
+// emit calls OnReadReceipt for every receipt, must be called without holding the lock
+func (t *ReadStateTracker) emit(receipts []*ReadReceipt) {
+	if t.OnReadReceipt == nil {
+		return
+	}
+	for _, receipt := range receipts {
+		t.OnReadReceipt(receipt)
+	}
+}
+
+// UpdateConversation updates watermarks from the read_state and self_read_state of a conversation
+func (t *ReadStateTracker) UpdateConversation(conversation *hangouts.Conversation) {
+	conversationID := conversation.GetConversationId().GetId()
+	if conversationID == "" {
+		return
+	}
+
+	t.mu.Lock()
+	t.conversation(conversationID)
+	receipts := make([]*ReadReceipt, 0)
+	for _, readState := range conversation.GetReadState() {
+		participantID := readState.GetParticipantId().GetGaiaId()
+		receipts = append(receipts, t.advance(conversationID, participantID, readState.GetLatestReadTimestamp())...)
+	}
+	if selfReadState := conversation.GetSelfConversationState().GetSelfReadState(); selfReadState != nil {
+		t.advance(conversationID, t.SelfID, selfReadState.GetLatestReadTimestamp())
+	}
+	t.mu.Unlock()
+
+	t.emit(receipts)
+}
+
+// AddEvent records an event so it can be reported in read receipts and unread counts
+func (t *ReadStateTracker) AddEvent(event *hangouts.Event) {
+	conversationID := event.GetConversationId().GetId()
+	if conversationID == "" || event.GetEventId() == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state := t.conversation(conversationID)
+	for _, tracked := range state.events {
+		if tracked.id == event.GetEventId() {
+			return
+		}
+	}
+
+	state.events = append(state.events, &trackedEvent{
+		id:        event.GetEventId(),
+		senderID:  event.GetSenderId().GetGaiaId(),
+		timestamp: event.GetTimestamp(),
+		countable: event.GetChatMessage() != nil,
+	})
+	sort.SliceStable(state.events, func(i, j int) bool {
+		return state.events[i].timestamp < state.events[j].timestamp
+	})
+	if len(state.events) > maxTrackedEvents {
+		state.events = state.events[len(state.events)-maxTrackedEvents:]
+	}
+}
+
+// AddConversationState records the events of a conversation state and then updates its watermarks,
+// suitable for the conversation states of SyncAllNewEvents and SyncRecentConversations
+func (t *ReadStateTracker) AddConversationState(conversationState *hangouts.ConversationState) {
+	for _, event := range conversationState.GetEvent() {
+		t.AddEvent(event)
+	}
+	if conversation := conversationState.GetConversation(); conversation != nil {
+		t.UpdateConversation(conversation)
+	}
+}
+
+// HandleWatermark applies a WatermarkNotification
+func (t *ReadStateTracker) HandleWatermark(notification *hangouts.WatermarkNotification) {
+	conversationID := notification.GetConversationId().GetId()
+	participantID := notification.GetSenderId().GetGaiaId()
+	if conversationID == "" || participantID == "" {
+		return
+	}
+
+	t.mu.Lock()
+	receipts := t.advance(conversationID, participantID, notification.GetLatestReadTimestamp())
+	t.mu.Unlock()
+
+	t.emit(receipts)
+}
+
+// Watermark returns the latest read timestamp of a participant in a conversation
+func (t *ReadStateTracker) Watermark(conversationID, participantID string) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.conversations[conversationID]
+	if !ok {
+		return 0
+	}
+	return state.watermarks[participantID]
+}
+
+// ReadBy returns the gaia ids of the participants who have read the event, excluding its sender
+func (t *ReadStateTracker) ReadBy(conversationID, eventID string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.conversations[conversationID]
+	if !ok {
+		return nil
+	}
+
+	var event *trackedEvent
+	for _, tracked := range state.events {
+		if tracked.id == eventID {
+			event = tracked
+			break
+		}
+	}
+	if event == nil {
+		return nil
+	}
+
+	readers := make([]string, 0)
+	for participantID, timestamp := range state.watermarks {
+		if participantID != event.senderID && timestamp >= event.timestamp {
+			readers = append(readers, participantID)
+		}
+	}
+	sort.Strings(readers)
+	return readers
+}
+
+// UnreadCount returns the number of chat messages from others newer than our own watermark
+func (t *ReadStateTracker) UnreadCount(conversationID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.conversations[conversationID]
+	if !ok {
+		return 0
+	}
+
+	selfTimestamp := state.watermarks[t.SelfID]
+	count := 0
+	for _, event := range state.events {
+		if event.countable && event.senderID != t.SelfID && event.timestamp > selfTimestamp {
+			count++
+		}
+	}
+	return count
+}
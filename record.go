@@ -0,0 +1,167 @@
+package hangups
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/prototext"
+)
+
+// Recorded files are named <timestamp>-<sequence>-<endpoint>.<kind>.<format>, e.g.
+// 20201018T101500.123-000001-conversations_sendchatmessage.response.bin, where kind is
+// request or response and format is bin for raw protobuf or txt for the text format.
+// The raw files can be inspected with protoc --decode_raw < file.bin
+const (
+	recordTimeFormat  = "20060102T150405.000"
+	recordRequestExt  = ".request"
+	recordResponseExt = ".response"
+)
+
+// Recorder writes the requests and responses of Client.ProtobufAPIRequest to a directory,
+// as raw protobuf and in the protobuf text format. Set it as Client.Recorder.
+// Responses which cannot be decoded are recorded as received.
+type Recorder struct {
+	Dir string
+
+	mu       sync.Mutex
+	sequence int
+}
+
+// NewRecorder creates a recorder writing to dir, the directory is created if needed
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Recorder{Dir: dir}, nil
+}
+
+// Record writes a request and its response, response is nil and rawResponse is the body as received
+// if the response could not be decoded
+func (r *Recorder) Record(endpoint string, request, response proto.Message, rawRequest, rawResponse []byte) error {
+	r.mu.Lock()
+	r.sequence++
+	base := fmt.Sprintf("%s-%06d-%s", time.Now().UTC().Format(recordTimeFormat), r.sequence,
+		strings.ReplaceAll(endpoint, "/", "_"))
+	r.mu.Unlock()
+	base = filepath.Join(r.Dir, base)
+
+	if err := r.write(base+recordRequestExt, request, rawRequest); err != nil {
+		return err
+	}
+	return r.write(base+recordResponseExt, response, rawResponse)
+}
+
+// write writes a message as raw protobuf and in text format, message may be nil
+func (r *Recorder) write(path string, message proto.Message, raw []byte) error {
+	if err := ioutil.WriteFile(path+".bin", raw, 0600); err != nil {
+		return err
+	}
+	if message == nil {
+		return nil
+	}
+	text, err := prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(proto.MessageV2(message))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".txt", text, 0600)
+}
+
+// recordExchange records a request with the recorder of the client, if it is set.
+// Recording failures are logged and do not fail the request.
+func (c *Client) recordExchange(endpoint string, request, response proto.Message, rawRequest, rawResponse []byte) {
+	if c.Recorder == nil {
+		return
+	}
+	if err := c.Recorder.Record(endpoint, request, response, rawRequest, rawResponse); err != nil {
+		logTo(c.Logger, LevelWarn, "cannot record request", "endpoint", endpoint, "error", err)
+	}
+}
+
+// ReplayTransport is a http.RoundTripper serving the responses recorded by a Recorder, to run a
+// Client offline with
+//
+//	client := &hangups.Client{HTTPClient: &http.Client{Transport: replay}}
+//
+// The responses of an endpoint are served in the order they were recorded, whatever the request.
+// Requests outside of the chat API, e.g. uploads, are not recorded and fail.
+type ReplayTransport struct {
+	Repeat bool // serve the last response of an endpoint again once all have been served
+
+	mu        sync.Mutex
+	responses map[string][]string // recorded response files by endpoint
+	served    map[string]int
+}
+
+// NewReplayTransport loads the responses recorded in dir
+func NewReplayTransport(dir string) (*ReplayTransport, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+recordResponseExt+".bin"))
+	if err != nil {
+		return nil, err
+	}
+	// the names start with the timestamp and the sequence number
+	sort.Strings(paths)
+
+	t := &ReplayTransport{responses: make(map[string][]string), served: make(map[string]int)}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), recordResponseExt+".bin")
+		parts := strings.SplitN(name, "-", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		endpoint := strings.ReplaceAll(parts[2], "_", "/")
+		t.responses[endpoint] = append(t.responses[endpoint], path)
+	}
+	if len(t.responses) == 0 {
+		return nil, fmt.Errorf("no recorded responses in %s", dir)
+	}
+	return t, nil
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	endpoint := strings.TrimPrefix(req.URL.Path, "/chat/v1/")
+	t.mu.Lock()
+	responses := t.responses[endpoint]
+	index := t.served[endpoint]
+	if index >= len(responses) && t.Repeat && len(responses) > 0 {
+		index = len(responses) - 1
+	}
+	t.served[endpoint]++
+	t.mu.Unlock()
+
+	if index >= len(responses) {
+		return nil, fmt.Errorf("no recorded response left for %s", req.URL.Path)
+	}
+	raw, err := ioutil.ReadFile(responses[index])
+	if err != nil {
+		return nil, err
+	}
+
+	// the chat API sends base64 encoded protobuf, see newAPIRequest
+	body := base64.StdEncoding.EncodeToString(raw)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/x-protobuf"}},
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(body))),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,231 @@
+package hangups
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/golang/protobuf/proto"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// defaultMaxMessageRunes is used when Client.MaxMessageRunes is not set
+const defaultMaxMessageRunes = 4000
+
+// linkPattern finds http and https links in a line of text
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// newSegment creates a segment, link segments get their target set
+func newSegment(segmentType hangouts.SegmentType, text string) *hangouts.Segment {
+	linkData := &hangouts.LinkData{}
+	if segmentType == hangouts.SegmentType_SEGMENT_TYPE_LINK {
+		linkData.LinkTarget = proto.String(text)
+	}
+	return &hangouts.Segment{
+		Type:       segmentType.Enum(),
+		Text:       proto.String(text),
+		Formatting: &hangouts.Formatting{},
+		LinkData:   linkData,
+	}
+}
+
+// TextToSegments splits plain text into TEXT, LINE_BREAK and LINK segments
+func TextToSegments(text string) []*hangouts.Segment {
+	segments := make([]*hangouts.Segment, 0)
+	if text == "" {
+		return segments
+	}
+
+	// a message which is just an address is sent as a link even without a scheme
+	if !strings.ContainsAny(text, " \t\r\n") && govalidator.IsURL(text) {
+		return append(segments, newSegment(hangouts.SegmentType_SEGMENT_TYPE_LINK, text))
+	}
+
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			segments = append(segments, newSegment(hangouts.SegmentType_SEGMENT_TYPE_LINE_BREAK, "\n"))
+		}
+		line = strings.TrimSuffix(line, "\r")
+
+		start := 0
+		for _, match := range linkPattern.FindAllStringIndex(line, -1) {
+			// trailing punctuation is more likely part of the sentence than of the link
+			end := match[1]
+			for end > match[0] && strings.ContainsRune(".,;:!?)", rune(line[end-1])) {
+				end--
+			}
+			if !govalidator.IsURL(line[match[0]:end]) {
+				continue
+			}
+			if match[0] > start {
+				segments = append(segments, newSegment(hangouts.SegmentType_SEGMENT_TYPE_TEXT, line[start:match[0]]))
+			}
+			segments = append(segments, newSegment(hangouts.SegmentType_SEGMENT_TYPE_LINK, line[match[0]:end]))
+			start = end
+		}
+		if start < len(line) {
+			segments = append(segments, newSegment(hangouts.SegmentType_SEGMENT_TYPE_TEXT, line[start:]))
+		}
+	}
+	return segments
+}
+
+// messageLimits is the maximum size of a message, a zero limit is not enforced
+type messageLimits struct {
+	bytes int
+	runes int
+}
+
+// fits checks if a size is within the limits
+func (l messageLimits) fits(bytes, runes int) bool {
+	return (l.bytes <= 0 || bytes <= l.bytes) && (l.runes <= 0 || runes <= l.runes)
+}
+
+// segmentSize returns the size of a segment in bytes and runes, line breaks count as one character
+func segmentSize(segment *hangouts.Segment) (int, int) {
+	if segment.GetType() == hangouts.SegmentType_SEGMENT_TYPE_LINE_BREAK {
+		return 1, 1
+	}
+	return len(segment.GetText()), utf8.RuneCountInString(segment.GetText())
+}
+
+// isLineBreak checks if a segment is a line break
+func isLineBreak(segment *hangouts.Segment) bool {
+	return segment.GetType() == hangouts.SegmentType_SEGMENT_TYPE_LINE_BREAK
+}
+
+// SplitSegments splits the segments of a message into chunks within maxBytes and maxRunes,
+// a limit of 0 is not enforced. Chunks are cut at paragraph boundaries if possible, then at
+// line breaks, then between segments. Text segments larger than a chunk are split at whitespace,
+// keeping their formatting. Link segments are never split.
+func SplitSegments(segments []*hangouts.Segment, maxBytes, maxRunes int) [][]*hangouts.Segment {
+	limits := messageLimits{bytes: maxBytes, runes: maxRunes}
+
+	pieces := make([]*hangouts.Segment, 0, len(segments))
+	for _, segment := range segments {
+		bytes, runes := segmentSize(segment)
+		if segment.GetType() == hangouts.SegmentType_SEGMENT_TYPE_TEXT && !limits.fits(bytes, runes) {
+			pieces = append(pieces, splitTextSegment(segment, limits)...)
+			continue
+		}
+		pieces = append(pieces, segment)
+	}
+
+	chunks := make([][]*hangouts.Segment, 0)
+	flush := func(chunk []*hangouts.Segment) {
+		if len(chunk) > 0 {
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	current := make([]*hangouts.Segment, 0)
+	currentBytes, currentRunes := 0, 0
+	for _, piece := range pieces {
+		bytes, runes := segmentSize(piece)
+		if len(current) > 0 && !limits.fits(currentBytes+bytes, currentRunes+runes) {
+			cut := breakPoint(current)
+			flush(current[:cut])
+			current = append([]*hangouts.Segment{}, current[cut:]...)
+			currentBytes, currentRunes = 0, 0
+			for _, segment := range current {
+				b, r := segmentSize(segment)
+				currentBytes, currentRunes = currentBytes+b, currentRunes+r
+			}
+			// the carried over tail and the new piece may still not fit together
+			if len(current) > 0 && !limits.fits(currentBytes+bytes, currentRunes+runes) {
+				flush(current)
+				current = current[:0]
+				currentBytes, currentRunes = 0, 0
+			}
+		}
+		current = append(current, piece)
+		currentBytes, currentRunes = currentBytes+bytes, currentRunes+runes
+	}
+	flush(current)
+	if len(chunks) < 2 {
+		return chunks
+	}
+
+	// the line breaks at a cut would show as blank lines at the end and the start of the parts
+	trimmed := make([][]*hangouts.Segment, 0, len(chunks))
+	for i, chunk := range chunks {
+		if i > 0 {
+			chunk = trimLeadingLineBreaks(chunk)
+		}
+		if i < len(chunks)-1 {
+			chunk = trimTrailingLineBreaks(chunk)
+		}
+		if len(chunk) > 0 {
+			trimmed = append(trimmed, chunk)
+		}
+	}
+	return trimmed
+}
+
+// breakPoint returns where to cut a full chunk: after the last paragraph break,
+// else after the last line break, else at its end
+func breakPoint(chunk []*hangouts.Segment) int {
+	for i := len(chunk) - 1; i > 0; i-- {
+		if isLineBreak(chunk[i]) && isLineBreak(chunk[i-1]) {
+			return i + 1
+		}
+	}
+	for i := len(chunk) - 1; i > 0; i-- {
+		if isLineBreak(chunk[i]) {
+			return i + 1
+		}
+	}
+	return len(chunk)
+}
+
+// trimLeadingLineBreaks removes the line breaks at the start of a chunk
+func trimLeadingLineBreaks(chunk []*hangouts.Segment) []*hangouts.Segment {
+	for len(chunk) > 0 && isLineBreak(chunk[0]) {
+		chunk = chunk[1:]
+	}
+	return chunk
+}
+
+// trimTrailingLineBreaks removes the line breaks at the end of a chunk
+func trimTrailingLineBreaks(chunk []*hangouts.Segment) []*hangouts.Segment {
+	for len(chunk) > 0 && isLineBreak(chunk[len(chunk)-1]) {
+		chunk = chunk[:len(chunk)-1]
+	}
+	return chunk
+}
+
+// splitTextSegment splits a text segment into pieces within the limits, preferring whitespace
+func splitTextSegment(segment *hangouts.Segment, limits messageLimits) []*hangouts.Segment {
+	pieces := make([]*hangouts.Segment, 0)
+	text := segment.GetText()
+
+	for text != "" {
+		// find the longest prefix within the limits
+		end, runes, lastSpace := 0, 0, -1
+		for i, r := range text {
+			// invalid bytes decode as one RuneError each, RuneLen would count 3
+			_, size := utf8.DecodeRuneInString(text[i:])
+			if !limits.fits(i+size, runes+1) {
+				break
+			}
+			end, runes = i+size, runes+1
+			if unicode.IsSpace(r) {
+				lastSpace = end
+			}
+		}
+		if end == 0 {
+			// a single rune larger than the byte limit, send it anyway
+			_, end = utf8.DecodeRuneInString(text)
+		} else if end < len(text) && lastSpace > end/2 {
+			end = lastSpace
+		}
+
+		piece := proto.Clone(segment).(*hangouts.Segment)
+		piece.Text = proto.String(text[:end])
+		pieces = append(pieces, piece)
+		text = text[end:]
+	}
+	return pieces
+}
@@ -0,0 +1,122 @@
+package hangups
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// chunkTexts returns the text of every chunk, line breaks as \n
+func chunkTexts(chunks [][]*hangouts.Segment) []string {
+	texts := make([]string, 0, len(chunks))
+	for _, chunk := range chunks {
+		var text strings.Builder
+		for _, segment := range chunk {
+			if isLineBreak(segment) {
+				text.WriteString("\n")
+				continue
+			}
+			text.WriteString(segment.GetText())
+		}
+		texts = append(texts, text.String())
+	}
+	return texts
+}
+
+func TestSplitSegments(t *testing.T) {
+	link := "https://example.com/a/very/long/path/to/a/page"
+
+	tests := []struct {
+		name     string
+		text     string
+		maxBytes int
+		maxRunes int
+		want     []string
+	}{
+		{name: "empty", text: "", maxRunes: 10, want: []string{}},
+		{name: "no limits", text: "aaa\n\nbbb\nccc", want: []string{"aaa\n\nbbb\nccc"}},
+		{name: "negative limits", text: "aaa\n\nbbb\nccc", maxBytes: -1, maxRunes: -1, want: []string{"aaa\n\nbbb\nccc"}},
+		{name: "fits", text: "aaa\n\nbbb", maxBytes: 8, maxRunes: 8, want: []string{"aaa\n\nbbb"}},
+		{name: "paragraph break first", text: "aaa\n\nbbb\nccc", maxRunes: 10, want: []string{"aaa", "bbb\nccc"}},
+		{name: "then line break", text: "aaa bbb\nccc ddd", maxRunes: 10, want: []string{"aaa bbb", "ccc ddd"}},
+		{name: "then whitespace", text: "hello world foo", maxRunes: 8, want: []string{"hello ", "world ", "foo"}},
+		{name: "no whitespace", text: "abcdefghij", maxRunes: 4, want: []string{"abcd", "efgh", "ij"}},
+		{name: "line breaks trimmed at the cut", text: "aaaa\n\n\n\nbbbb", maxRunes: 6, want: []string{"aaaa", "bbbb"}},
+		{name: "chunks of line breaks dropped", text: "aaaa\n\n\n\n\n\n\n\nbbbb", maxRunes: 4, want: []string{"aaaa", "bbbb"}},
+		{name: "leading line break of the message kept", text: "\naaaa\nbbbb", maxRunes: 5, want: []string{"\naaaa", "bbbb"}},
+		{name: "two byte runes at the byte limit", text: "ééééé", maxBytes: 5, want: []string{"éé", "éé", "é"}},
+		{name: "three byte runes at the rune limit", text: "日本語テキスト", maxRunes: 3, want: []string{"日本語", "テキス", "ト"}},
+		{name: "byte limit stricter than rune limit", text: "日本語テキスト", maxBytes: 7, maxRunes: 5, want: []string{"日本", "語テ", "キス", "ト"}},
+		{name: "rune larger than the byte limit", text: "日本", maxBytes: 2, want: []string{"日", "本"}},
+		{name: "invalid utf-8", text: "abcdef\xff\xfe", maxRunes: 6, want: []string{"abcdef", "\xff\xfe"}},
+		{name: "link longer than the limit", text: "see " + link + " ok", maxRunes: 10, want: []string{"see ", link, " ok"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chunks := SplitSegments(TextToSegments(test.text), test.maxBytes, test.maxRunes)
+			got := chunkTexts(chunks)
+			if strings.Join(got, "|") != strings.Join(test.want, "|") || len(got) != len(test.want) {
+				t.Fatalf("SplitSegments() = %q, want %q", got, test.want)
+			}
+			for _, chunk := range chunks {
+				for _, segment := range chunk {
+					if segment.GetType() == hangouts.SegmentType_SEGMENT_TYPE_LINK &&
+						segment.GetLinkData().GetLinkTarget() != segment.GetText() {
+						t.Errorf("link %q points to %q", segment.GetText(), segment.GetLinkData().GetLinkTarget())
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSplitSegmentsLimits(t *testing.T) {
+	text := strings.Repeat("Grüße aus Köln, 日本語も。\n", 40) + "\n" + strings.Repeat("word ", 200)
+	for _, limit := range []struct{ bytes, runes int }{{0, 50}, {64, 0}, {64, 50}, {7, 3}, {3, 0}} {
+		chunks := SplitSegments(TextToSegments(text), limit.bytes, limit.runes)
+		joined := ""
+		for _, chunk := range chunkTexts(chunks) {
+			if !utf8.ValidString(chunk) {
+				t.Errorf("limits %v: chunk %q splits a rune", limit, chunk)
+			}
+			if (limit.bytes > 0 && len(chunk) > limit.bytes) || (limit.runes > 0 && utf8.RuneCountInString(chunk) > limit.runes) {
+				t.Errorf("limits %v: chunk %q is too large", limit, chunk)
+			}
+			joined += chunk
+		}
+		// only the line breaks at the cuts are dropped
+		if strings.ReplaceAll(joined, "\n", "") != strings.ReplaceAll(text, "\n", "") {
+			t.Errorf("limits %v: text changed by splitting", limit)
+		}
+	}
+}
+
+func TestSplitSegmentsKeepsFormatting(t *testing.T) {
+	bold := newSegment(hangouts.SegmentType_SEGMENT_TYPE_TEXT, "bold text that is split")
+	bold.Formatting = &hangouts.Formatting{Bold: proto.Bool(true)}
+	link := newSegment(hangouts.SegmentType_SEGMENT_TYPE_LINK, "https://example.com/page")
+	link.LinkData.LinkTarget = proto.String("https://example.com/page?ref=chat")
+	segments := []*hangouts.Segment{bold, link}
+
+	chunks := SplitSegments(segments, 0, 10)
+	want := []string{"bold text ", "that is ", "split", "https://example.com/page"}
+	if got := chunkTexts(chunks); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("SplitSegments() = %q, want %q", got, want)
+	}
+	for _, chunk := range chunks[:3] {
+		if !chunk[0].GetFormatting().GetBold() {
+			t.Errorf("chunk %q lost its formatting", chunk[0].GetText())
+		}
+	}
+	if last := chunks[3][0]; last.GetType() != hangouts.SegmentType_SEGMENT_TYPE_LINK ||
+		last.GetLinkData().GetLinkTarget() != "https://example.com/page?ref=chat" {
+		t.Errorf("link segment changed to %v", last)
+	}
+	if bold.GetText() != "bold text that is split" {
+		t.Errorf("input segment modified to %q", bold.GetText())
+	}
+}
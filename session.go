@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 
@@ -20,6 +21,23 @@ type Session struct {
 	RefreshToken string
 	Cookies      string
 	Sapisid      string
+
+	// AuthCode asks the user to log in at authURL and returns the auth code, it is used when
+	// RefreshToken is empty. PromptAuthCode is used if not set.
+	AuthCode func(authURL string) (string, error)
+	Logger   Logger // optional, receives the logs of logins and token refreshes
+}
+
+// PromptAuthCode asks for the auth code on the terminal, the prompt is written to stderr
+func PromptAuthCode(authURL string) (string, error) {
+	fmt.Fprintln(os.Stderr, "Can't find Refresh Token. Please navigate to the below address and paste the code")
+	fmt.Fprintln(os.Stderr, authURL)
+	fmt.Fprint(os.Stderr, "Auth Code: ")
+	authCode := ""
+	if _, err := fmt.Scanln(&authCode); err != nil {
+		return "", fmt.Errorf("cannot read auth code : %v", err)
+	}
+	return authCode, nil
 }
 
 var (
@@ -45,13 +63,16 @@ func (s *Session) Init() error {
 
 	client, err := s.getOauthClient(oauthConf)
 	if err != nil {
+		logTo(s.Logger, LevelError, "cannot get access token", "error", err)
 		return err
 	}
 
 	err = s.setCookies(client)
 	if err != nil {
+		logTo(s.Logger, LevelError, "cannot get session cookies", "error", err)
 		return err
 	}
+	logTo(s.Logger, LevelInfo, "session initialized")
 	return nil
 }
 
@@ -109,8 +130,10 @@ func (s *Session) getOauthClient(oauthConf *oauth2.Config) (*http.Client, error)
 	var err error
 
 	if s.RefreshToken == "" {
-		token, err = tokenFromAuthCode(oauthConf)
+		logTo(s.Logger, LevelInfo, "no refresh token, logging in with an auth code")
+		token, err = s.tokenFromAuthCode(oauthConf)
 	} else {
+		logTo(s.Logger, LevelDebug, "refreshing access token")
 		token, err = tokenFromRefreshToken(oauthConf, s.RefreshToken)
 	}
 
@@ -131,16 +154,19 @@ func tokenFromRefreshToken(oauthConf *oauth2.Config, refreshToken string) (*oaut
 	return tokenSource.Token()
 }
 
-func tokenFromAuthCode(oauthConf *oauth2.Config) (*oauth2.Token, error) {
+func (s *Session) tokenFromAuthCode(oauthConf *oauth2.Config) (*oauth2.Token, error) {
 	// construct url and encode queries properly
 	authURL := removeResponseTypeFromAuthURL(oauthConf.AuthCodeURL("", deviceName))
 
 	// ask the user for the auth token
-	fmt.Println("Can't find Refresh Token. Please navigate to the below address and paste the code")
-	fmt.Println(authURL)
-	fmt.Print("Auth Code: ")
-	authCode := ""
-	fmt.Scanln(&authCode)
+	prompt := s.AuthCode
+	if prompt == nil {
+		prompt = PromptAuthCode
+	}
+	authCode, err := prompt(authURL)
+	if err != nil {
+		return nil, err
+	}
 
 	// got the auth_code. Exchange it with an access token
 	token, err := oauthConf.Exchange(context.TODO(), authCode)
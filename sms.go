@@ -0,0 +1,116 @@
+package hangups
+
+import (
+	"errors"
+
+	"github.com/golang/protobuf/proto"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// SMSMessage is a Google Voice SMS or MMS event
+type SMSMessage struct {
+	ConversationID string
+	SenderID       string // gaia id of the sender
+	EventID        string
+	Timestamp      uint64
+	PhoneNumber    string // the Google Voice number the message went through, in E.164 format
+	Text           string
+	Attachments    []*Attachment
+	MMS            bool
+}
+
+// GetPhoneNumbers returns the phone numbers of the account, Google Voice numbers have GoogleVoice set
+func (c *Client) GetPhoneNumbers() ([]*hangouts.Phone, error) {
+	selfInfo, err := c.GetSelfInfo()
+	if err != nil {
+		return nil, err
+	}
+	if selfInfo.GetResponseHeader().GetStatus() != hangouts.ResponseStatus_RESPONSE_STATUS_OK {
+		return nil, errors.New("cannot get self info : " + selfInfo.GetResponseHeader().GetErrorDescription())
+	}
+	return selfInfo.GetPhoneData().GetPhone(), nil
+}
+
+// GetGoogleVoiceNumbers returns the Google Voice numbers of the account in E.164 format
+func (c *Client) GetGoogleVoiceNumbers() ([]string, error) {
+	phones, err := c.GetPhoneNumbers()
+	if err != nil {
+		return nil, err
+	}
+	numbers := make([]string, 0, len(phones))
+	for _, phone := range phones {
+		if phone.GetGoogleVoice() {
+			numbers = append(numbers, phone.GetPhoneNumber().GetE164())
+		}
+	}
+	return numbers, nil
+}
+
+// DeliveryMediumOptions returns the delivery mediums available to send to a conversation
+func DeliveryMediumOptions(conversation *hangouts.Conversation) []*hangouts.DeliveryMediumOption {
+	return conversation.GetSelfConversationState().GetDeliveryMediumOption()
+}
+
+// defaultDeliveryMedium returns the delivery medium marked as current default, falling back to
+// the first offered medium and then to BABEL
+func defaultDeliveryMedium(conversation *hangouts.Conversation) *hangouts.DeliveryMedium {
+	options := DeliveryMediumOptions(conversation)
+	for _, option := range options {
+		if option.GetCurrentDefault() && option.GetDeliveryMedium() != nil {
+			return option.GetDeliveryMedium()
+		}
+	}
+	if len(options) > 0 && options[0].GetDeliveryMedium() != nil {
+		return options[0].GetDeliveryMedium()
+	}
+	return &hangouts.DeliveryMedium{
+		MediumType: hangouts.DeliveryMediumType_DELIVERY_MEDIUM_BABEL.Enum(),
+	}
+}
+
+// findDeliveryMedium returns the delivery medium of a conversation matching the type and the phone number if given
+func findDeliveryMedium(conversation *hangouts.Conversation, mediumType hangouts.DeliveryMediumType, e164 string) *hangouts.DeliveryMedium {
+	for _, option := range DeliveryMediumOptions(conversation) {
+		medium := option.GetDeliveryMedium()
+		if medium.GetMediumType() != mediumType {
+			continue
+		}
+		if e164 == "" || medium.GetPhoneNumber().GetE164() == e164 {
+			return medium
+		}
+	}
+
+	// not offered by the conversation, let the server decide if it is acceptable
+	medium := &hangouts.DeliveryMedium{MediumType: mediumType.Enum()}
+	if e164 != "" {
+		medium.PhoneNumber = &hangouts.PhoneNumber{E164: proto.String(e164)}
+	}
+	return medium
+}
+
+// IsSMS returns true for Google Voice SMS and MMS events
+func IsSMS(event *hangouts.Event) bool {
+	return event.GetEventType() == hangouts.EventType_EVENT_TYPE_SMS ||
+		event.GetEventType() == hangouts.EventType_EVENT_TYPE_MMS
+}
+
+// ParseSMS returns the SMS or MMS carried by an event, or nil for other events
+func ParseSMS(event *hangouts.Event) *SMSMessage {
+	if !IsSMS(event) {
+		return nil
+	}
+
+	sms := &SMSMessage{
+		ConversationID: event.GetConversationId().GetId(),
+		SenderID:       event.GetSenderId().GetGaiaId(),
+		EventID:        event.GetEventId(),
+		Timestamp:      event.GetTimestamp(),
+		PhoneNumber:    event.GetMediumType().GetPhoneNumber().GetE164(),
+		Attachments:    EventAttachments(event),
+		MMS:            event.GetEventType() == hangouts.EventType_EVENT_TYPE_MMS,
+	}
+	if message := NewMessage(event); message != nil {
+		sms.Text = message.Text()
+	}
+	return sms
+}
@@ -0,0 +1,18 @@
+package hangups
+
+import (
+	"time"
+)
+
+// Stats receives the measurements of a Client and its Pollers, the methods are called concurrently.
+// See package metrics for an implementation exposed through expvar and Prometheus.
+type Stats interface {
+	// RequestDone is called when an API request has ended, with its endpoint, duration and status
+	RequestDone(trace *RequestTrace)
+	// SyncDone is called after every poll of a Poller, err is nil for successful polls
+	SyncDone(err error)
+	// EventReceived is called for every event delivered by a Poller, lag is the time since the event was sent
+	EventReceived(lag time.Duration)
+	// UploadedBytes is called with the number of bytes of media sent to the upload servers
+	UploadedBytes(n int64)
+}
@@ -0,0 +1,428 @@
+package hangups
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	hangouts "github.com/mysqto/hangups/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Takeout exports of Hangouts come in two layouts:
+//
+//	{"conversations": [{"conversation": {"conversation_id": ..., "conversation": ...}, "events": [...]}]}
+//	{"conversation_state": [{"conversation_id": ..., "conversation_state": {"conversation": ..., "event": [...]}}]}
+//
+// Field names follow the proto names with a few exceptions, enum values are written without their
+// prefix (TEXT instead of SEGMENT_TYPE_TEXT), 64 bit integers are strings and embed items use
+// snake_case names for the camelCase fields of the embeds proto.
+
+// takeoutFieldAliases maps takeout names to proto field names where they differ,
+// they are only used if the message has no field of the takeout name
+var takeoutFieldAliases = map[string]string{
+	"event_otr": "otr_status",
+	"id":        "conversation_id", // the id of a conversation object
+}
+
+// takeoutEnumAliases maps takeout enum values to the names of the proto enum values
+var takeoutEnumAliases = map[string]string{
+	"STICKY_ONE_TO_ONE":         "ONE_TO_ONE",
+	"BABEL_MEDIUM":              "BABEL",
+	"GOOGLE_VOICE_MEDIUM":       "GOOGLE_VOICE",
+	"LOCAL_SMS_MEDIUM":          "LOCAL_SMS",
+	"START_HANGOUT":             "START",
+	"END_HANGOUT":               "END",
+	"JOIN_HANGOUT":              "JOIN",
+	"LEAVE_HANGOUT":             "LEAVE",
+	"HANGOUT_COMING_SOON":       "COMING_SOON",
+	"ONGOING_HANGOUT":           "ONGOING",
+	"MEDIA_TYPE_PHOTO":          "Picture",
+	"MEDIA_TYPE_VIDEO":          "Video",
+	"MEDIA_TYPE_ANIMATED_PHOTO": "AnimatedPhoto",
+	"MEDIA_TYPE_PHOTOSPHERE":    "Photosphere",
+}
+
+// TakeoutReader streams the conversations of a Takeout Hangouts.json file.
+// Only one conversation is held in memory at a time.
+type TakeoutReader struct {
+	decoder *json.Decoder
+	legacy  bool // the file uses the conversation_state layout
+	started bool
+	done    bool
+}
+
+// NewTakeoutReader creates a reader decoding the Hangouts.json read from r
+func NewTakeoutReader(r io.Reader) *TakeoutReader {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	return &TakeoutReader{decoder: decoder}
+}
+
+// Next returns the next conversation with all its events, or io.EOF after the last one
+func (t *TakeoutReader) Next() (*hangouts.ConversationState, error) {
+	if t.done {
+		return nil, io.EOF
+	}
+	if !t.started {
+		if err := t.seekConversations(); err != nil {
+			t.done = true
+			return nil, err
+		}
+		t.started = true
+	}
+
+	if !t.decoder.More() {
+		t.done = true
+		// a file cut after a conversation has no closing bracket
+		if err := expectDelim(t.decoder, ']'); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	var raw map[string]interface{}
+	if err := t.decoder.Decode(&raw); err != nil {
+		t.done = true
+		return nil, fmt.Errorf("cannot decode takeout conversation : %v", err)
+	}
+	return parseTakeoutConversation(raw, t.legacy)
+}
+
+// seekConversations advances the decoder into the array of conversations, skipping other keys
+func (t *TakeoutReader) seekConversations() error {
+	if err := expectDelim(t.decoder, '{'); err != nil {
+		return err
+	}
+	for t.decoder.More() {
+		token, err := t.decoder.Token()
+		if err != nil {
+			return truncated(err)
+		}
+		key, _ := token.(string)
+		if key == "conversations" || key == "conversation_state" {
+			t.legacy = key == "conversation_state"
+			return expectDelim(t.decoder, '[')
+		}
+		var skipped json.RawMessage
+		if err = t.decoder.Decode(&skipped); err != nil {
+			return truncated(err)
+		}
+	}
+	return errors.New("takeout file has no conversations")
+}
+
+// expectDelim reads a delimiter token
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return truncated(err)
+	}
+	if token != delim {
+		return fmt.Errorf("invalid takeout file : expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+// truncated reports the end of the input inside the takeout structure as an unexpected one,
+// io.EOF is only returned after the last conversation
+func truncated(err error) error {
+	if err == io.EOF {
+		return fmt.Errorf("invalid takeout file : %w", io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// ReadTakeout calls fn for every conversation of a Takeout Hangouts.json file, stopping at the first error
+func ReadTakeout(r io.Reader, fn func(state *hangouts.ConversationState) error) error {
+	reader := NewTakeoutReader(r)
+	for {
+		state, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(state); err != nil {
+			return err
+		}
+	}
+}
+
+// parseTakeoutConversation converts one element of the conversations array
+func parseTakeoutConversation(raw map[string]interface{}, legacy bool) (*hangouts.ConversationState, error) {
+	state := &hangouts.ConversationState{}
+
+	if legacy {
+		inner, _ := raw["conversation_state"].(map[string]interface{})
+		if inner == nil {
+			return nil, errors.New("invalid takeout conversation : missing conversation_state")
+		}
+		if err := takeoutToProto(inner, state.ProtoReflect()); err != nil {
+			return nil, err
+		}
+		if state.ConversationId == nil {
+			if id, ok := raw["conversation_id"].(map[string]interface{}); ok {
+				state.ConversationId = &hangouts.ConversationId{}
+				if err := takeoutToProto(id, state.ConversationId.ProtoReflect()); err != nil {
+					return nil, err
+				}
+			}
+		}
+		fillConversationID(state)
+		return state, nil
+	}
+
+	conversation, _ := raw["conversation"].(map[string]interface{})
+	if conversation == nil {
+		return nil, errors.New("invalid takeout conversation : missing conversation")
+	}
+	if err := takeoutToProto(conversation, state.ProtoReflect()); err != nil {
+		return nil, err
+	}
+
+	events, _ := raw["events"].([]interface{})
+	state.Event = make([]*hangouts.Event, 0, len(events))
+	for _, rawEvent := range events {
+		fields, ok := rawEvent.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid takeout event : not an object")
+		}
+		event := &hangouts.Event{}
+		if err := takeoutToProto(fields, event.ProtoReflect()); err != nil {
+			return nil, err
+		}
+		state.Event = append(state.Event, event)
+	}
+	fillConversationID(state)
+	return state, nil
+}
+
+// fillConversationID copies the conversation id between the state and the conversation,
+// takeout files do not always repeat it in both places
+func fillConversationID(state *hangouts.ConversationState) {
+	if state.Conversation == nil {
+		state.Conversation = &hangouts.Conversation{}
+	}
+	if state.ConversationId == nil {
+		state.ConversationId = state.Conversation.ConversationId
+	}
+	if state.Conversation.ConversationId == nil {
+		state.Conversation.ConversationId = state.ConversationId
+	}
+}
+
+// normaliseName makes snake_case and camelCase names comparable
+func normaliseName(name string) string {
+	return strings.ToLower(strings.Replace(name, "_", "", -1))
+}
+
+// takeoutField finds the proto field of a takeout key.
+// Repeated fields are often singular in takeout (participant_id for participant_ids, type for typeArray)
+// and a few string fields carry a _p suffix in the proto (id for id_p).
+func takeoutField(fields protoreflect.FieldDescriptors, key string) protoreflect.FieldDescriptor {
+	if field := fields.ByName(protoreflect.Name(key)); field != nil {
+		return field
+	}
+	if alias, ok := takeoutFieldAliases[key]; ok {
+		if field := fields.ByName(protoreflect.Name(alias)); field != nil {
+			return field
+		}
+	}
+
+	wanted := normaliseName(key)
+	candidates := []string{wanted, wanted + "s", wanted + "array", wanted + "p"}
+	for _, candidate := range candidates {
+		for i := 0; i < fields.Len(); i++ {
+			if normaliseName(string(fields.Get(i).Name())) == candidate {
+				return fields.Get(i)
+			}
+		}
+	}
+	return nil
+}
+
+// takeoutToProto fills a message from a decoded takeout object, unknown keys and enum values are skipped
+func takeoutToProto(raw map[string]interface{}, message protoreflect.Message) error {
+	fields := message.Descriptor().Fields()
+	for key, value := range raw {
+		field := takeoutField(fields, key)
+		if field == nil || value == nil {
+			continue
+		}
+
+		if field.IsList() {
+			values, ok := value.([]interface{})
+			if !ok {
+				values = []interface{}{value}
+			}
+			list := message.Mutable(field).List()
+			for _, item := range values {
+				if field.Kind() == protoreflect.MessageKind {
+					object, ok := item.(map[string]interface{})
+					if !ok {
+						return fmt.Errorf("invalid takeout value for %s : expected object", field.FullName())
+					}
+					element := list.NewElement()
+					if err := takeoutToProto(object, element.Message()); err != nil {
+						return err
+					}
+					list.Append(element)
+					continue
+				}
+				converted, ok, err := takeoutScalar(field, item)
+				if err != nil {
+					return err
+				}
+				if ok {
+					list.Append(converted)
+				}
+			}
+			continue
+		}
+
+		if field.IsMap() {
+			continue
+		}
+
+		if field.Kind() == protoreflect.MessageKind {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("invalid takeout value for %s : expected object", field.FullName())
+			}
+			if err := takeoutToProto(object, message.Mutable(field).Message()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		converted, ok, err := takeoutScalar(field, value)
+		if err != nil {
+			return err
+		}
+		if ok {
+			message.Set(field, converted)
+		}
+	}
+	return nil
+}
+
+// takeoutScalar converts a scalar json value, ok is false for unknown enum values
+func takeoutScalar(field protoreflect.FieldDescriptor, value interface{}) (protoreflect.Value, bool, error) {
+	invalid := func() (protoreflect.Value, bool, error) {
+		return protoreflect.Value{}, false, fmt.Errorf("invalid takeout value for %s : %v", field.FullName(), value)
+	}
+
+	switch field.Kind() {
+	case protoreflect.StringKind:
+		switch v := value.(type) {
+		case string:
+			return protoreflect.ValueOfString(v), true, nil
+		case json.Number:
+			return protoreflect.ValueOfString(v.String()), true, nil
+		}
+	case protoreflect.BytesKind:
+		if v, ok := value.(string); ok {
+			return protoreflect.ValueOfBytes([]byte(v)), true, nil
+		}
+	case protoreflect.BoolKind:
+		switch v := value.(type) {
+		case bool:
+			return protoreflect.ValueOfBool(v), true, nil
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return invalid()
+			}
+			return protoreflect.ValueOfBool(b), true, nil
+		}
+	case protoreflect.EnumKind:
+		number, ok := takeoutEnum(field.Enum(), value)
+		return protoreflect.ValueOfEnum(number), ok, nil
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(numberString(value), 64)
+		if err != nil {
+			return invalid()
+		}
+		if field.Kind() == protoreflect.FloatKind {
+			return protoreflect.ValueOfFloat32(float32(f)), true, nil
+		}
+		return protoreflect.ValueOfFloat64(f), true, nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		i, err := strconv.ParseInt(numberString(value), 10, 32)
+		if err != nil {
+			return invalid()
+		}
+		return protoreflect.ValueOfInt32(int32(i)), true, nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		i, err := strconv.ParseInt(numberString(value), 10, 64)
+		if err != nil {
+			return invalid()
+		}
+		return protoreflect.ValueOfInt64(i), true, nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		u, err := strconv.ParseUint(numberString(value), 10, 32)
+		if err != nil {
+			return invalid()
+		}
+		return protoreflect.ValueOfUint32(uint32(u)), true, nil
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		u, err := strconv.ParseUint(numberString(value), 10, 64)
+		if err != nil {
+			return invalid()
+		}
+		return protoreflect.ValueOfUint64(u), true, nil
+	}
+	return invalid()
+}
+
+// numberString returns the text of a json number or a number written as a string
+func numberString(value interface{}) string {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String()
+	case string:
+		return v
+	}
+	return ""
+}
+
+// takeoutEnum resolves an enum value written by name, with or without its prefix, or by number
+func takeoutEnum(enum protoreflect.EnumDescriptor, value interface{}) (protoreflect.EnumNumber, bool) {
+	if number, ok := value.(json.Number); ok {
+		i, err := strconv.ParseInt(number.String(), 10, 32)
+		if err != nil || enum.Values().ByNumber(protoreflect.EnumNumber(i)) == nil {
+			return 0, false
+		}
+		return protoreflect.EnumNumber(i), true
+	}
+
+	name, ok := value.(string)
+	if !ok {
+		return 0, false
+	}
+	if alias, ok := takeoutEnumAliases[name]; ok {
+		name = alias
+	}
+
+	values := enum.Values()
+	if v := values.ByName(protoreflect.Name(name)); v != nil {
+		return v.Number(), true
+	}
+	for i := 0; i < values.Len(); i++ {
+		if strings.HasSuffix(string(values.Get(i).Name()), "_"+name) {
+			return values.Get(i).Number(), true
+		}
+	}
+	// embed item types are CamelCase in the proto and UPPER_CASE in takeout
+	for i := 0; i < values.Len(); i++ {
+		if normaliseName(string(values.Get(i).Name())) == normaliseName(name) {
+			return values.Get(i).Number(), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,282 @@
+package hangups
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+func TestReadTakeoutFile(t *testing.T) {
+	file, err := os.Open("testdata/Hangouts.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var states []*hangouts.ConversationState
+	err = ReadTakeout(file, func(state *hangouts.ConversationState) error {
+		states = append(states, state)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadTakeout() error = %v", err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("got %d conversations, want 2", len(states))
+	}
+
+	first := states[0]
+	if got := first.GetConversationId().GetId(); got != "UgxConversation1" {
+		t.Errorf("conversation id = %q, want UgxConversation1", got)
+	}
+	conversation := first.GetConversation()
+	if got := conversation.GetConversationId().GetId(); got != "UgxConversation1" {
+		t.Errorf("conversation.conversation_id = %q, want UgxConversation1", got)
+	}
+	if got := conversation.GetType(); got != hangouts.ConversationType_CONVERSATION_TYPE_ONE_TO_ONE {
+		t.Errorf("type = %v, want ONE_TO_ONE", got)
+	}
+	if got := conversation.GetSelfConversationState().GetStatus(); got != hangouts.ConversationStatus_CONVERSATION_STATUS_ACTIVE {
+		t.Errorf("status = %v, want ACTIVE", got)
+	}
+	if got := conversation.GetSelfConversationState().GetSelfReadState().GetLatestReadTimestamp(); got != 1591012800000000 {
+		t.Errorf("latest_read_timestamp = %d", got)
+	}
+	if got := conversation.GetOtrStatus(); got != hangouts.OffTheRecordStatus_OFF_THE_RECORD_STATUS_ON_THE_RECORD {
+		t.Errorf("otr_status = %v, want ON_THE_RECORD", got)
+	}
+	if got := len(conversation.GetCurrentParticipant()); got != 2 {
+		t.Errorf("got %d current participants, want 2", got)
+	}
+	participants := conversation.GetParticipantData()
+	if len(participants) != 2 || participants[1].GetFallbackName() != "John Roe" ||
+		participants[1].GetParticipantType() != hangouts.ParticipantType_PARTICIPANT_TYPE_GAIA {
+		t.Errorf("participant_data = %v", participants)
+	}
+	if got := conversation.GetNetworkType(); len(got) != 1 || got[0] != hangouts.NetworkType_NETWORK_TYPE_BABEL {
+		t.Errorf("network_type = %v, want [BABEL]", got)
+	}
+
+	if len(first.GetEvent()) != 2 {
+		t.Fatalf("got %d events, want 2", len(first.GetEvent()))
+	}
+	event := first.GetEvent()[0]
+	if event.GetEventId() != "7-H0Z7-Fn5Fj7-H0Z7" || event.GetTimestamp() != 1591012800000000 ||
+		event.GetSenderId().GetGaiaId() != "1001" {
+		t.Errorf("event = %v", event)
+	}
+	if got := event.GetEventType(); got != hangouts.EventType_EVENT_TYPE_REGULAR_CHAT_MESSAGE {
+		t.Errorf("event_type = %v, want REGULAR_CHAT_MESSAGE", got)
+	}
+	if got := event.GetOtrStatus(); got != hangouts.OffTheRecordStatus_OFF_THE_RECORD_STATUS_ON_THE_RECORD {
+		t.Errorf("event_otr = %v, want ON_THE_RECORD", got)
+	}
+	segments := event.GetChatMessage().GetMessageContent().GetSegment()
+	wantTypes := []hangouts.SegmentType{
+		hangouts.SegmentType_SEGMENT_TYPE_TEXT,
+		hangouts.SegmentType_SEGMENT_TYPE_LINE_BREAK,
+		hangouts.SegmentType_SEGMENT_TYPE_LINK,
+	}
+	if len(segments) != len(wantTypes) {
+		t.Fatalf("got %d segments, want %d", len(segments), len(wantTypes))
+	}
+	for i, segment := range segments {
+		if segment.GetType() != wantTypes[i] {
+			t.Errorf("segment %d type = %v, want %v", i, segment.GetType(), wantTypes[i])
+		}
+	}
+	if got := segments[2].GetLinkData().GetLinkTarget(); got != "https://example.com" {
+		t.Errorf("link_target = %q", got)
+	}
+	if !first.GetEvent()[1].GetChatMessage().GetMessageContent().GetSegment()[0].GetFormatting().GetBold() {
+		t.Error("formatting.bold not set")
+	}
+
+	second := states[1]
+	if got := second.GetConversation().GetConversationId().GetId(); got != "UgxConversation2" {
+		t.Errorf("conversation.conversation_id = %q, want it filled from the state", got)
+	}
+	if got := second.GetConversation().GetType(); got != hangouts.ConversationType_CONVERSATION_TYPE_GROUP {
+		t.Errorf("type = %v, want GROUP", got)
+	}
+	if len(second.GetEvent()) != 3 {
+		t.Fatalf("got %d events, want 3", len(second.GetEvent()))
+	}
+	rename := second.GetEvent()[0]
+	if rename.GetEventType() != hangouts.EventType_EVENT_TYPE_CONVERSATION_RENAME ||
+		rename.GetConversationRename().GetNewName() != "Team" {
+		t.Errorf("rename event = %v", rename)
+	}
+
+	attachments := second.GetEvent()[1].GetChatMessage().GetMessageContent().GetAttachment()
+	if len(attachments) != 1 {
+		t.Fatalf("got %d photo attachments, want 1", len(attachments))
+	}
+	item := attachments[0].GetEmbedItem()
+	if types := item.GetTypeArray(); len(types) != 1 || types[0] != hangouts.EMItemType_PlusPhoto {
+		t.Errorf("photo type = %v, want [PlusPhoto]", types)
+	}
+	if item.GetIdP() != "embed-photo" {
+		t.Errorf("photo id = %q", item.GetIdP())
+	}
+	photo := item.GetPlusPhoto()
+	if photo.GetPhotoId() != "6002" || photo.GetAlbumId() != "6001" || photo.GetOwnerObfuscatedId() != "1003" ||
+		photo.GetOriginalContentURL() != "https://lh3.googleusercontent.com/original.jpg" ||
+		photo.GetDownloadURL() != "https://video.googleusercontent.com/download.jpg" {
+		t.Errorf("plus_photo = %v", photo)
+	}
+	if photo.GetMediaType() != hangouts.EMPlusPhoto_PhotoMediaType_Picture {
+		t.Errorf("media_type = %v, want Picture", photo.GetMediaType())
+	}
+	if streams := photo.GetStreamIdArray(); strings.Join(streams, ",") != "by_date,messenger" {
+		t.Errorf("stream_id = %v", streams)
+	}
+	if thumbnail := photo.GetThumbnail(); thumbnail.GetWidthPx() != 640 || thumbnail.GetHeightPx() != 480 ||
+		thumbnail.GetImageURL() != "//lh3.googleusercontent.com/thumb.jpg" {
+		t.Errorf("thumbnail = %v", thumbnail)
+	}
+	parsed := ParseAttachment(item)
+	if parsed.Type != AttachmentPhoto || parsed.ImageURL != "https://lh3.googleusercontent.com/thumb.jpg" ||
+		parsed.Width != 640 || parsed.Height != 480 {
+		t.Errorf("parsed photo = %+v", parsed)
+	}
+
+	attachments = second.GetEvent()[2].GetChatMessage().GetMessageContent().GetAttachment()
+	if len(attachments) != 1 {
+		t.Fatalf("got %d location attachments, want 1", len(attachments))
+	}
+	item = attachments[0].GetEmbedItem()
+	wantItemTypes := []hangouts.EMItemType{hangouts.EMItemType_PlaceV2, hangouts.EMItemType_ThingV2, hangouts.EMItemType_Thing}
+	if types := item.GetTypeArray(); len(types) != len(wantItemTypes) || types[0] != wantItemTypes[0] ||
+		types[1] != wantItemTypes[1] || types[2] != wantItemTypes[2] {
+		t.Errorf("place type = %v, want %v", types, wantItemTypes)
+	}
+	place := item.GetPlaceV2()
+	if place.GetName() != "Brandenburger Tor" || place.GetURL() != "https://maps.google.com/maps?q=52.5163,13.3777" {
+		t.Errorf("place_v2 = %v", place)
+	}
+	if geo := place.GetGeo().GetGeoCoordinatesV2(); geo.GetLatitude() != 52.5163 || geo.GetLongitude() != 13.3777 {
+		t.Errorf("geo = %v", geo)
+	}
+	if address := place.GetAddress().GetPostalAddressV2(); address.GetStreetAddress() != "Pariser Platz" ||
+		address.GetAddressLocality() != "Berlin" || address.GetPostalCode() != "10117" {
+		t.Errorf("address = %v", address)
+	}
+	if image := place.GetRepresentativeImage(); image.GetIdP() != "embed-map" ||
+		image.GetImageObjectV2().GetWidth() != "400px" || image.GetImageObjectV2().GetHeight() != "300" {
+		t.Errorf("representative_image = %v", image)
+	}
+	parsed = ParseAttachment(item)
+	if parsed.Type != AttachmentLocation || parsed.Latitude != 52.5163 || parsed.Longitude != 13.3777 ||
+		parsed.Address != "Pariser Platz, Berlin, 10117" {
+		t.Errorf("parsed location = %+v", parsed)
+	}
+}
+
+func TestTakeoutReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string // conversation ids
+		wantErr string   // part of the error after the conversations in want
+	}{
+		{
+			name:  "empty array",
+			input: `{"conversations": []}`,
+		},
+		{
+			name: "unknown keys are skipped",
+			input: `{"version": {"major": 2}, "extra": [1, {"a": null}], "conversations": [
+				{"conversation": {"conversation_id": {"id": "c1"}, "unknown": {"deep": [{"x": 1}]}},
+				 "events": [{"event_id": "e1", "new_field": "v", "event_type": "NOT_AN_EVENT_TYPE"}],
+				 "metadata": {}}]}`,
+			want: []string{"c1"},
+		},
+		{
+			name: "legacy layout",
+			input: `{"conversation_state": [
+				{"conversation_id": {"id": "c1"},
+				 "conversation_state": {"conversation": {"type": "GROUP"}, "event": [{"event_id": "e1"}]}}]}`,
+			want: []string{"c1"},
+		},
+		{
+			name:    "no conversations",
+			input:   `{"version": 1}`,
+			wantErr: "no conversations",
+		},
+		{
+			name:    "not an object",
+			input:   `[]`,
+			wantErr: "expected {",
+		},
+		{
+			name:    "truncated before the conversations",
+			input:   `{"conversations": `,
+			wantErr: "unexpected EOF",
+		},
+		{
+			name:    "truncated after a conversation",
+			input:   `{"conversations": [{"conversation": {"conversation_id": {"id": "c1"}}}`,
+			want:    []string{"c1"},
+			wantErr: "cannot decode takeout conversation",
+		},
+		{
+			name: "truncated inside a conversation",
+			input: `{"conversations": [
+				{"conversation": {"conversation_id": {"id": "c1"}}, "events": []},
+				{"conversation": {"conversation_id": {"id": "c2"}}, "events": [{"event_id": "e`,
+			want:    []string{"c1"},
+			wantErr: "cannot decode takeout conversation",
+		},
+		{
+			name:    "missing conversation",
+			input:   `{"conversations": [{"events": []}]}`,
+			wantErr: "missing conversation",
+		},
+		{
+			name:    "invalid number",
+			input:   `{"conversations": [{"conversation": {}, "events": [{"timestamp": "yesterday"}]}]}`,
+			wantErr: "invalid takeout value for hangouts.Event.timestamp",
+		},
+		{
+			name:    "scalar for a message",
+			input:   `{"conversations": [{"conversation": {"conversation_id": "c1"}}]}`,
+			wantErr: "expected object",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := NewTakeoutReader(strings.NewReader(test.input))
+			var got []string
+			var err error
+			for {
+				var state *hangouts.ConversationState
+				if state, err = reader.Next(); err != nil {
+					break
+				}
+				got = append(got, state.GetConversationId().GetId())
+			}
+
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("conversations = %v, want %v", got, test.want)
+			}
+			if test.wantErr == "" {
+				if err != io.EOF {
+					t.Errorf("error = %v, want io.EOF", err)
+				}
+				return
+			}
+			if err == nil || err == io.EOF || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("error = %v, want one containing %q", err, test.wantErr)
+			}
+			// the reader stays at its end after an error
+			if _, err = reader.Next(); err != io.EOF {
+				t.Errorf("Next() after an error = %v, want io.EOF", err)
+			}
+		})
+	}
+}
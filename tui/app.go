@@ -0,0 +1,333 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+const (
+	// defaultPollInterval is used when App.PollInterval is not set
+	defaultPollInterval = 3 * time.Second
+	// resizeInterval is how often the terminal size is checked
+	resizeInterval = 500 * time.Millisecond
+	// typingInterval is how often our typing state is sent while composing
+	typingInterval = 5 * time.Second
+	// recentConversations is the number of conversations loaded on start
+	recentConversations = 50
+	// eventsPerLoad is the number of events loaded per conversation and per history request
+	eventsPerLoad = 50
+)
+
+// App is the terminal chat client.
+//
+// Keys: Up/Down or Ctrl-P/Ctrl-N switch conversations, PageUp/PageDown scroll, Tab completes names,
+// Enter sends, "/me text" sends an action, Ctrl-L redraws and Ctrl-C quits.
+type App struct {
+	Client       *hangups.Client
+	PollInterval time.Duration                          // 3s if not set
+	Typing       <-chan *hangouts.SetTypingNotification // optional source of typing notifications, see the package doc
+	In           *os.File                               // os.Stdin if nil
+	Out          io.Writer                              // os.Stdout if nil
+
+	model         *Model
+	syncTimestamp uint64
+	updates       chan func()
+	loading       map[string]bool // conversations with a history request in flight
+	exhausted     map[string]bool // conversations whose history is completely loaded
+	lastTyping    time.Time
+	typingIn      string
+	width, height int
+}
+
+// load fetches the user and the recent conversations
+func (a *App) load() error {
+	info, err := a.Client.GetSelfInfo()
+	if err == nil {
+		err = hangups.CheckResponseHeader(info.GetResponseHeader())
+	}
+	if err != nil {
+		return err
+	}
+	a.model = NewModel(info.GetSelfEntity().GetId().GetGaiaId())
+	a.syncTimestamp = info.GetResponseHeader().GetCurrentServerTime()
+
+	response, err := a.Client.SyncRecentConversations(recentConversations, eventsPerLoad)
+	if err == nil {
+		err = hangups.CheckResponseHeader(response.GetResponseHeader())
+	}
+	if err != nil {
+		return err
+	}
+	for _, state := range response.GetConversationState() {
+		a.model.AddConversationState(state)
+	}
+	return nil
+}
+
+// Run loads the conversations and runs the UI until ctx is done or the user quits
+func (a *App) Run(ctx context.Context) error {
+	if a.In == nil {
+		a.In = os.Stdin
+	}
+	if a.Out == nil {
+		a.Out = os.Stdout
+	}
+	pollInterval := a.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	// load before switching the terminal so errors are readable
+	if err := a.load(); err != nil {
+		return err
+	}
+	a.updates = make(chan func(), 16)
+	a.loading = make(map[string]bool)
+	a.exhausted = make(map[string]bool)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	a.startPoller(ctx, pollInterval)
+
+	terminal, err := OpenTerminal(a.In, a.Out)
+	if err != nil {
+		return err
+	}
+	defer terminal.Close()
+
+	keys := make(chan Key)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- terminal.ReadKeys(keys)
+	}()
+
+	resize := time.NewTicker(resizeInterval)
+	defer resize.Stop()
+
+	a.width, a.height, err = terminal.Size()
+	if err != nil {
+		return err
+	}
+	a.markRead()
+
+	for {
+		a.loadHistory()
+		if err = terminal.Draw(a.model.Render(a.width, a.height)); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err = <-readErr:
+			return err
+		case key := <-keys:
+			if quit := a.handleKey(terminal, key); quit {
+				return nil
+			}
+		case update := <-a.updates:
+			update()
+			a.markRead()
+		case notification := <-a.Typing:
+			a.model.HandleTyping(notification)
+		case <-resize.C:
+			// also expires typing indicators
+			if width, height, err := terminal.Size(); err == nil {
+				a.width, a.height = width, height
+			}
+		}
+	}
+}
+
+// handleKey applies a key press, it returns true when the user quits
+func (a *App) handleKey(terminal *Terminal, key Key) bool {
+	page := a.height / 2
+	selected := a.model.Selected()
+
+	switch {
+	case key.Code == KeyCtrl && (key.Rune == 'c' || key.Rune == 'q'):
+		return true
+	case key.Code == KeyCtrl && key.Rune == 'd' && a.model.Input() == "":
+		return true
+	case key.Code == KeyUp || key.Code == KeyCtrl && key.Rune == 'p':
+		a.model.Select(-1)
+	case key.Code == KeyDown || key.Code == KeyCtrl && key.Rune == 'n':
+		a.model.Select(1)
+	case key.Code == KeyPageUp:
+		a.model.Scroll(page)
+	case key.Code == KeyPageDown:
+		a.model.Scroll(-page)
+	case key.Code == KeyCtrl && key.Rune == 'l':
+		terminal.Draw("\x1b[2J")
+	case key.Code == KeyEnter:
+		a.send()
+	default:
+		if a.model.Edit(key) && key.Code == KeyRune {
+			a.typing()
+		}
+	}
+
+	if a.model.Selected() != selected {
+		a.model.SetStatus("")
+		a.markRead()
+	}
+	return false
+}
+
+// async runs fn in the background, its result is applied by the returned update in the UI loop
+func (a *App) async(fn func() func()) {
+	go func() {
+		a.updates <- fn()
+	}()
+}
+
+// startPoller delivers the events since load to the UI loop until ctx is done
+func (a *App) startPoller(ctx context.Context, interval time.Duration) {
+	// the handlers run in the poll loop, they hand the states over to the UI loop
+	update := func(fn func()) {
+		select {
+		case a.updates <- fn:
+		case <-ctx.Done():
+		}
+	}
+
+	poller := hangups.NewPoller(a.Client)
+	poller.Interval = interval
+	poller.Since = a.syncTimestamp
+	poller.OnError = func(err error) {
+		update(func() {
+			a.model.SetStatus("sync failed : " + err.Error())
+		})
+	}
+	poller.OnConversation = func(conversation *hangouts.Conversation) {
+		state := &hangouts.ConversationState{ConversationId: conversation.GetConversationId(), Conversation: conversation}
+		update(func() {
+			a.model.AddConversationState(state)
+		})
+	}
+
+	go func() {
+		err := poller.Run(ctx, func(conversation *hangouts.Conversation, event *hangouts.Event) {
+			state := &hangouts.ConversationState{
+				ConversationId: conversation.GetConversationId(),
+				Event:          []*hangouts.Event{event},
+			}
+			update(func() {
+				a.model.AddConversationState(state)
+			})
+		})
+		if err != nil && ctx.Err() == nil {
+			update(func() {
+				a.model.SetStatus("sync stopped : " + err.Error())
+			})
+		}
+	}()
+}
+
+// markRead advances our watermark in the selected conversation to its newest event
+func (a *App) markRead() {
+	conversationID := a.model.Selected()
+	if conversationID == "" || a.model.UnreadCount(conversationID) == 0 {
+		return
+	}
+	timestamp := a.model.LatestTimestamp(conversationID)
+	a.model.MarkRead(conversationID, timestamp)
+
+	a.async(func() func() {
+		_, err := a.Client.UpdateWatermark(conversationID, timestamp)
+		return func() {
+			if err != nil {
+				a.model.SetStatus("cannot mark as read : " + err.Error())
+			}
+		}
+	})
+}
+
+// loadHistory fetches older events when the selected conversation is scrolled to its top
+func (a *App) loadHistory() {
+	conversationID := a.model.Selected()
+	if !a.model.NeedsHistory() || a.loading[conversationID] || a.exhausted[conversationID] {
+		return
+	}
+	a.loading[conversationID] = true
+	before := a.model.OldestTimestamp(conversationID)
+	a.model.SetStatus("loading history…")
+
+	a.async(func() func() {
+		response, err := a.Client.GetConversationHistory(conversationID, eventsPerLoad, before)
+		if err == nil {
+			err = hangups.CheckResponseHeader(response.GetResponseHeader())
+		}
+		return func() {
+			a.loading[conversationID] = false
+			if err != nil {
+				a.model.SetStatus("cannot load history : " + err.Error())
+				return
+			}
+			a.model.SetStatus("")
+			a.model.AddConversationState(response.GetConversationState())
+			if a.model.OldestTimestamp(conversationID) >= before {
+				a.exhausted[conversationID] = true
+			}
+		}
+	})
+}
+
+// typing tells the other participants that we are composing, at most every typingInterval
+func (a *App) typing() {
+	conversationID := a.model.Selected()
+	if conversationID == a.typingIn && time.Since(a.lastTyping) < typingInterval {
+		return
+	}
+	a.typingIn, a.lastTyping = conversationID, time.Now()
+
+	go a.Client.SetTyping(conversationID, int32(hangouts.TypingType_TYPING_TYPE_STARTED))
+}
+
+// send sends the composed message to the selected conversation
+func (a *App) send() {
+	conversationID := a.model.Selected()
+	text := strings.TrimSpace(a.model.TakeInput())
+	if conversationID == "" || text == "" {
+		return
+	}
+	a.typingIn = ""
+
+	options := make([]hangups.MessageOption, 0)
+	if strings.HasPrefix(text, "/me ") {
+		text = strings.TrimPrefix(text, "/me ")
+		options = append(options, hangups.WithAction())
+	}
+	a.model.SetStatus("sending…")
+
+	a.async(func() func() {
+		sent, err := a.Client.Send(conversationID, text, options...)
+		return func() {
+			if err != nil {
+				a.model.SetStatus(fmt.Sprintf("message not sent : %v", err))
+				return
+			}
+			a.model.SetStatus("")
+
+			// show the message right away instead of waiting for the next poll
+			parts := sent.Parts
+			if len(parts) == 0 {
+				parts = []*hangups.SentMessage{sent}
+			}
+			state := &hangouts.ConversationState{ConversationId: &hangouts.ConversationId{Id: &conversationID}}
+			for _, part := range parts {
+				if part.Event != nil {
+					state.Event = append(state.Event, part.Event)
+				}
+			}
+			a.model.AddConversationState(state)
+		}
+	})
+}
@@ -0,0 +1,336 @@
+package tui
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mysqto/hangups"
+	"github.com/mysqto/hangups/export"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// typingTimeout is how long a typing notification is shown without a newer one
+const typingTimeout = 15 * time.Second
+
+// conversationView is a conversation as shown by the UI
+type conversationView struct {
+	*export.Conversation
+	sortTimestamp uint64
+	archive       *export.Archive // rebuilt when events change
+	scroll        int             // lines scrolled up from the newest message
+	atTop         bool            // the oldest loaded event is visible
+	typing        map[string]time.Time
+}
+
+// Archive returns the archive form of the conversation, used for rendering
+func (v *conversationView) Archive() *export.Archive {
+	if v.archive == nil {
+		v.archive = export.NewArchive(v.Conversation)
+	}
+	return v.archive
+}
+
+// completion is the state of cycling through tab completions
+type completion struct {
+	start   int // index of the completed word in the input
+	matches []string
+	index   int
+}
+
+// Model is the state of the UI, it is not safe for concurrent use
+type Model struct {
+	SelfID string
+
+	conversations map[string]*conversationView
+	order         []string // conversation ids, most recent first
+	selected      string
+	readState     *hangups.ReadStateTracker
+
+	input      []rune
+	cursor     int
+	completion *completion
+	status     string
+}
+
+// NewModel creates an empty model for the user with gaia id selfID
+func NewModel(selfID string) *Model {
+	return &Model{
+		SelfID:        selfID,
+		conversations: make(map[string]*conversationView),
+		readState:     hangups.NewReadStateTracker(selfID),
+	}
+}
+
+// AddConversationState merges a conversation state from a sync or history response
+func (m *Model) AddConversationState(state *hangouts.ConversationState) {
+	id := state.GetConversationId().GetId()
+	if id == "" {
+		id = state.GetConversation().GetConversationId().GetId()
+	}
+	if id == "" {
+		return
+	}
+
+	view, ok := m.conversations[id]
+	if !ok {
+		view = &conversationView{
+			Conversation: export.NewConversation(state),
+			typing:       make(map[string]time.Time),
+		}
+		m.conversations[id] = view
+		m.order = append(m.order, id)
+	} else {
+		view.Update(state)
+	}
+	view.archive = nil
+
+	if timestamp := view.Conversation.Conversation.GetSelfConversationState().GetSortTimestamp(); timestamp > view.sortTimestamp {
+		view.sortTimestamp = timestamp
+	}
+	for _, event := range state.GetEvent() {
+		if event.GetTimestamp() > view.sortTimestamp {
+			view.sortTimestamp = event.GetTimestamp()
+		}
+		// a message from someone stops their typing indicator
+		delete(view.typing, event.GetSenderId().GetGaiaId())
+	}
+
+	m.readState.AddConversationState(state)
+	m.sort()
+	if m.selected == "" && len(m.order) > 0 {
+		m.selected = m.order[0]
+	}
+}
+
+// sort orders the conversations by sort timestamp, newest first
+func (m *Model) sort() {
+	sort.SliceStable(m.order, func(i, j int) bool {
+		return m.conversations[m.order[i]].sortTimestamp > m.conversations[m.order[j]].sortTimestamp
+	})
+}
+
+// HandleTyping shows or clears a typing indicator.
+// Typing notifications are not part of sync responses, they come from a notification channel.
+func (m *Model) HandleTyping(notification *hangouts.SetTypingNotification) {
+	view, ok := m.conversations[notification.GetConversationId().GetId()]
+	gaiaID := notification.GetSenderId().GetGaiaId()
+	if !ok || gaiaID == m.SelfID {
+		return
+	}
+	if notification.GetType() == hangouts.TypingType_TYPING_TYPE_STARTED {
+		view.typing[gaiaID] = time.Now().Add(typingTimeout)
+	} else {
+		delete(view.typing, gaiaID)
+	}
+}
+
+// Typing returns the names of the participants typing in a conversation
+func (m *Model) Typing(conversationID string) []string {
+	view, ok := m.conversations[conversationID]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	names := make([]string, 0)
+	for gaiaID, until := range view.typing {
+		if now.After(until) {
+			delete(view.typing, gaiaID)
+			continue
+		}
+		names = append(names, view.Name(gaiaID))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MarkRead records that we have read a conversation up to timestamp
+func (m *Model) MarkRead(conversationID string, timestamp uint64) {
+	m.readState.HandleWatermark(&hangouts.WatermarkNotification{
+		SenderId:            &hangouts.ParticipantId{GaiaId: proto.String(m.SelfID), ChatId: proto.String(m.SelfID)},
+		ConversationId:      &hangouts.ConversationId{Id: proto.String(conversationID)},
+		LatestReadTimestamp: proto.Uint64(timestamp),
+	})
+}
+
+// UnreadCount returns the number of unread messages in a conversation
+func (m *Model) UnreadCount(conversationID string) int {
+	return m.readState.UnreadCount(conversationID)
+}
+
+// Selected returns the id of the selected conversation
+func (m *Model) Selected() string {
+	return m.selected
+}
+
+// LatestTimestamp returns the timestamp of the newest event of a conversation
+func (m *Model) LatestTimestamp(conversationID string) uint64 {
+	view, ok := m.conversations[conversationID]
+	if !ok || len(view.Events) == 0 {
+		return 0
+	}
+	return view.Events[len(view.Events)-1].GetTimestamp()
+}
+
+// OldestTimestamp returns the timestamp of the oldest loaded event of a conversation
+func (m *Model) OldestTimestamp(conversationID string) uint64 {
+	view, ok := m.conversations[conversationID]
+	if !ok || len(view.Events) == 0 {
+		return 0
+	}
+	return view.Events[0].GetTimestamp()
+}
+
+// Select moves the selection by delta conversations
+func (m *Model) Select(delta int) {
+	if len(m.order) == 0 {
+		return
+	}
+	index := 0
+	for i, id := range m.order {
+		if id == m.selected {
+			index = i
+			break
+		}
+	}
+	index += delta
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(m.order) {
+		index = len(m.order) - 1
+	}
+	m.selected = m.order[index]
+}
+
+// Scroll scrolls the selected conversation by delta lines, positive values go back in time
+func (m *Model) Scroll(delta int) {
+	view, ok := m.conversations[m.selected]
+	if !ok {
+		return
+	}
+	view.scroll += delta
+	if view.scroll < 0 {
+		view.scroll = 0
+	}
+}
+
+// SetStatus shows a message in the status line until the next one
+func (m *Model) SetStatus(status string) {
+	m.status = status
+}
+
+// Input returns the text being composed
+func (m *Model) Input() string {
+	return string(m.input)
+}
+
+// TakeInput returns the text being composed and clears the input box
+func (m *Model) TakeInput() string {
+	text := string(m.input)
+	m.input, m.cursor, m.completion = nil, 0, nil
+	return text
+}
+
+// Edit applies an editing key to the input box, it returns false for keys which do not edit
+func (m *Model) Edit(key Key) bool {
+	if key.Code != KeyTab {
+		m.completion = nil
+	}
+
+	switch key.Code {
+	case KeyRune:
+		m.input = append(m.input[:m.cursor], append([]rune{key.Rune}, m.input[m.cursor:]...)...)
+		m.cursor++
+	case KeyBackspace:
+		if m.cursor > 0 {
+			m.input = append(m.input[:m.cursor-1], m.input[m.cursor:]...)
+			m.cursor--
+		}
+	case KeyDelete:
+		if m.cursor < len(m.input) {
+			m.input = append(m.input[:m.cursor], m.input[m.cursor+1:]...)
+		}
+	case KeyLeft:
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case KeyRight:
+		if m.cursor < len(m.input) {
+			m.cursor++
+		}
+	case KeyHome:
+		m.cursor = 0
+	case KeyEnd:
+		m.cursor = len(m.input)
+	case KeyTab:
+		m.complete()
+	case KeyCtrl:
+		switch key.Rune {
+		case 'a':
+			m.cursor = 0
+		case 'e':
+			m.cursor = len(m.input)
+		case 'u':
+			m.input, m.cursor = m.input[m.cursor:], 0
+		case 'k':
+			m.input = m.input[:m.cursor]
+		case 'w':
+			start := m.cursor
+			for start > 0 && m.input[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && m.input[start-1] != ' ' {
+				start--
+			}
+			m.input, m.cursor = append(m.input[:start], m.input[m.cursor:]...), start
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// complete replaces the word before the cursor with the next participant name starting with it
+func (m *Model) complete() {
+	if m.completion == nil {
+		start := m.cursor
+		for start > 0 && m.input[start-1] != ' ' {
+			start--
+		}
+		prefix := strings.ToLower(string(m.input[start:m.cursor]))
+		if prefix == "" {
+			return
+		}
+
+		matches := make([]string, 0)
+		if view, ok := m.conversations[m.selected]; ok {
+			for gaiaID, name := range view.Names {
+				if gaiaID != m.SelfID && strings.HasPrefix(strings.ToLower(name), prefix) {
+					matches = append(matches, name)
+				}
+			}
+		}
+		if len(matches) == 0 {
+			return
+		}
+		sort.Strings(matches)
+		m.completion = &completion{start: start, matches: matches, index: -1}
+	}
+
+	c := m.completion
+	c.index = (c.index + 1) % len(c.matches)
+	name := []rune(c.matches[c.index])
+
+	// a name at the start of the message addresses the participant
+	suffix := []rune(" ")
+	if c.start == 0 {
+		suffix = []rune(": ")
+	}
+	replacement := append(name, suffix...)
+	m.input = append(append(append([]rune{}, m.input[:c.start]...), replacement...), m.input[m.cursor:]...)
+	m.cursor = c.start + len(replacement)
+}
@@ -0,0 +1,417 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mysqto/hangups/export"
+)
+
+// SGR parameters of the styles used by the UI
+const (
+	styleBold      = "1"
+	styleDim       = "2"
+	styleItalic    = "3"
+	styleUnderline = "4"
+	styleReverse   = "7"
+	styleStrike    = "9"
+	styleLink      = "4;34"
+	styleSelf      = "1;32"
+	styleOther     = "1;36"
+)
+
+// maxListWidth is the widest the conversation list gets
+const maxListWidth = 30
+
+// span is a run of text in one style
+type span struct {
+	text  string
+	style string
+}
+
+// line is a row of spans, the text never contains line breaks, every rune takes one cell
+type line []span
+
+// printable replaces the control characters of remote text, which the terminal would interpret
+// as escape sequences or cursor movements. Tabs become a space to keep one cell per rune.
+func printable(r rune) rune {
+	switch {
+	case r == '\t':
+		return ' '
+	case unicode.IsControl(r):
+		return unicode.ReplacementChar
+	}
+	return r
+}
+
+// render formats a line to exactly width cells, clipping or padding it
+func (l line) render(width int) string {
+	var b strings.Builder
+	remaining := width
+	for _, s := range l {
+		if remaining <= 0 {
+			break
+		}
+		text := []rune(s.text)
+		if len(text) > remaining {
+			text = text[:remaining]
+		}
+		for i, r := range text {
+			text[i] = printable(r)
+		}
+		remaining -= len(text)
+		if s.style != "" {
+			b.WriteString("\x1b[" + s.style + "m" + string(text) + "\x1b[0m")
+		} else {
+			b.WriteString(string(text))
+		}
+	}
+	b.WriteString(strings.Repeat(" ", remaining))
+	return b.String()
+}
+
+// token is a word or a run of spaces of a span
+type token struct {
+	text  []rune
+	style string
+	space bool
+}
+
+// tokenize splits spans into words and spaces, a nil token marks a line break
+func tokenize(spans []span) []*token {
+	tokens := make([]*token, 0)
+	for _, s := range spans {
+		var current *token
+		for _, r := range s.text {
+			if r == '\n' {
+				tokens = append(tokens, nil)
+				current = nil
+				continue
+			}
+			r = printable(r)
+			space := unicode.IsSpace(r)
+			if current == nil || current.space != space {
+				current = &token{style: s.style, space: space}
+				tokens = append(tokens, current)
+			}
+			current.text = append(current.text, r)
+		}
+	}
+	return tokens
+}
+
+// wrap breaks spans into lines of at most width cells, continuation lines are indented
+func wrap(spans []span, width, indent int) []line {
+	if width <= indent {
+		indent = 0
+	}
+	lines := make([]line, 0)
+	current := line{}
+	used := 0
+	newLine := func() {
+		lines = append(lines, current)
+		current = line{span{text: strings.Repeat(" ", indent)}}
+		used = indent
+	}
+
+	for _, t := range tokenize(spans) {
+		if t == nil {
+			newLine()
+			continue
+		}
+		if t.space {
+			if used == indent && len(lines) > 0 {
+				// no leading spaces on continuation lines
+				continue
+			}
+			if used+len(t.text) > width {
+				newLine()
+				continue
+			}
+		} else if used+len(t.text) > width && len(t.text) <= width-indent && used > indent {
+			newLine()
+		}
+
+		text := t.text
+		for len(text) > 0 {
+			room := width - used
+			if room <= 0 {
+				newLine()
+				room = width - used
+			}
+			if room > len(text) {
+				room = len(text)
+			}
+			if last := len(current) - 1; last >= 0 && current[last].style == t.style {
+				current[last].text += string(text[:room])
+			} else {
+				current = append(current, span{text: string(text[:room]), style: t.style})
+			}
+			used += room
+			text = text[room:]
+		}
+	}
+	return append(lines, current)
+}
+
+// segmentStyle returns the style of a formatted message segment
+func segmentStyle(segment *export.ArchiveSegment) string {
+	if segment.Link != "" {
+		return styleLink
+	}
+	styles := make([]string, 0)
+	if segment.Bold {
+		styles = append(styles, styleBold)
+	}
+	if segment.Italic {
+		styles = append(styles, styleItalic)
+	}
+	if segment.Underline {
+		styles = append(styles, styleUnderline)
+	}
+	if segment.Strikethrough {
+		styles = append(styles, styleStrike)
+	}
+	return strings.Join(styles, ";")
+}
+
+// eventLines formats an event for the message view
+func (m *Model) eventLines(archive *export.Archive, event *export.ArchiveEvent, width int) []line {
+	prefix := event.Timestamp.Local().Format("15:04") + " "
+	spans := []span{{text: prefix, style: styleDim}}
+
+	if event.Kind != export.KindMessage {
+		spans = append(spans, span{text: "*** " + export.Describe(archive, event), style: styleDim})
+		return wrap(spans, width, len(prefix))
+	}
+
+	senderStyle := styleOther
+	if event.SenderID == m.SelfID {
+		senderStyle = styleSelf
+	}
+	sender := archive.Participant(event.SenderID)
+	if event.Action {
+		spans = append(spans, span{text: "* " + sender + " ", style: senderStyle})
+	} else {
+		spans = append(spans, span{text: sender + ": ", style: senderStyle})
+	}
+
+	for _, segment := range event.Segments {
+		if segment.Type == "LINE_BREAK" {
+			spans = append(spans, span{text: "\n"})
+			continue
+		}
+		style := segmentStyle(segment)
+		if event.Action {
+			style = strings.Trim(style+";"+styleItalic, ";")
+		}
+		spans = append(spans, span{text: segment.Text, style: style})
+	}
+	for _, attachment := range event.Attachments {
+		label := attachment.URL
+		if label == "" {
+			label = attachment.Name
+		}
+		if attachment.Address != "" {
+			label = attachment.Address
+		}
+		spans = append(spans, span{text: "\n[" + attachment.Type + "] ", style: styleDim}, span{text: label, style: styleLink})
+	}
+	return wrap(spans, width, len(prefix))
+}
+
+// messageLines formats the events of a conversation with read markers below the newest event each participant read
+func (m *Model) messageLines(view *conversationView, width int) []line {
+	archive := view.Archive()
+	conversationID := view.ID()
+
+	// newest first, so every reader is shown once, at the newest event they read
+	markers := make(map[string][]string)
+	marked := make(map[string]bool)
+	for i := len(archive.Events) - 1; i >= 0; i-- {
+		event := archive.Events[i]
+		if event.Kind != export.KindMessage {
+			continue
+		}
+		for _, reader := range m.readState.ReadBy(conversationID, event.ID) {
+			if reader == m.SelfID || marked[reader] {
+				continue
+			}
+			marked[reader] = true
+			markers[event.ID] = append(markers[event.ID], archive.Participant(reader))
+		}
+	}
+
+	lines := make([]line, 0)
+	for _, event := range archive.Events {
+		lines = append(lines, m.eventLines(archive, event, width)...)
+		if readers, ok := markers[event.ID]; ok {
+			marker := fmt.Sprintf("%*s✓ seen by %s", 6, "", strings.Join(readers, ", "))
+			lines = append(lines, wrap([]span{{text: marker, style: styleDim}}, width, 6)...)
+		}
+	}
+	return lines
+}
+
+// truncate shortens text to width runes
+func truncate(text string, width int) string {
+	runes := []rune(text)
+	if width <= 0 {
+		return ""
+	}
+	if len(runes) <= width {
+		return text
+	}
+	if width <= 1 {
+		return string(runes[:width])
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// title returns the name of a conversation or the names of the other participants
+func (m *Model) title(view *conversationView) string {
+	if name := view.Conversation.Conversation.GetName(); name != "" {
+		return name
+	}
+	names := make([]string, 0)
+	for _, participant := range view.Conversation.Conversation.GetParticipantData() {
+		if id := participant.GetId().GetGaiaId(); id != m.SelfID {
+			names = append(names, view.Name(id))
+		}
+	}
+	if len(names) == 0 {
+		return view.ID()
+	}
+	return strings.Join(names, ", ")
+}
+
+// listLines formats the conversation list, height rows of width cells
+func (m *Model) listLines(width, height int) []line {
+	// keep the selection visible
+	first := 0
+	for i, id := range m.order {
+		if id == m.selected && i >= height {
+			first = i - height + 1
+		}
+	}
+
+	lines := make([]line, 0, height)
+	for i := first; i < len(m.order) && len(lines) < height; i++ {
+		id := m.order[i]
+		view := m.conversations[id]
+		badge := ""
+		if unread := m.UnreadCount(id); unread > 0 {
+			badge = " (" + strconv.Itoa(unread) + ")"
+		}
+		name := truncate(m.title(view), width-len(badge)-1)
+
+		style := ""
+		if badge != "" {
+			style = styleBold
+		}
+		if id == m.selected {
+			style = strings.Trim(style+";"+styleReverse, ";")
+		}
+		padding := width - 1 - len([]rune(name)) - len(badge)
+		if padding < 0 {
+			padding = 0
+		}
+		text := " " + name + strings.Repeat(" ", padding) + badge
+		lines = append(lines, line{span{text: text, style: style}})
+	}
+	return lines
+}
+
+// NeedsHistory reports whether the selected conversation is scrolled to its oldest loaded event
+func (m *Model) NeedsHistory() bool {
+	view, ok := m.conversations[m.selected]
+	return ok && view.scroll > 0 && view.atTop
+}
+
+// Render draws the whole screen, width columns by height rows, and places the cursor in the input box
+func (m *Model) Render(width, height int) string {
+	if width < 20 || height < 5 {
+		return "\x1b[H\x1b[2Jterminal is too small"
+	}
+
+	listWidth := width / 3
+	if listWidth > maxListWidth {
+		listWidth = maxListWidth
+	}
+	viewWidth := width - listWidth - 1
+	bodyHeight := height - 3 // title, status and input rows
+
+	list := m.listLines(listWidth, bodyHeight+1)
+
+	var title line
+	body := make([]line, 0, bodyHeight)
+	status := m.status
+	if view, ok := m.conversations[m.selected]; ok {
+		title = line{span{text: " " + m.title(view), style: styleBold}}
+
+		lines := m.messageLines(view, viewWidth-1)
+		maxScroll := len(lines) - bodyHeight
+		if maxScroll < 0 {
+			maxScroll = 0
+		}
+		if view.scroll > maxScroll {
+			view.scroll = maxScroll
+		}
+		view.atTop = view.scroll == maxScroll
+
+		end := len(lines) - view.scroll
+		start := end - bodyHeight
+		if start < 0 {
+			start = 0
+		}
+		for _, l := range lines[start:end] {
+			body = append(body, append(line{span{text: " "}}, l...))
+		}
+		if view.scroll > 0 {
+			status = fmt.Sprintf("-- %d more lines below -- %s", view.scroll, status)
+		}
+		if typing := m.Typing(view.ID()); len(typing) > 0 {
+			status = strings.Join(typing, ", ") + " typing…  " + status
+		}
+	}
+	// messages stick to the bottom of the view
+	for len(body) < bodyHeight {
+		body = append([]line{{}}, body...)
+	}
+
+	var frame strings.Builder
+	frame.WriteString("\x1b[?25l\x1b[H")
+	for row := 0; row < bodyHeight+1; row++ {
+		var left line
+		if row < len(list) {
+			left = list[row]
+		}
+		right := title
+		if row > 0 {
+			right = body[row-1]
+		}
+		frame.WriteString(left.render(listWidth))
+		frame.WriteString("\x1b[2m│\x1b[0m")
+		frame.WriteString(right.render(viewWidth))
+		frame.WriteString("\r\n")
+	}
+	frame.WriteString(line{span{text: " " + status, style: styleReverse}}.render(width))
+	frame.WriteString("\r\n")
+
+	// the input box scrolls horizontally to keep the cursor visible
+	prompt := "> "
+	room := width - len(prompt) - 1
+	offset := 0
+	if m.cursor > room {
+		offset = m.cursor - room
+	}
+	visible := m.input[offset:]
+	if len(visible) > room+1 {
+		visible = visible[:room+1]
+	}
+	frame.WriteString(line{span{text: prompt, style: styleBold}, span{text: string(visible)}}.render(width))
+	frame.WriteString(fmt.Sprintf("\x1b[%d;%dH\x1b[?25h", height, len(prompt)+m.cursor-offset+1))
+	return frame.String()
+}
@@ -0,0 +1,196 @@
+// Package tui is a terminal chat client built on the hangups Client.
+//
+// It only needs a VT100 compatible terminal and the stty utility, so it runs over plain SSH sessions.
+//
+// New events are polled with SyncAllNewEvents, which carries no typing notifications. The typing
+// indicators of other participants are only shown when App.Typing is fed from a notification
+// channel, hangups-tui has none and only sends our own typing state.
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// KeyCode identifies a key press
+type KeyCode int
+
+// supported keys, KeyRune carries the typed character in Key.Rune and KeyCtrl the letter pressed with control
+const (
+	KeyRune KeyCode = iota
+	KeyCtrl
+	KeyEnter
+	KeyTab
+	KeyBackspace
+	KeyDelete
+	KeyEscape
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyHome
+	KeyEnd
+	KeyPageUp
+	KeyPageDown
+)
+
+// Key is a decoded key press
+type Key struct {
+	Code KeyCode
+	Rune rune
+}
+
+// Terminal is a terminal in raw mode showing the alternate screen
+type Terminal struct {
+	in    *os.File
+	out   io.Writer
+	saved string // stty settings restored by Close
+}
+
+// stty runs stty on the terminal and returns its output
+func (t *Terminal) stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = t.in
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty %s : %v", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// OpenTerminal switches the terminal connected to in to raw mode and shows the alternate screen
+func OpenTerminal(in *os.File, out io.Writer) (*Terminal, error) {
+	t := &Terminal{in: in, out: out}
+
+	saved, err := t.stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	t.saved = saved
+	if _, err = t.stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+
+	// alternate screen, clear it
+	fmt.Fprint(t.out, "\x1b[?1049h\x1b[2J")
+	return t, nil
+}
+
+// Close restores the terminal
+func (t *Terminal) Close() error {
+	fmt.Fprint(t.out, "\x1b[0m\x1b[?25h\x1b[?1049l")
+	_, err := t.stty(t.saved)
+	return err
+}
+
+// Size returns the number of columns and rows of the terminal
+func (t *Terminal) Size() (int, int, error) {
+	size, err := t.stty("size")
+	if err != nil {
+		return 0, 0, err
+	}
+	var rows, columns int
+	if _, err = fmt.Sscanf(size, "%d %d", &rows, &columns); err != nil {
+		return 0, 0, fmt.Errorf("cannot parse terminal size %q", size)
+	}
+	return columns, rows, nil
+}
+
+// Draw replaces the screen with a frame
+func (t *Terminal) Draw(frame string) error {
+	_, err := io.WriteString(t.out, frame)
+	return err
+}
+
+// ReadKeys decodes key presses and sends them to keys until reading fails
+func (t *Terminal) ReadKeys(keys chan<- Key) error {
+	reader := bufio.NewReader(t.in)
+	for {
+		key, err := readKey(reader)
+		if err != nil {
+			return err
+		}
+		keys <- key
+	}
+}
+
+// readKey decodes a single key press
+func readKey(reader *bufio.Reader) (Key, error) {
+	r, _, err := reader.ReadRune()
+	if err != nil {
+		return Key{}, err
+	}
+
+	switch {
+	case r == '\r' || r == '\n':
+		return Key{Code: KeyEnter}, nil
+	case r == '\t':
+		return Key{Code: KeyTab}, nil
+	case r == 127 || r == 8:
+		return Key{Code: KeyBackspace}, nil
+	case r == 27:
+		return readEscape(reader)
+	case r < 27:
+		return Key{Code: KeyCtrl, Rune: 'a' + r - 1}, nil
+	}
+	return Key{Code: KeyRune, Rune: r}, nil
+}
+
+// readEscape decodes the CSI and SS3 sequences of cursor and editing keys, a lone escape is KeyEscape
+func readEscape(reader *bufio.Reader) (Key, error) {
+	if reader.Buffered() == 0 {
+		return Key{Code: KeyEscape}, nil
+	}
+	introducer, err := reader.ReadByte()
+	if err != nil {
+		return Key{}, err
+	}
+	if introducer != '[' && introducer != 'O' {
+		// alt and a key
+		return Key{Code: KeyEscape}, nil
+	}
+
+	// parameters up to the final byte
+	var params strings.Builder
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return Key{}, err
+		}
+		if b >= 0x40 && b <= 0x7e {
+			switch b {
+			case 'A':
+				return Key{Code: KeyUp}, nil
+			case 'B':
+				return Key{Code: KeyDown}, nil
+			case 'C':
+				return Key{Code: KeyRight}, nil
+			case 'D':
+				return Key{Code: KeyLeft}, nil
+			case 'H':
+				return Key{Code: KeyHome}, nil
+			case 'F':
+				return Key{Code: KeyEnd}, nil
+			case '~':
+				switch params.String() {
+				case "1", "7":
+					return Key{Code: KeyHome}, nil
+				case "3":
+					return Key{Code: KeyDelete}, nil
+				case "4", "8":
+					return Key{Code: KeyEnd}, nil
+				case "5":
+					return Key{Code: KeyPageUp}, nil
+				case "6":
+					return Key{Code: KeyPageDown}, nil
+				}
+			}
+			return Key{Code: KeyEscape}, nil
+		}
+		params.WriteByte(b)
+	}
+}
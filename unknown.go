@@ -0,0 +1,243 @@
+package hangups
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// maxSampleLength limits the length of the sample values of unknown fields
+const maxSampleLength = 64
+
+// UnknownField is a field of a decoded message which is not in hangouts.proto
+type UnknownField struct {
+	Message  string // full name of the message type holding the field, e.g. hangouts.ChatMessage
+	Path     string // field names from the root message, e.g. conversation_state.event.chat_message, empty for the root
+	Number   int32
+	WireType string // varint, fixed32, fixed64, bytes or group
+	Sample   string // the value, bytes are shown as text if they are printable, as hex otherwise
+}
+
+// UnknownFields walks a message and returns the fields which are not in its schema, depth first
+func UnknownFields(message proto.Message) []*UnknownField {
+	fields := make([]*UnknownField, 0)
+	collectUnknownFields(proto.MessageV2(message).ProtoReflect(), "", &fields)
+	return fields
+}
+
+// collectUnknownFields adds the unknown fields of m and of its set message fields
+func collectUnknownFields(m protoreflect.Message, path string, fields *[]*UnknownField) {
+	name := string(m.Descriptor().FullName())
+	unknown := m.GetUnknown()
+	for len(unknown) > 0 {
+		number, wireType, n := protowire.ConsumeTag(unknown)
+		if n < 0 {
+			return
+		}
+		length := protowire.ConsumeFieldValue(number, wireType, unknown[n:])
+		if length < 0 {
+			return
+		}
+		*fields = append(*fields, &UnknownField{
+			Message:  name,
+			Path:     path,
+			Number:   int32(number),
+			WireType: wireTypeName(wireType),
+			Sample:   sampleValue(wireType, unknown[n:n+length]),
+		})
+		unknown = unknown[n+length:]
+	}
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		fieldPath := string(fd.Name())
+		if path != "" {
+			fieldPath = path + "." + fieldPath
+		}
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				collectUnknownFields(list.Get(i).Message(), fieldPath, fields)
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, value protoreflect.Value) bool {
+				collectUnknownFields(value.Message(), fieldPath, fields)
+				return true
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			collectUnknownFields(v.Message(), fieldPath, fields)
+		}
+		return true
+	})
+}
+
+// wireTypeName returns the name of a wire type
+func wireTypeName(wireType protowire.Type) string {
+	switch wireType {
+	case protowire.VarintType:
+		return "varint"
+	case protowire.Fixed32Type:
+		return "fixed32"
+	case protowire.Fixed64Type:
+		return "fixed64"
+	case protowire.BytesType:
+		return "bytes"
+	case protowire.StartGroupType:
+		return "group"
+	}
+	return "wiretype " + strconv.Itoa(int(wireType))
+}
+
+// sampleValue formats the encoded value of an unknown field
+func sampleValue(wireType protowire.Type, value []byte) string {
+	switch wireType {
+	case protowire.VarintType:
+		v, _ := protowire.ConsumeVarint(value)
+		return strconv.FormatUint(v, 10)
+	case protowire.Fixed32Type:
+		v, _ := protowire.ConsumeFixed32(value)
+		return strconv.FormatUint(uint64(v), 10)
+	case protowire.Fixed64Type:
+		v, _ := protowire.ConsumeFixed64(value)
+		return strconv.FormatUint(v, 10)
+	case protowire.BytesType:
+		v, _ := protowire.ConsumeBytes(value)
+		length := len(v)
+		truncated := length > maxSampleLength
+		if truncated {
+			v = v[:maxSampleLength]
+		}
+		var sample string
+		if isPrintable(v) {
+			sample = strconv.Quote(string(v))
+		} else {
+			sample = fmt.Sprintf("%x", v)
+		}
+		if truncated {
+			sample += "..."
+		}
+		return fmt.Sprintf("%s (%d bytes)", sample, length)
+	}
+	return ""
+}
+
+// isPrintable checks if bytes look like text rather than an embedded message
+func isPrintable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if r < 0x20 && r != '\n' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// UnknownFieldEntry aggregates the occurrences of an unknown field
+type UnknownFieldEntry struct {
+	UnknownField          // the first occurrence
+	Count        int      // number of occurrences
+	Paths        []string // every path it was seen at
+	Endpoints    []string // every endpoint it was received from
+}
+
+// unknownFieldKey identifies an unknown field
+type unknownFieldKey struct {
+	message  string
+	number   int32
+	wireType string
+}
+
+// UnknownFieldReport collects the unknown fields of responses, its Add method is meant to be
+// set as Client.OnUnknownFields. It is safe for concurrent use.
+type UnknownFieldReport struct {
+	mu      sync.Mutex
+	entries map[unknownFieldKey]*UnknownFieldEntry
+}
+
+// NewUnknownFieldReport creates an empty report
+func NewUnknownFieldReport() *UnknownFieldReport {
+	return &UnknownFieldReport{entries: make(map[unknownFieldKey]*UnknownFieldEntry)}
+}
+
+// Add adds the unknown fields of a response of endpoint
+func (r *UnknownFieldReport) Add(endpoint string, fields []*UnknownField) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, field := range fields {
+		key := unknownFieldKey{field.Message, field.Number, field.WireType}
+		entry, ok := r.entries[key]
+		if !ok {
+			entry = &UnknownFieldEntry{UnknownField: *field}
+			r.entries[key] = entry
+		}
+		entry.Count++
+		entry.Paths = appendMissing(entry.Paths, field.Path)
+		entry.Endpoints = appendMissing(entry.Endpoints, endpoint)
+	}
+}
+
+// appendMissing appends s to list if it is not in it yet
+func appendMissing(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// Entries returns the unknown fields seen so far, ordered by message type and field number
+func (r *UnknownFieldReport) Entries() []*UnknownFieldEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := make([]*UnknownFieldEntry, 0, len(r.entries))
+	for _, entry := range r.entries {
+		copied := *entry
+		copied.Paths = append([]string{}, entry.Paths...)
+		copied.Endpoints = append([]string{}, entry.Endpoints...)
+		entries = append(entries, &copied)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Message != entries[j].Message {
+			return entries[i].Message < entries[j].Message
+		}
+		if entries[i].Number != entries[j].Number {
+			return entries[i].Number < entries[j].Number
+		}
+		return entries[i].WireType < entries[j].WireType
+	})
+	return entries
+}
+
+// WriteTo writes the report as text grouped by message type
+func (r *UnknownFieldReport) WriteTo(w io.Writer) (int64, error) {
+	var report strings.Builder
+	message := ""
+	for _, entry := range r.Entries() {
+		if entry.Message != message {
+			message = entry.Message
+			fmt.Fprintf(&report, "%s\n", message)
+		}
+		fmt.Fprintf(&report, "  field %d %s, seen %d times, sample %s\n", entry.Number, entry.WireType, entry.Count, entry.Sample)
+		paths := make([]string, len(entry.Paths))
+		for i, path := range entry.Paths {
+			if paths[i] = path; path == "" {
+				paths[i] = "(root)"
+			}
+		}
+		fmt.Fprintf(&report, "    at %s\n", strings.Join(paths, ", "))
+		fmt.Fprintf(&report, "    from %s\n", strings.Join(entry.Endpoints, ", "))
+	}
+	n, err := io.WriteString(w, report.String())
+	return int64(n), err
+}
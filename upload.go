@@ -0,0 +1,312 @@
+package hangups
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultUploadChunkSize is used when UploadOptions.ChunkSize is not set, chunks must be multiples of 256KB
+	defaultUploadChunkSize = 8 * 256 * 1024
+	// defaultUploadRetries is the number of times a failed chunk is retried
+	defaultUploadRetries = 3
+)
+
+// MediaSource tells UploadImageFrom how to interpret its argument
+type MediaSource int
+
+// supported media sources
+const (
+	MediaSourceFile MediaSource = iota
+	MediaSourceURL
+	MediaSourceBase64
+)
+
+// UploadOptions tunes a streaming upload
+type UploadOptions struct {
+	ChunkSize  int64                   // bytes sent per request, rounded up to a multiple of 256KB
+	MaxRetries int                     // retries per chunk, 3 if not set
+	SessionURL string                  // resume this upload session instead of creating a new one
+	Progress   func(sent, total int64) // called after every chunk, total is the size passed to the upload
+}
+
+// UploadError is returned when an upload is interrupted.
+// Passing SessionURL in UploadOptions resumes the upload from Offset.
+type UploadError struct {
+	SessionURL string
+	Offset     int64 // bytes acknowledged by the server
+	Err        error
+}
+
+// Error implements error
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("upload interrupted at %d bytes : %v", e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// UploadMedia uploads size bytes read from r in chunks and returns the photo for chat message sending
+func (c *Client) UploadMedia(ctx context.Context, name string, r io.Reader, size int64) (*Photo, error) {
+	return c.UploadMediaWithOptions(ctx, name, r, size, nil)
+}
+
+// UploadMediaWithOptions uploads size bytes read from r in chunks, retrying failed chunks.
+// When resuming with opts.SessionURL, r must be positioned at the start of the media,
+// the bytes the server already has are skipped.
+func (c *Client) UploadMediaWithOptions(ctx context.Context, name string, r io.Reader, size int64,
+	opts *UploadOptions) (*Photo, error) {
+
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultUploadChunkSize
+	}
+	// the upload protocol only accepts chunks aligned to 256KB, except for the last one
+	const chunkAlignment = 256 * 1024
+	if chunkSize%chunkAlignment != 0 {
+		chunkSize += chunkAlignment - chunkSize%chunkAlignment
+	}
+
+	maxRetries := opts.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultUploadRetries
+	}
+
+	var offset int64
+	sessionURL := opts.SessionURL
+	if sessionURL == "" {
+		var err error
+		sessionURL, err = c.createUploadSession(name, size)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		offset, err = c.queryUpload(ctx, sessionURL)
+		if err != nil {
+			return nil, &UploadError{SessionURL: sessionURL, Err: err}
+		}
+		if err = skipReader(r, offset); err != nil {
+			return nil, &UploadError{SessionURL: sessionURL, Offset: offset, Err: err}
+		}
+		if opts.Progress != nil {
+			opts.Progress(offset, size)
+		}
+	}
+
+	buffer := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buffer)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF || (size > 0 && offset+int64(n) >= size)
+		if err != nil && !last {
+			return nil, &UploadError{SessionURL: sessionURL, Offset: offset, Err: err}
+		}
+
+		resp, err := c.uploadChunk(ctx, sessionURL, offset, buffer[:n], last, maxRetries)
+		if err != nil {
+			return nil, &UploadError{SessionURL: sessionURL, Offset: offset, Err: err}
+		}
+
+		offset += int64(n)
+		if opts.Progress != nil {
+			opts.Progress(offset, size)
+		}
+
+		if last {
+			return parseUploadResponse(resp)
+		}
+	}
+}
+
+// skipReader advances r by n bytes, seeking when possible
+func skipReader(r io.Reader, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekCurrent)
+		return err
+	}
+	_, err := io.CopyN(ioutil.Discard, r, n)
+	return err
+}
+
+// uploadRequest sends a request to an upload session and returns the response, the caller closes the body
+func (c *Client) uploadRequest(ctx context.Context, sessionURL string, headers map[string]string, body io.Reader) (*http.Response, error) {
+	req, err := c.newAPIRequest(ctx, sessionURL, "json", headers, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.httpClient().Do(req)
+}
+
+// queryUpload asks the server how many bytes of an upload session it has received
+func (c *Client) queryUpload(ctx context.Context, sessionURL string) (int64, error) {
+	headers := map[string]string{
+		"X-Goog-Upload-Command": "query",
+	}
+	resp, err := c.uploadRequest(ctx, sessionURL, headers, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("cannot query upload session : %s", resp.Status)
+	}
+	if resp.Header.Get("X-Goog-Upload-Status") == "final" {
+		return 0, errors.New("upload session is already finalized")
+	}
+
+	received, err := strconv.ParseInt(resp.Header.Get("X-Goog-Upload-Size-Received"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot get received size of upload session : %v", err)
+	}
+	return received, nil
+}
+
+// uploadChunk sends a chunk starting at offset, on failure it asks the server how much
+// of the chunk arrived and sends the rest
+func (c *Client) uploadChunk(ctx context.Context, sessionURL string, offset int64, chunk []byte,
+	last bool, maxRetries int) ([]byte, error) {
+
+	command := "upload"
+	if last {
+		command = "upload, finalize"
+	}
+
+	var sent int64
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+			logTo(c.Logger, LevelWarn, "retrying upload chunk", "offset", offset, "attempt", attempt, "error", lastErr)
+
+			received, err := c.queryUpload(ctx, sessionURL)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if received < offset || received > offset+int64(len(chunk)) {
+				return nil, fmt.Errorf("server has %d bytes, cannot resume chunk at %d", received, offset)
+			}
+			sent = received - offset
+		}
+
+		headers := map[string]string{
+			"Content-Type":          "application/octet-stream",
+			"X-Goog-Upload-Command": command,
+			"X-Goog-Upload-Offset":  strconv.FormatInt(offset+sent, 10),
+		}
+		resp, err := c.uploadRequest(ctx, sessionURL, headers, bytes.NewReader(chunk[sent:]))
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		switch {
+		case err != nil:
+			lastErr = err
+		case resp.StatusCode == http.StatusOK:
+			if c.Stats != nil {
+				c.Stats.UploadedBytes(int64(len(chunk)) - sent)
+			}
+			return body, nil
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			lastErr = fmt.Errorf("upload failed : %s", resp.Status)
+		default:
+			return nil, fmt.Errorf("upload failed : %s, raw response : %s", resp.Status, string(body))
+		}
+	}
+	return nil, lastErr
+}
+
+// UploadImageFrom uploads an image from an explicit source, v is a file path, a http/https url
+// or a base64 encoded image depending on source.
+// The image is run through Client.ImageProcessor if one is set.
+func (c *Client) UploadImageFrom(ctx context.Context, source MediaSource, v string) (*Photo, error) {
+	var name string
+	var r io.Reader
+	var size int64
+
+	switch source {
+	case MediaSourceFile:
+		file, err := os.Open(v)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		name, r, size = filepath.Base(v), file, info.Size()
+	case MediaSourceURL:
+		if err := c.checkImageURL(v); err != nil {
+			return nil, err
+		}
+		resp, err := c.mediaRequest(ctx, v)
+		if err != nil {
+			return nil, fmt.Errorf("error requesting image from %s : %v", v, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("error requesting image from %s : %s", v, resp.Status)
+		}
+		data, err := readLimited(resp, c.maxDownloadSize())
+		if err != nil {
+			return nil, fmt.Errorf("error downloading image from %s : %w", v, err)
+		}
+		name, r, size = filepath.Base(resp.Request.URL.Path), bytes.NewReader(data), int64(len(data))
+	case MediaSourceBase64:
+		uploadFile, err := readBase64Image(v)
+		if err != nil {
+			return nil, err
+		}
+		name, r, size = uploadFile.name, bytes.NewReader(uploadFile.data), int64(uploadFile.size)
+	default:
+		return nil, fmt.Errorf("unknown media source %d", source)
+	}
+
+	// the upload session needs the size up front and the processor needs the whole image
+	if size < 0 || c.ImageProcessor != nil {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading image from %s : %v", v, err)
+		}
+		if c.ImageProcessor != nil {
+			name, data, err = c.ImageProcessor.Process(name, data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	return c.UploadMedia(ctx, name, r, size)
+}
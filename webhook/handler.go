@@ -0,0 +1,132 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/mysqto/hangups"
+)
+
+// maxRequestSize limits the body of inbound requests, images may be posted base64 encoded
+const maxRequestSize = 32 << 20
+
+// SendRequest is the body accepted by Handler:
+//
+//	{"conversation_id": "UgwXYZ...", "text": "deployed *v1.2*", "action": false}
+//	{"conversation_id": "UgwXYZ...", "image": "https://example.com/graph.png"}
+//
+// text and image may both be set, the image is sent after the text.
+type SendRequest struct {
+	ConversationID string `json:"conversation_id"` // or a gaia id, email or phone number
+	Text           string `json:"text,omitempty"`
+	Action         bool   `json:"action,omitempty"` // send text as a "/me" message
+	Image          string `json:"image,omitempty"`  // a url, file path or base64 encoded image
+}
+
+// SendResponse is the body answered by Handler
+type SendResponse struct {
+	ConversationID string `json:"conversation_id,omitempty"`
+	EventID        string `json:"event_id,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// Handler sends the messages posted to it as SendRequest
+type Handler struct {
+	Client  *hangups.Client
+	Secret  []byte        // requests must be signed like the posts of a Relay, unchecked if empty
+	MaxSkew time.Duration // accepted difference of the signature timestamp, 5 minutes if not set
+	// AllowFiles lets images be given as a path on the server, only urls and base64 images are accepted otherwise.
+	// Images given by url are downloaded within Client.MaxDownloadSize and from Client.ImageHosts if set.
+	AllowFiles bool
+}
+
+// NewHandler creates a Handler sending with client
+func NewHandler(client *hangups.Client, secret []byte) *Handler {
+	return &Handler{Client: client, Secret: secret}
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		h.reply(w, http.StatusMethodNotAllowed, &SendResponse{Error: "only POST is allowed"})
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		h.reply(w, http.StatusRequestEntityTooLarge, &SendResponse{Error: err.Error()})
+		return
+	}
+	if len(h.Secret) > 0 {
+		if err = Verify(r.Header, h.Secret, body, h.MaxSkew); err != nil {
+			h.reply(w, http.StatusUnauthorized, &SendResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	var request SendRequest
+	if err = json.Unmarshal(body, &request); err != nil {
+		h.reply(w, http.StatusBadRequest, &SendResponse{Error: fmt.Sprintf("invalid request : %v", err)})
+		return
+	}
+	if err = h.validate(&request); err != nil {
+		h.reply(w, http.StatusBadRequest, &SendResponse{Error: err.Error()})
+		return
+	}
+
+	response, err := h.send(&request)
+	if err != nil {
+		response.Error = err.Error()
+		h.reply(w, http.StatusBadGateway, response)
+		return
+	}
+	h.reply(w, http.StatusOK, response)
+}
+
+// validate checks a request before sending it
+func (h *Handler) validate(request *SendRequest) error {
+	if request.ConversationID == "" {
+		return fmt.Errorf("conversation_id is required")
+	}
+	if request.Text == "" && request.Image == "" {
+		return fmt.Errorf("text or image is required")
+	}
+	if request.Image != "" && !h.AllowFiles && hangups.IsImageFile(request.Image) {
+		return fmt.Errorf("image files are not allowed")
+	}
+	return nil
+}
+
+// send sends the text and the image of a request
+func (h *Handler) send(request *SendRequest) (*SendResponse, error) {
+	response := &SendResponse{ConversationID: request.ConversationID}
+	if request.Text != "" {
+		var options []hangups.MessageOption
+		if request.Action {
+			options = append(options, hangups.WithAction())
+		}
+		sent, err := h.Client.Send(request.ConversationID, request.Text, options...)
+		if err != nil {
+			return response, err
+		}
+		response.ConversationID = sent.ConversationID
+		response.EventID = sent.Event.GetEventId()
+	}
+	if request.Image != "" {
+		if err := h.Client.SendImage(request.ConversationID, request.Image); err != nil {
+			return response, err
+		}
+	}
+	return response, nil
+}
+
+// reply writes a JSON response
+func (h *Handler) reply(w http.ResponseWriter, status int, response *SendResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
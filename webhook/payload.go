@@ -0,0 +1,94 @@
+// Package webhook relays hangouts events to HTTP endpoints and sends messages posted to an HTTP endpoint,
+// so that tools which cannot link Go can integrate with hangouts.
+//
+// Every incoming event is posted as a Payload, JSON encoded:
+//
+//	{
+//	  "version": 1,
+//	  "delivery_id": "UgwXYZ...:7-H0Z7-Fn5Fj7-H0Z7",
+//	  "conversation": {"id": "UgwXYZ...", "name": "ops", "type": "GROUP"},
+//	  "sender": {"gaia_id": "1234", "name": "Jane Doe", "first_name": "Jane",
+//	             "photo_url": "https://...", "emails": ["jane@example.com"]},
+//	  "event": {"id": "7-H0Z7-Fn5Fj7-H0Z7", "kind": "message", "type": "REGULAR_CHAT_MESSAGE",
+//	            "timestamp": "2020-06-01T12:00:00Z", "sender_id": "1234", "text": "hello",
+//	            "segments": [{"type": "TEXT", "text": "hello"}],
+//	            "attachments": [{"type": "photo", "url": "https://..."}]}
+//	}
+//
+// The event has the schema of export.ArchiveEvent. Requests carry the headers:
+//
+//	X-Hangups-Delivery   the delivery id, the same on retries
+//	X-Hangups-Timestamp  unix seconds when the request was signed
+//	X-Hangups-Signature  "sha256=" + hex HMAC-SHA256 of timestamp + "." + body, keyed with the secret
+package webhook
+
+import (
+	"strings"
+
+	"github.com/mysqto/hangups/export"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+// PayloadVersion is the version of the payload schema, it changes only on incompatible changes
+const PayloadVersion = 1
+
+// Payload is the body posted to the endpoints for every incoming event
+type Payload struct {
+	Version      int                  `json:"version"`
+	DeliveryID   string               `json:"delivery_id"`
+	Conversation *Conversation        `json:"conversation"`
+	Sender       *Sender              `json:"sender"`
+	Event        *export.ArchiveEvent `json:"event"`
+}
+
+// Conversation is the conversation an event belongs to
+type Conversation struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"` // ONE_TO_ONE or GROUP
+}
+
+// Sender is the user who caused an event
+type Sender struct {
+	GaiaID    string   `json:"gaia_id"`
+	Name      string   `json:"name,omitempty"`
+	FirstName string   `json:"first_name,omitempty"`
+	PhotoURL  string   `json:"photo_url,omitempty"`
+	Emails    []string `json:"emails,omitempty"`
+}
+
+// newSender converts an entity, falling back to the participant data of the conversation
+func newSender(gaiaID string, entity *hangouts.Entity, conversation *hangouts.Conversation) *Sender {
+	sender := &Sender{GaiaID: gaiaID}
+	if properties := entity.GetProperties(); properties != nil {
+		sender.Name = properties.GetDisplayName()
+		sender.FirstName = properties.GetFirstName()
+		sender.PhotoURL = properties.GetPhotoUrl()
+		sender.Emails = properties.GetEmail()
+	}
+	if sender.Name == "" {
+		for _, participant := range conversation.GetParticipantData() {
+			if participant.GetId().GetGaiaId() == gaiaID {
+				sender.Name = participant.GetFallbackName()
+			}
+		}
+	}
+	return sender
+}
+
+// NewPayload creates the payload of an event, entity may be nil
+func NewPayload(conversation *hangouts.Conversation, event *hangouts.Event, entity *hangouts.Entity) *Payload {
+	archiveEvent := export.NewArchiveEvent(event)
+	conversationID := event.GetConversationId().GetId()
+	payload := &Payload{
+		Version:      PayloadVersion,
+		DeliveryID:   conversationID + ":" + event.GetEventId(),
+		Conversation: &Conversation{ID: conversationID, Name: conversation.GetName()},
+		Sender:       newSender(archiveEvent.SenderID, entity, conversation),
+		Event:        archiveEvent,
+	}
+	if conversation != nil && conversation.Type != nil {
+		payload.Conversation.Type = strings.TrimPrefix(conversation.GetType().String(), "CONVERSATION_TYPE_")
+	}
+	return payload
+}
@@ -0,0 +1,197 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mysqto/hangups"
+	hangouts "github.com/mysqto/hangups/proto"
+)
+
+const (
+	defaultMaxRetries = 5
+	defaultRetryDelay = time.Second
+	maxRetryDelay     = 5 * time.Minute
+	defaultTimeout    = 30 * time.Second
+)
+
+// Relay posts every incoming event to the endpoints.
+// Failed posts are retried with exponential backoff, each endpoint receives the events in order.
+type Relay struct {
+	Client     *hangups.Client
+	Endpoints  []string
+	Secret     []byte        // signs the requests, unsigned if empty
+	MaxRetries int           // 5 if not set
+	RetryDelay time.Duration // first retry delay, doubled on every retry, 1s if not set
+	HTTPClient *http.Client  // a client with a 30s timeout if nil
+	// IncludeOwn also relays the events of the current user, messages sent through the client are skipped anyway
+	IncludeOwn bool
+	// OnError is called for failed syncs and for posts given up on, optional
+	OnError func(err error)
+
+	mu       sync.Mutex
+	entities map[string]*hangouts.Entity
+}
+
+// NewRelay creates a Relay posting the events of client to endpoints
+func NewRelay(client *hangups.Client, endpoints ...string) *Relay {
+	return &Relay{Client: client, Endpoints: endpoints}
+}
+
+// Run relays events until ctx is done
+func (r *Relay) Run(ctx context.Context) error {
+	queues := make([]chan *Payload, len(r.Endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range r.Endpoints {
+		queues[i] = make(chan *Payload, 100)
+		wg.Add(1)
+		go func(endpoint string, queue chan *Payload) {
+			defer wg.Done()
+			for payload := range queue {
+				if err := r.Post(ctx, endpoint, payload); err != nil && ctx.Err() == nil {
+					r.error(err)
+				}
+			}
+		}(endpoint, queues[i])
+	}
+
+	poller := hangups.NewPoller(r.Client)
+	poller.OnError = r.OnError
+	err := poller.Run(ctx, func(conversation *hangouts.Conversation, event *hangouts.Event) {
+		senderID := event.GetSenderId().GetGaiaId()
+		if !r.IncludeOwn && senderID == poller.SelfID() {
+			return
+		}
+		payload := NewPayload(conversation, event, r.entity(senderID))
+		for _, queue := range queues {
+			select {
+			case queue <- payload:
+			case <-ctx.Done():
+			}
+		}
+	})
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+	return err
+}
+
+// Post sends a payload to an endpoint, retrying network errors, 429 and 5xx responses
+func (r *Relay) Post(ctx context.Context, endpoint string, payload *Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	maxRetries := r.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultMaxRetries
+	}
+	delay := r.RetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = r.post(ctx, endpoint, payload.DeliveryID, body)
+		if err == nil || !retry || attempt >= maxRetries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error posting %s to %s : %v", payload.DeliveryID, endpoint, err)
+	}
+	return nil
+}
+
+// post makes a single request and reports if a failure is worth retrying
+func (r *Relay) post(ctx context.Context, endpoint, deliveryID string, body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(HeaderDelivery, deliveryID)
+	if len(r.Secret) > 0 {
+		SignRequest(request, r.Secret, body)
+	}
+
+	httpClient := r.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected status %v", response.Status)
+}
+
+// entity looks up the sender of an event, lookups are cached and failures give nil
+func (r *Relay) entity(gaiaID string) *hangouts.Entity {
+	if gaiaID == "" {
+		return nil
+	}
+	r.mu.Lock()
+	entity, ok := r.entities[gaiaID]
+	r.mu.Unlock()
+	if ok {
+		return entity
+	}
+
+	response, err := r.Client.GetEntityByID([]string{gaiaID})
+	if err == nil {
+		err = hangups.CheckResponseHeader(response.GetResponseHeader())
+	}
+	if err != nil {
+		r.error(err)
+		return nil
+	}
+	for _, result := range response.GetEntityResult() {
+		for _, e := range result.GetEntity() {
+			entity = e
+		}
+	}
+
+	r.mu.Lock()
+	if r.entities == nil {
+		r.entities = make(map[string]*hangouts.Entity)
+	}
+	r.entities[gaiaID] = entity
+	r.mu.Unlock()
+	return entity
+}
+
+// error reports an error to OnError
+func (r *Relay) error(err error) {
+	if r.OnError != nil {
+		r.OnError(err)
+	}
+}
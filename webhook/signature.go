@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headers of signed requests
+const (
+	HeaderDelivery  = "X-Hangups-Delivery"
+	HeaderTimestamp = "X-Hangups-Timestamp"
+	HeaderSignature = "X-Hangups-Signature"
+)
+
+// signaturePrefix names the algorithm of the signature header
+const signaturePrefix = "sha256="
+
+// defaultMaxSkew is how far the timestamp of a signed request may be off
+const defaultMaxSkew = 5 * time.Minute
+
+// ErrBadSignature is returned by Verify for requests without a valid signature
+var ErrBadSignature = errors.New("invalid webhook signature")
+
+// Sign computes the signature header value of a body signed at timestamp (unix seconds)
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest sets the timestamp and signature headers of a request with body
+func SignRequest(request *http.Request, secret []byte, body []byte) {
+	timestamp := time.Now().Unix()
+	request.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(HeaderSignature, Sign(secret, timestamp, body))
+}
+
+// Verify checks the signature headers of a request with body, the timestamp may be off by maxSkew, 5 minutes if 0
+func Verify(header http.Header, secret []byte, body []byte, maxSkew time.Duration) error {
+	if maxSkew <= 0 {
+		maxSkew = defaultMaxSkew
+	}
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	skew := time.Since(time.Unix(timestamp, 0))
+	if skew < -maxSkew || skew > maxSkew {
+		return ErrBadSignature
+	}
+
+	signature := header.Get(HeaderSignature)
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return ErrBadSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return ErrBadSignature
+	}
+	return nil
+}